@@ -0,0 +1,263 @@
+// Package acmetest provides an in-process ACME CA for tests.
+//
+// The CA is Pebble (https://github.com/letsencrypt/pebble), the test CA of
+// Let's Encrypt. Its validation authority resolves DNS-01 challenges with an
+// in-process name server, which is authoritative for a single zone and accepts
+// TSIG signed dynamic updates (RFC 2136), so challenges can be solved with the
+// rfc2136 DNS provider without leaving the machine.
+package acmetest
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/pebble/v2/ca"
+	"github.com/letsencrypt/pebble/v2/db"
+	"github.com/letsencrypt/pebble/v2/va"
+	"github.com/letsencrypt/pebble/v2/wfe"
+	"github.com/miekg/dns"
+)
+
+// TSIGKeyName is the name of the TSIG key updates must be signed with.
+const TSIGKeyName = "acmetest."
+
+// Server is an in-process ACME CA and the name server its validation
+// authority resolves challenges with. Its zero value is not usable, create
+// one with [NewServer].
+type Server struct {
+	// DirectoryURL is the URL of the ACME directory.
+	DirectoryURL string
+	// DNSAddr is the UDP and TCP address of the name server.
+	DNSAddr string
+	// Zone is the fully qualified zone the name server is authoritative for.
+	Zone string
+	// TSIGKey is the base64 encoded secret of the TSIG key [TSIGKeyName].
+	TSIGKey string
+
+	ca  *ca.CAImpl
+	srv *httptest.Server
+	dns []*dns.Server
+
+	mu  sync.Mutex
+	txt map[string][]string
+}
+
+// NewServer starts a CA issuing certificates for names in zone. It sets the
+// environment Pebble is configured with, so it cannot be used by parallel
+// tests. The server is closed when the test ends.
+func NewServer(tb testing.TB, zone string) *Server {
+	tb.Helper()
+
+	// validate right away and accept every nonce, so clients do not need to
+	// retry
+	tb.Setenv("PEBBLE_VA_NOSLEEP", "1")
+	tb.Setenv("PEBBLE_WFE_NONCEREJECT", "0")
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		tb.Fatalf("generating TSIG key: %v", err)
+	}
+	s := &Server{
+		Zone:    dns.Fqdn(zone),
+		TSIGKey: base64.StdEncoding.EncodeToString(secret),
+		txt:     map[string][]string{},
+	}
+	tb.Cleanup(s.Close)
+
+	if err := s.startDNS(); err != nil {
+		tb.Fatalf("starting name server: %v", err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	store := db.NewMemoryStore()
+	s.ca = ca.New(logger, store, "", "ecdsa", 0, 1, map[string]ca.Profile{
+		"default": {Description: "The default profile"},
+	})
+	authority := va.New(logger, 0, 0, false, s.DNSAddr, store)
+	frontend := wfe.New(logger, store, authority, s.ca, []string{"acmetest"}, false, false, 0, 0)
+
+	s.srv = httptest.NewTLSServer(frontend.Handler())
+	s.DirectoryURL = s.srv.URL + wfe.DirectoryPath
+
+	return s
+}
+
+// Close shuts the CA and the name server down.
+func (s *Server) Close() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+	for _, srv := range s.dns {
+		_ = srv.Shutdown()
+	}
+}
+
+// CABundle returns the PEM encoded certificate the directory is served with.
+func (s *Server) CABundle() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.srv.Certificate().Raw})
+}
+
+// Roots returns the roots of the certificates the CA issues.
+func (s *Server) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for i := range s.ca.GetNumberOfRootCerts() {
+		pool.AddCert(s.ca.GetRootCert(i).Cert)
+	}
+
+	return pool
+}
+
+// TXT returns the TXT records of name.
+func (s *Server) TXT(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.txt[dns.CanonicalName(name)])
+}
+
+// startDNS starts the name server on a UDP and a TCP listener of the same
+// port, Pebble resolves with TCP while certmagic checks propagation with UDP.
+func (s *Server) startDNS() error {
+	const attempts = 10
+
+	var err error
+	for range attempts {
+		var pc net.PacketConn
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("listen udp: %w", err)
+		}
+		var l net.Listener
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			// the port is taken for TCP, try another one
+			pc.Close()
+			continue
+		}
+
+		s.DNSAddr = pc.LocalAddr().String()
+		s.serveDNS(&dns.Server{PacketConn: pc}, &dns.Server{Listener: l})
+
+		return nil
+	}
+
+	return fmt.Errorf("listen tcp: %w", err)
+}
+
+func (s *Server) serveDNS(servers ...*dns.Server) {
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		srv.TsigSecret = map[string]string{TSIGKeyName: s.TSIGKey}
+		srv.Handler = dns.HandlerFunc(s.handleDNS)
+		srv.MsgAcceptFunc = func(dh dns.Header) dns.MsgAcceptAction {
+			if int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+				return dns.MsgAccept
+			}
+			return dns.DefaultMsgAcceptFunc(dh)
+		}
+		wg.Add(1)
+		srv.NotifyStartedFunc = wg.Done
+
+		s.dns = append(s.dns, srv)
+		go func() { _ = srv.ActivateAndServe() }()
+	}
+	wg.Wait()
+}
+
+func (s *Server) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	switch {
+	case len(req.Question) != 1:
+		resp.Rcode = dns.RcodeFormatError
+	case req.Opcode == dns.OpcodeUpdate:
+		s.update(w, req, resp)
+	case !dns.IsSubDomain(s.Zone, dns.CanonicalName(req.Question[0].Name)):
+		resp.Rcode = dns.RcodeRefused
+	default:
+		s.answer(req.Question[0], resp)
+	}
+
+	_ = w.WriteMsg(resp)
+}
+
+func (s *Server) answer(q dns.Question, resp *dns.Msg) {
+	name := dns.CanonicalName(q.Name)
+	switch {
+	case q.Qtype == dns.TypeSOA && name == s.Zone:
+		resp.Answer = append(resp.Answer, &dns.SOA{
+			Hdr:     dns.RR_Header{Name: s.Zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+			Ns:      "ns." + s.Zone,
+			Mbox:    "hostmaster." + s.Zone,
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: 60,
+			Retry:   60,
+			Expire:  60,
+			Minttl:  60,
+		})
+	case q.Qtype == dns.TypeTXT:
+		for _, value := range s.TXT(name) {
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: splitTXT(value),
+			})
+		}
+	}
+}
+
+// update applies a TSIG signed dynamic update of the TXT records in the zone.
+func (s *Server) update(w dns.ResponseWriter, req *dns.Msg, resp *dns.Msg) {
+	tsig := req.IsTsig()
+	switch {
+	case tsig == nil || w.TsigStatus() != nil:
+		resp.Rcode = dns.RcodeNotAuth
+		return
+	case dns.CanonicalName(req.Question[0].Name) != s.Zone:
+		resp.Rcode = dns.RcodeNotZone
+	default:
+		s.mu.Lock()
+		for _, rr := range req.Ns {
+			txt, ok := rr.(*dns.TXT)
+			if !ok {
+				continue
+			}
+			name, value := dns.CanonicalName(txt.Hdr.Name), strings.Join(txt.Txt, "")
+			switch txt.Hdr.Class {
+			case dns.ClassINET:
+				s.txt[name] = append(s.txt[name], value)
+			case dns.ClassNONE:
+				s.txt[name] = slices.DeleteFunc(s.txt[name], func(v string) bool { return v == value })
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+}
+
+// splitTXT splits text into the 255 byte strings a TXT record is made of.
+func splitTXT(text string) []string {
+	const maxLen = 255
+
+	var parts []string
+	for len(text) > maxLen {
+		parts = append(parts, text[:maxLen])
+		text = text[maxLen:]
+	}
+
+	return append(parts, text)
+}
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// errNoIssuers is returned when a certificate is requested from a
+// [certmagic.Config] without any issuers.
+var errNoIssuers = errors.New("no issuers configured")
+
+// certmagic manages one certificate per name. To issue a single certificate
+// for a set of names, the functions below drive the issuers directly and keep
+// the result in the same storage layout certmagic uses, keyed by the sorted,
+// comma-separated SAN set. For a single name this is the key certmagic itself
+// uses, so existing certificates are picked up.
+
+// namesKey returns the storage key for the certificate covering names.
+func namesKey(names []string) string {
+	res := certmagic.CertificateResource{SANs: slices.Clone(names)}
+	return res.NamesKey()
+}
+
+// obtainCertificate returns a certificate covering exactly names. A stored
+// certificate is reused unless it is due for renewal or its key is not of the
+// configured type, otherwise a new one is obtained from the first issuer that
+// succeeds.
+func (c cmd) obtainCertificate(ctx context.Context, magic *certmagic.Config, names []string) (certmagic.Certificate, error) {
+	cert, issuer, err := loadCertificate(ctx, magic, names)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		c.CertLogger.Info("no certificate in storage", zap.Strings("names", names))
+	case err != nil:
+		return cert, err
+	case !c.needsRenewal(ctx, magic, issuer, cert):
+		return cert, nil
+	}
+
+	unlock, err := c.lockIssuance(ctx, magic, names)
+	if err != nil {
+		return certmagic.Certificate{}, err
+	}
+	defer unlock()
+
+	// another instance sharing the storage may have renewed it while we waited
+	if stored, issuer, err := loadCertificate(ctx, magic, names); err == nil {
+		if !stored.Leaf.Equal(cert.Leaf) && !c.needsRenewal(ctx, magic, issuer, stored) {
+			return stored, nil
+		}
+		cert = stored
+	}
+
+	return c.issueCertificate(ctx, magic, names, cert)
+}
+
+// renewCertificate obtains a new certificate covering exactly names, even if
+// the stored one is not due for renewal yet.
+func (c cmd) renewCertificate(ctx context.Context, magic *certmagic.Config, names []string) (certmagic.Certificate, error) {
+	unlock, err := c.lockIssuance(ctx, magic, names)
+	if err != nil {
+		return certmagic.Certificate{}, err
+	}
+	defer unlock()
+
+	previous, _, err := loadCertificate(ctx, magic, names)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return previous, err
+	}
+	c.CertLogger.Info("forcing renewal", zap.Strings("names", names))
+
+	return c.issueCertificate(ctx, magic, names, previous)
+}
+
+// lockIssuance acquires the storage lock for issuing the certificate covering
+// names, so instances sharing the storage do not issue it twice. The returned
+// function releases it.
+func (c cmd) lockIssuance(ctx context.Context, magic *certmagic.Config, names []string) (func(), error) {
+	lockKey := "issue_cert_" + namesKey(names)
+	if err := magic.Storage.Lock(ctx, lockKey); err != nil {
+		return nil, fmt.Errorf("acquiring lock %q: %w", lockKey, err)
+	}
+
+	return func() {
+		if err := magic.Storage.Unlock(context.WithoutCancel(ctx), lockKey); err != nil {
+			c.CertLogger.Error("releasing lock", zap.String("lock", lockKey), zap.Error(err))
+		}
+	}, nil
+}
+
+// needsRenewal reports whether cert is due for renewal, by its expiry or the
+// renewal window its CA suggests, or its key does not match the configured
+// key type.
+func (c cmd) needsRenewal(ctx context.Context, magic *certmagic.Config, issuer certmagic.Issuer, cert certmagic.Certificate) bool {
+	if cert.NeedsRenewal(magic) {
+		return true
+	}
+	if ri := c.renewalInfo(ctx, magic, issuer, cert); ri.ARI != nil && !time.Now().Before(ri.ARI.SelectedTime) {
+		c.CertLogger.Info("renewal suggested by CA",
+			zap.Strings("names", cert.Names),
+			zap.Time("window_start", ri.ARI.SuggestedWindow.Start),
+			zap.Time("window_end", ri.ARI.SuggestedWindow.End),
+			zap.String("explanation_url", ri.ARI.ExplanationURL),
+		)
+		return true
+	}
+	if keyTypeChanged(magic, cert) {
+		c.CertLogger.Info("key type changed, renewing certificate",
+			zap.Strings("names", cert.Names),
+			zap.String("key_type", string(keyTypeOf(cert.PrivateKey))),
+			zap.String("configured", string(configuredKeyType(magic))),
+		)
+		return true
+	}
+
+	return false
+}
+
+// configuredKeyType returns the key type new keys are generated with, or an
+// empty string if the key source does not tell.
+func configuredKeyType(magic *certmagic.Config) certmagic.KeyType {
+	if kg, ok := magic.KeySource.(certmagic.StandardKeyGenerator); ok {
+		return kg.KeyType
+	}
+
+	return ""
+}
+
+// keyTypeChanged reports whether a key type is configured that differs from
+// the key of cert.
+func keyTypeChanged(magic *certmagic.Config, cert certmagic.Certificate) bool {
+	want := configuredKeyType(magic)
+	return want != "" && keyTypeOf(cert.PrivateKey) != want
+}
+
+// keyTypeOf returns the [certmagic.KeyType] of key, or an empty string if it
+// is none of them.
+func keyTypeOf(key crypto.PrivateKey) certmagic.KeyType {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return certmagic.ED25519
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return certmagic.P256
+		case elliptic.P384():
+			return certmagic.P384
+		}
+	case *rsa.PrivateKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return certmagic.RSA2048
+		case 4096:
+			return certmagic.RSA4096
+		case 8192:
+			return certmagic.RSA8192
+		}
+	}
+
+	return ""
+}
+
+// loadCertificate returns the newest certificate covering names that any of
+// the configured issuers has in storage, along with that issuer. It returns an
+// error wrapping [fs.ErrNotExist] if there is none.
+func loadCertificate(ctx context.Context, magic *certmagic.Config, names []string) (certmagic.Certificate, certmagic.Issuer, error) {
+	key := namesKey(names)
+
+	var (
+		newest       certmagic.Certificate
+		newestIssuer certmagic.Issuer
+	)
+	for _, issuer := range magic.Issuers {
+		certPEM, err := magic.Storage.Load(ctx, certmagic.StorageKeys.SiteCert(issuer.IssuerKey(), key))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return certmagic.Certificate{}, nil, fmt.Errorf("loading certificate for %q: %w", key, err)
+		}
+		keyPEM, err := magic.Storage.Load(ctx, certmagic.StorageKeys.SitePrivateKey(issuer.IssuerKey(), key))
+		if err != nil {
+			return certmagic.Certificate{}, nil, fmt.Errorf("loading private key for %q: %w", key, err)
+		}
+
+		cert, err := parseCertificate(certPEM, keyPEM)
+		if err != nil {
+			return certmagic.Certificate{}, nil, fmt.Errorf("certificate for %q from %s: %w", key, issuer.IssuerKey(), err)
+		}
+		if newest.Empty() || cert.Leaf.NotBefore.After(newest.Leaf.NotBefore) {
+			newest, newestIssuer = cert, issuer
+		}
+	}
+	if newest.Empty() {
+		return newest, nil, fmt.Errorf("certificate for %q: %w", key, fs.ErrNotExist)
+	}
+
+	return newest, newestIssuer, nil
+}
+
+// issueCertificate obtains a new certificate for names, trying each issuer in
+// order, and saves it to storage. The key of previous, the certificate being
+// renewed, is reused if the configuration asks for it and its type still
+// matches.
+func (c cmd) issueCertificate(ctx context.Context, magic *certmagic.Config, names []string, previous certmagic.Certificate) (certmagic.Certificate, error) {
+	if len(magic.Issuers) == 0 {
+		return certmagic.Certificate{}, errNoIssuers
+	}
+
+	var privateKey crypto.PrivateKey
+	if magic.ReusePrivateKeys && !previous.Empty() && !keyTypeChanged(magic, previous) {
+		c.CertLogger.Info("reusing private key", zap.Strings("names", names))
+		privateKey = previous.PrivateKey
+	} else {
+		var err error
+		privateKey, err = magic.KeySource.GenerateKey()
+		if err != nil {
+			return certmagic.Certificate{}, fmt.Errorf("generating private key: %w", err)
+		}
+	}
+	keyPEM, err := certmagic.PEMEncodePrivateKey(privateKey)
+	if err != nil {
+		return certmagic.Certificate{}, fmt.Errorf("encoding private key: %w", err)
+	}
+	csr, err := newCSR(privateKey, names)
+	if err != nil {
+		return certmagic.Certificate{}, err
+	}
+
+	var errs []error
+	for _, issuer := range magic.Issuers {
+		logger := c.CertLogger.With(zap.Strings("names", names), zap.String("issuer", issuer.IssuerKey()))
+
+		if prechecker, ok := issuer.(certmagic.PreChecker); ok {
+			if err := prechecker.PreCheck(ctx, names, true); err != nil {
+				c.metrics.renewal(issuer.IssuerKey(), err)
+				logger.Warn("issuer precheck failed", zap.Error(err))
+				errs = append(errs, fmt.Errorf("%s: %w", issuer.IssuerKey(), err))
+				continue
+			}
+		}
+
+		logger.Info("obtaining certificate")
+		issued, err := issuer.Issue(ctx, csr)
+		c.metrics.renewal(issuer.IssuerKey(), err)
+		if err != nil {
+			logger.Warn("could not get certificate from issuer", zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", issuer.IssuerKey(), err))
+			continue
+		}
+
+		cert, err := parseCertificate(issued.Certificate, keyPEM)
+		if err != nil {
+			return cert, fmt.Errorf("certificate from %s: %w", issuer.IssuerKey(), err)
+		}
+		if err := saveCertificate(ctx, magic, issuer, names, issued, keyPEM); err != nil {
+			return cert, err
+		}
+		logger.Info("certificate obtained", zap.Time("not_after", cert.Leaf.NotAfter))
+
+		return cert, nil
+	}
+
+	return certmagic.Certificate{}, fmt.Errorf("obtaining certificate for %q: %w", names, errors.Join(errs...))
+}
+
+// saveCertificate writes the issued certificate, its key and metadata to
+// storage in the layout certmagic uses.
+func saveCertificate(ctx context.Context, magic *certmagic.Config, issuer certmagic.Issuer, names []string, issued *certmagic.IssuedCertificate, keyPEM []byte) error {
+	metadata, err := json.Marshal(issued.Metadata)
+	if err != nil {
+		return fmt.Errorf("encoding certificate metadata: %w", err)
+	}
+	res := certmagic.CertificateResource{SANs: slices.Clone(names), IssuerData: metadata}
+	meta, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding certificate resource: %w", err)
+	}
+
+	key := res.NamesKey()
+	for _, kv := range []struct {
+		key   string
+		value []byte
+	}{
+		{certmagic.StorageKeys.SitePrivateKey(issuer.IssuerKey(), key), keyPEM},
+		{certmagic.StorageKeys.SiteCert(issuer.IssuerKey(), key), issued.Certificate},
+		{certmagic.StorageKeys.SiteMeta(issuer.IssuerKey(), key), meta},
+	} {
+		if err := magic.Storage.Store(ctx, kv.key, kv.value); err != nil {
+			return fmt.Errorf("storing %s: %w", kv.key, err)
+		}
+	}
+
+	return nil
+}
+
+// newCSR creates a certificate request for names signed by privateKey. The
+// first name is used as the common name, as some CAs still require one.
+func newCSR(privateKey crypto.PrivateKey, names []string) (*x509.CertificateRequest, error) {
+	tmpl := &x509.CertificateRequest{DNSNames: names}
+	if len(names) > 0 && len(names[0]) <= 64 {
+		tmpl.Subject = pkix.Name{CommonName: names[0]}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate request: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate request: %w", err)
+	}
+
+	return csr, nil
+}
+
+// parseCertificate builds a [certmagic.Certificate] with its leaf from a PEM
+// encoded chain and private key.
+func parseCertificate(certPEM, keyPEM []byte) (certmagic.Certificate, error) {
+	tlsCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return certmagic.Certificate{}, fmt.Errorf("parsing key pair: %w", err)
+	}
+	if tlsCert.Leaf == nil {
+		tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			return certmagic.Certificate{}, fmt.Errorf("parsing leaf certificate: %w", err)
+		}
+	}
+
+	return certmagic.Certificate{Certificate: tlsCert, Names: tlsCert.Leaf.DNSNames}, nil
+}
+
+// sameNames reports whether a and b contain the same DNS names, ignoring order
+// and case.
+func sameNames(a, b []string) bool {
+	normalize := func(names []string) []string {
+		out := make([]string, 0, len(names))
+		for _, name := range names {
+			out = append(out, strings.ToLower(name))
+		}
+		slices.Sort(out)
+		return slices.Compact(out)
+	}
+
+	return slices.Equal(normalize(a), normalize(b))
+}
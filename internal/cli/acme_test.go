@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// testIssuer is a [certmagic.Issuer] that signs every CSR with a throwaway CA.
+type testIssuer struct {
+	key    *ecdsa.PrivateKey
+	ca     *x509.Certificate
+	issued atomic.Int32
+	// lifetime of issued certificates, defaults to 90 days.
+	lifetime time.Duration
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA: %v", err)
+	}
+
+	return &testIssuer{key: key, ca: ca}
+}
+
+func (ti *testIssuer) Issue(_ context.Context, csr *x509.CertificateRequest) (*certmagic.IssuedCertificate, error) {
+	lifetime := ti.lifetime
+	if lifetime == 0 {
+		lifetime = 90 * 24 * time.Hour
+	}
+
+	serial := ti.issued.Add(1)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(serial) + 1),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ti.ca, csr.PublicKey, ti.key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ti.ca.Raw})...)
+
+	return &certmagic.IssuedCertificate{Certificate: chain}, nil
+}
+
+func (*testIssuer) IssuerKey() string { return "test-issuer" }
+
+func newTestMagic(t *testing.T, issuers ...certmagic.Issuer) *certmagic.Config {
+	t.Helper()
+
+	magic := certmagic.New(certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) { return nil, nil },
+		Logger:           zap.NewNop(),
+	}), certmagic.Config{
+		Storage: &certmagic.FileStorage{Path: t.TempDir()},
+		Logger:  zap.NewNop(),
+	})
+	magic.Issuers = issuers
+
+	return magic
+}
+
+func newTestCmd() cmd {
+	logger := zap.NewNop()
+	return cmd{CertLogger: logger, ScaleLogger: logger, CLILogger: logger, Out: io.Discard, BuildInfo: &BuildInfo{}}
+}
+
+func Test_obtainCertificate(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com", "nas.lan.example.com", "*.nas.example.com"}
+
+	cert, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if !sameNames(cert.Leaf.DNSNames, names) {
+		t.Errorf("obtainCertificate() SANs = %v, want %v", cert.Leaf.DNSNames, names)
+	}
+
+	// the certificate is fresh, so the second call loads it from storage
+	reversed := slices.Clone(names)
+	slices.Reverse(reversed)
+	again, err := newTestCmd().obtainCertificate(t.Context(), magic, reversed)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if !again.Leaf.Equal(cert.Leaf) {
+		t.Error("obtainCertificate() issued a new certificate instead of reusing the stored one")
+	}
+	if got := issuer.issued.Load(); got != 1 {
+		t.Errorf("issuer called %d times, want 1", got)
+	}
+}
+
+func Test_obtainCertificate_renew(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	issuer.lifetime = 2 * time.Minute // well inside the renewal window
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com"}
+
+	first, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	second, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if second.Leaf.Equal(first.Leaf) {
+		t.Error("obtainCertificate() did not renew a certificate due for renewal")
+	}
+}
+
+func Test_obtainCertificate_keyType(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	magic := newTestMagic(t, issuer)
+	magic.KeySource = certmagic.StandardKeyGenerator{KeyType: certmagic.P256}
+	names := []string{"nas.example.com"}
+
+	first, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if got := keyTypeOf(first.PrivateKey); got != certmagic.P256 {
+		t.Fatalf("obtainCertificate() key type = %s, want %s", got, certmagic.P256)
+	}
+
+	// the stored certificate is fresh, but its key no longer matches
+	magic.KeySource = certmagic.StandardKeyGenerator{KeyType: certmagic.P384}
+	second, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if got := keyTypeOf(second.PrivateKey); got != certmagic.P384 {
+		t.Errorf("obtainCertificate() key type = %s, want %s", got, certmagic.P384)
+	}
+	if got := issuer.issued.Load(); got != 2 {
+		t.Errorf("issuer called %d times, want 2", got)
+	}
+}
+
+func Test_obtainCertificate_reusePrivateKeys(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	issuer.lifetime = 2 * time.Minute // well inside the renewal window
+	magic := newTestMagic(t, issuer)
+	magic.KeySource = certmagic.StandardKeyGenerator{KeyType: certmagic.P256}
+	magic.ReusePrivateKeys = true
+	names := []string{"nas.example.com"}
+
+	first, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	second, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if second.Leaf.Equal(first.Leaf) {
+		t.Fatal("obtainCertificate() did not renew a certificate due for renewal")
+	}
+	if !second.Leaf.PublicKey.(*ecdsa.PublicKey).Equal(first.Leaf.PublicKey) {
+		t.Error("obtainCertificate() did not reuse the private key")
+	}
+}
+
+func Test_renewCertificate(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com"}
+
+	first, err := newTestCmd().renewCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("renewCertificate() error = %v", err)
+	}
+	second, err := newTestCmd().renewCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("renewCertificate() error = %v", err)
+	}
+	if second.Leaf.Equal(first.Leaf) {
+		t.Error("renewCertificate() did not renew a certificate that is not due")
+	}
+	stored, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if !stored.Leaf.Equal(second.Leaf) {
+		t.Error("obtainCertificate() did not return the renewed certificate")
+	}
+	if got := issuer.issued.Load(); got != 2 {
+		t.Errorf("issuer called %d times, want 2", got)
+	}
+}
+
+func Test_sameNames(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{"equal", []string{"a.example.com", "b.example.com"}, []string{"a.example.com", "b.example.com"}, true},
+		{"order", []string{"b.example.com", "a.example.com"}, []string{"a.example.com", "b.example.com"}, true},
+		{"case", []string{"A.example.com"}, []string{"a.example.com"}, true},
+		{"subset", []string{"a.example.com"}, []string{"a.example.com", "b.example.com"}, false},
+		{"wildcard", []string{"*.example.com"}, []string{"a.example.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := sameNames(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameNames(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,31 +5,24 @@ package cli
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/caddyserver/certmagic"
-	"github.com/libdns/acmedns"
-	"github.com/libdns/cloudflare"
 	"github.com/mholt/acmez/v3/acme"
-	flag "github.com/spf13/pflag"
-	"github.com/thde/truenas-scale-acme/internal/cron"
+	"github.com/thde/truenas-scale-acme/internal/execdns"
+	"github.com/thde/truenas-scale-acme/internal/notify"
 	"github.com/thde/truenas-scale-acme/internal/truenas"
 	"github.com/thde/truenas-scale-acme/internal/zerossl"
 	"go.uber.org/zap"
 )
 
-var (
-	flagConfigPath = flag.String("config", defaultConfigPath(), "Configuration path")
-	flagDaemon     = flag.Bool("daemon", false, "Run in daemon mode")
-	flagSchedule   = flag.String("schedule", "22 22 * * *", "Cron schedule, if daemon mode is enabled")
-	flagHelp       = flag.BoolP("help", "h", false, "Print help message")
-	flagVersion    = flag.BoolP("version", "v", false, "Print version information")
-)
-
 const (
 	defaultURL = "ws://localhost/api/current"
 )
@@ -49,7 +42,10 @@ var (
 		},
 	}
 	exampleConfig = Config{
-		Domain: "nas.domain.local",
+		Certificates: []CertificateConfig{{
+			Domains: []string{"nas.domain.local", "*.nas.domain.local"},
+			Targets: []TargetConfig{{Type: targetUI}},
+		}},
 		API: &APIConfig{
 			APIKey:     "s3cure",
 			URL:        defaultURL,
@@ -58,14 +54,15 @@ var (
 		ACME: ACMEConfig{
 			Email:     "myemail@example.com",
 			TOSAgreed: false,
-			ACMEDNS: &acmedns.Provider{
-				Username:  "00000000-0000-0000-0000-000000000000",
-				Password:  "s3cure",
-				Subdomain: "FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF",
-				ServerURL: "https://auth.acme-dns.io",
-			},
-			Cloudflare: &cloudflare.Provider{
-				APIToken: "s3cure",
+			DNS: &DNSProviderConfig{
+				Provider: "acme-dns",
+				raw: json.RawMessage(`{
+					"provider": "acme-dns",
+					"username": "00000000-0000-0000-0000-000000000000",
+					"password": "s3cure",
+					"subdomain": "FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF",
+					"server_url": "https://auth.acme-dns.io"
+				}`),
 			},
 		},
 	}
@@ -87,58 +84,94 @@ type cmd struct {
 	CertLogger  *zap.Logger
 	ScaleLogger *zap.Logger
 	CLILogger   *zap.Logger
+	// Out receives the output of the commands reporting to the operator.
+	Out io.Writer
+	// metrics are recorded if the daemon serves them.
+	metrics *metrics
+	// report collects the events of a run for its notifications.
+	report *runReport
+	// notifier sends the events to the notification sinks.
+	notifier *notify.Notifier
 
 	*BuildInfo
 }
 
-// Run parses the command-line flags and executes the command, either once or,
-// in daemon mode, on the configured cron schedule until ctx is cancelled.
+// Run parses the command-line arguments and executes the command they name.
+// Without a command, the certificates are ensured once or, with --daemon, on
+// the configured cron schedule until ctx is cancelled.
 func Run(ctx context.Context, logger *zap.Logger, buildInfo *BuildInfo) error {
 	return cmd{
 		CertLogger:  logger.Named("certificate"),
 		ScaleLogger: logger.Named("scale"),
 		CLILogger:   logger.Named("cli"),
+		Out:         os.Stdout,
 		BuildInfo:   buildInfo,
-	}.Run(ctx)
+	}.Run(ctx, os.Args[1:])
 }
 
-func (c cmd) Run(ctx context.Context) error {
-	flag.Parse()
+func (c cmd) Run(ctx context.Context, args []string) error {
+	command, opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
 
-	if *flagHelp {
-		fmt.Printf("Usage of %s %s:\n", os.Args[0], c.Version)
-		flag.PrintDefaults()
+	if opts.help {
+		c.usage(command)
 		return nil
 	}
 
-	if *flagVersion {
-		fmt.Print(c.BuildInfo)
+	if opts.version {
+		fmt.Fprint(c.Out, c.BuildInfo)
 		return nil
 	}
 
 	c.CLILogger.Info("starting",
+		zap.String("command", command.name),
 		zap.String("version", c.Version),
 		zap.String("go", c.GoVersion),
 		zap.String("commit", c.Commit),
 		zap.String("date", c.Date),
 	)
 
-	config, err := c.loadConfig(*flagConfigPath)
+	return command.run(c, ctx, opts)
+}
+
+// config loads the configuration named by opts and applies the flags
+// overriding it.
+func (c cmd) config(opts *options) (*Config, error) {
+	config, err := c.loadConfig(opts.configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load config from %s: %w", *flagConfigPath, err)
+		return nil, fmt.Errorf("failed to load config from %s: %w", opts.configPath, err)
 	}
 	if config == nil { // if no config existed
-		return fmt.Errorf("%w at %s", errNoConfig, *flagConfigPath)
+		return nil, fmt.Errorf("%w at %s", errNoConfig, opts.configPath)
+	}
+	if opts.staging {
+		config.ACME.Staging = true
+	}
+	if opts.forceStaging {
+		config.ACME.ForceStaging = true
 	}
+	if config.ACME.Staging {
+		c.CLILogger.Warn("staging mode enabled, certificates will not be trusted")
+	}
+
+	return config, nil
+}
 
+// dial connects to the TrueNAS API of config.
+func (c cmd) dial(ctx context.Context, config *Config) (*truenas.Client, error) {
 	u, err := url.Parse(config.API.URL)
 	if err != nil {
-		return fmt.Errorf("error parsing api url %q: %w", config.API.URL, err)
+		return nil, fmt.Errorf("error parsing api url %q: %w", config.API.URL, err)
 	}
 
 	dialOpts := []truenas.Option{
 		truenas.WithURL(u),
 	}
+	if c.metrics != nil {
+		dialOpts = append(dialOpts, truenas.WithObserver(c.metrics))
+	}
 	if config.API.SkipVerify {
 		//nolint:gosec // skipping verification is what api.skip_verify explicitly opts into.
 		dialOpts = append(dialOpts, truenas.WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
@@ -146,86 +179,226 @@ func (c cmd) Run(ctx context.Context) error {
 
 	tnClient, err := truenas.Dial(ctx, config.API.APIKey, dialOpts...)
 	if err != nil {
-		return fmt.Errorf("error connecting to TrueNAS: %w", err)
+		return nil, fmt.Errorf("error connecting to TrueNAS: %w", err)
 	}
-	defer tnClient.Close()
 
-	acmeClient, err := c.acmeClient(config.ACME)
+	return tnClient, nil
+}
+
+// setup loads the configuration, connects to TrueNAS and creates the ACME
+// clients of the certificates. The returned client must be closed.
+func (c cmd) setup(ctx context.Context, opts *options) (*Config, *truenas.Client, []managedCertificate, error) {
+	config, err := c.config(opts)
 	if err != nil {
-		return fmt.Errorf("error creating ACME client: %w", err)
+		return nil, nil, nil, err
 	}
-
-	if *flagDaemon {
-		c.CLILogger.Info("daemon mode enabled", zap.String("schedule", *flagSchedule))
+	tnClient, err := c.dial(ctx, config)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-
-	err = c.ensureCertificate(ctx, config, acmeClient, tnClient)
+	certs, err := c.managedCertificates(config)
 	if err != nil {
-		return err
+		tnClient.Close()
+		return nil, nil, nil, err
 	}
-	if !*flagDaemon {
-		return nil
+
+	return config, tnClient, certs, nil
+}
+
+// nextRenewalCheck returns the earliest time one of the certificates is due
+// for renewal or needs new renewal information. It returns the zero time if
+// none is known.
+func nextRenewalCheck(certs []managedCertificate) time.Time {
+	var next time.Time
+	for _, cert := range certs {
+		check := cert.renewal.nextCheck()
+		if check.IsZero() {
+			continue
+		}
+		if next.IsZero() || check.Before(next) {
+			next = check
+		}
 	}
 
-	acmeClient.OnEvent = func(ctx context.Context, event string, _ map[string]any) error {
-		c.CLILogger.Info("ACME event", zap.String("event", event))
+	return next
+}
 
-		switch event {
-		case "cert_obtained":
-			return c.ensureCertificate(ctx, config, acmeClient, tnClient)
-		default:
-			return nil
+// managedCertificate is a certificate definition with the ACME client
+// obtaining it.
+type managedCertificate struct {
+	*CertificateConfig
+	acme       ACMEConfig
+	acmeClient *certmagic.Config
+	// renewal is updated every time the certificate is ensured.
+	renewal *renewalInfo
+	// hooks are the global hooks followed by the hooks of the certificate.
+	hooks []HookConfig
+	// forceRenewal renews the certificate even if it is not due yet.
+	forceRenewal bool
+}
+
+// managedCertificates creates the ACME client of every configured certificate.
+func (c cmd) managedCertificates(config *Config) ([]managedCertificate, error) {
+	certs := make([]managedCertificate, 0, len(config.Certificates))
+	for i := range config.Certificates {
+		cert := &config.Certificates[i]
+		acme := config.ACME.withSolver(cert.ACME)
+		acmeClient, err := c.acmeClient(acme)
+		if err != nil {
+			return nil, fmt.Errorf("error creating ACME client for %s: %w", cert, err)
 		}
+		certs = append(certs, managedCertificate{
+			CertificateConfig: cert,
+			acme:              acme,
+			acmeClient:        acmeClient,
+			renewal:           &renewalInfo{},
+			hooks:             slices.Concat(config.Hooks, cert.Hooks),
+		})
 	}
 
-	ticker, err := cron.NewTickerWithLocation(*flagSchedule, time.Local)
-	if err != nil {
-		return fmt.Errorf("error parsing schedule %s: %w", *flagSchedule, err)
+	return certs, nil
+}
+
+// ensureCertificates ensures every certificate. A failing certificate does
+// not keep the others from being processed, its error is returned once all
+// are done. The expired certificates are removed after all were deployed, so
+// the certificates in use are only read once.
+func (c cmd) ensureCertificates(ctx context.Context, certs []managedCertificate, tnClient *truenas.Client) error {
+	var errs []error
+	var ensured []managedCertificate
+	for _, cert := range certs {
+		if err := c.ensureCertificate(ctx, cert, tnClient); err != nil {
+			errs = append(errs, c.certificateFailed(cert, err))
+			continue
+		}
+		ensured = append(ensured, cert)
+	}
+	if len(ensured) == 0 {
+		return errors.Join(errs...)
 	}
-	defer ticker.Stop()
 
-	for range ticker.C {
-		if err := c.ensureCertificate(ctx, config, acmeClient, tnClient); err != nil {
-			return err
+	inUse, err := certificatesInUse(ctx, tnClient, certificateTargets(certs))
+	if err != nil {
+		c.CLILogger.Error("error removing expired certificates", zap.Error(err))
+		return errors.Join(append(errs, deployFailure(fmt.Errorf("error removing expired certificates: %w", err)))...)
+	}
+	for _, cert := range ensured {
+		if err := c.removeExpiredCerts(ctx, tnClient, cert.CertificateConfig, inUse, nil); err != nil {
+			errs = append(errs, c.certificateFailed(cert, deployFailure(err)))
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func (c cmd) ensureCertificate(ctx context.Context, cfg *Config, acmeClient *certmagic.Config, tnClient *truenas.Client) error {
-	c.CLILogger.Info("ensure valid certificate is present")
-	currentCert, err := c.ensureACMECertificate(ctx, cfg.Domain, acmeClient)
-	if err != nil {
-		c.CLILogger.Warn("error ensuring certificate, skipping update...", zap.Error(err))
+// certificateFailed logs and reports err, the failure ensuring cert, and
+// returns it annotated with the certificate.
+func (c cmd) certificateFailed(cert managedCertificate, err error) error {
+	c.CLILogger.Error("error ensuring certificate", zap.Stringer("certificate", cert), zap.Error(err))
+	event := certificateEvent(notify.EventFailed, cert.CertificateConfig, nil, "error ensuring certificate for %s", cert)
+	event.Error = err.Error()
+	c.report.add(event)
 
-		return nil
+	return fmt.Errorf("certificate %s: %w", cert, err)
+}
+
+// certificateTargets returns the targets of all certs.
+func certificateTargets(certs []managedCertificate) []TargetConfig {
+	var targets []TargetConfig
+	for _, cert := range certs {
+		targets = append(targets, cert.Targets...)
 	}
 
-	activeCert, err := c.ensureUICertificate(ctx, tnClient, currentCert)
+	return targets
+}
+
+func (c cmd) ensureCertificate(ctx context.Context, cert managedCertificate, tnClient *truenas.Client) error {
+	c.CLILogger.Info("ensure valid certificate is present", zap.Stringer("certificate", cert))
+	currentCert, err := c.ensureACMECertificate(ctx, cert)
 	if err != nil {
-		return fmt.Errorf("error ensuring ui certificate for %s: %w", cfg.Domain, err)
+		c.CLILogger.Warn("error ensuring certificate, skipping update...", zap.Stringer("certificate", cert), zap.Error(err))
+		c.reportExpiring(ctx, cert)
+
+		return fmt.Errorf("%w: %w", errACMEFailure, err)
+	}
+
+	if ri, err := certificateRenewal(ctx, cert.acmeClient, cert.Domains); err == nil {
+		*cert.renewal = ri
+		fields := []zap.Field{zap.Stringer("certificate", cert), zap.Time("not_after", ri.NotAfter), zap.Time("renew_at", ri.RenewAt)}
+		if ri.ARI != nil {
+			fields = append(fields,
+				zap.Time("window_start", ri.ARI.SuggestedWindow.Start),
+				zap.Time("window_end", ri.ARI.SuggestedWindow.End),
+				zap.String("explanation_url", ri.ARI.ExplanationURL),
+			)
+		}
+		c.CertLogger.Info("renewal scheduled", fields...)
 	}
 
-	return c.removeExpiredCerts(ctx, tnClient, cfg.Domain, activeCert)
+	d := &deployment{
+		config:       cert.CertificateConfig,
+		cert:         currentCert,
+		client:       tnClient,
+		staging:      cert.acme.Staging,
+		forceStaging: cert.acme.ForceStaging,
+	}
+	for _, target := range cert.Targets {
+		d.updated = false
+		if err := c.deploy(ctx, d, target); err != nil {
+			return deployFailure(fmt.Errorf("error deploying to %s: %w", target.Type, err))
+		}
+		c.metrics.target(cert, target, currentCert.Leaf.NotAfter, d.updated)
+		if !d.updated {
+			continue
+		}
+		c.report.add(deployedEvent(cert, target, currentCert.Leaf))
+		if err := c.runHooks(ctx, cert.hooks, d.event(target)); err != nil {
+			return deployFailure(fmt.Errorf("error running hooks after deploying to %s: %w", target.Type, err))
+		}
+	}
+
+	return nil
 }
 
-func (c cmd) ensureACMECertificate(ctx context.Context, domain string, acmeClient *certmagic.Config) (certmagic.Certificate, error) {
-	if err := acmeClient.ManageAsync(ctx, []string{domain}); err != nil {
-		return certmagic.Certificate{}, fmt.Errorf("error ensuring certificate for %q: %w", domain, err)
+// deployFailure classifies err, a failure after the certificate was obtained,
+// as TrueNAS being unreachable or as a failed deployment.
+func deployFailure(err error) error {
+	if truenas.IsConnectionError(err) {
+		return fmt.Errorf("%w: %w", errConnectFailure, err)
 	}
-	currentCert, err := acmeClient.CacheManagedCertificate(ctx, domain)
+
+	return fmt.Errorf("%w: %w", errDeployFailure, err)
+}
+
+func (c cmd) ensureACMECertificate(ctx context.Context, cert managedCertificate) (certmagic.Certificate, error) {
+	obtain := c.obtainCertificate
+	if cert.forceRenewal {
+		obtain = c.renewCertificate
+	}
+	previous, _, _ := loadCertificate(ctx, cert.acmeClient, cert.Domains)
+	currentCert, err := obtain(ctx, cert.acmeClient, cert.Domains)
 	if err != nil {
-		return currentCert, fmt.Errorf("error storing certificate for %q: %w", domain, err)
+		return currentCert, fmt.Errorf("error ensuring certificate for %q: %w", cert.Domains, err)
+	}
+	switch {
+	case previous.Empty():
+		c.metrics.issued(cert)
+		c.report.add(certificateEvent(notify.EventIssued, cert.CertificateConfig, currentCert.Leaf,
+			"issued certificate for %s, valid until %s", cert, formatTime(currentCert.Leaf.NotAfter)))
+	case !previous.Leaf.Equal(currentCert.Leaf):
+		c.metrics.issued(cert)
+		c.report.add(certificateEvent(notify.EventRenewed, cert.CertificateConfig, currentCert.Leaf,
+			"renewed certificate for %s, valid until %s", cert, formatTime(currentCert.Leaf.NotAfter)))
 	}
+	c.metrics.certificate(cert, currentCert.Leaf.NotAfter)
 
 	return currentCert, nil
 }
 
-func (c cmd) ensureUICertificate(ctx context.Context, client *truenas.Client, currentCert certmagic.Certificate) (*truenas.Certificate, error) {
-	settings, err := client.SystemGeneralConfig(ctx)
+func (c cmd) ensureUICertificate(ctx context.Context, d *deployment, target TargetConfig) error {
+	settings, err := d.client.SystemGeneralConfig(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error reading system configuration: %w", err)
+		return fmt.Errorf("error reading system configuration: %w", err)
 	}
 
 	if settings.UICertificate == nil {
@@ -234,97 +407,180 @@ func (c cmd) ensureUICertificate(ctx context.Context, client *truenas.Client, cu
 		activeCert := settings.UICertificate
 		activeCertTLS, err := activeCert.TLSCertificate()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing active ui certificate %q: %w", activeCert.Name, err)
+			return fmt.Errorf("error parsing active ui certificate %q: %w", activeCert.Name, err)
 		}
 
-		if activeCertTLS.Leaf.Equal(currentCert.Leaf) {
+		if !d.renewing() && activeCertTLS.Leaf.Equal(d.cert.Leaf) {
 			c.ScaleLogger.Info("ui certificate up to date")
-			return activeCert, nil
+			return nil
+		}
+		if d.staging && isTrusted(activeCertTLS) {
+			if !d.forceStaging {
+				return fmt.Errorf("%w: %q", errTrustedUICertificate, activeCert.Name)
+			}
+			c.ScaleLogger.Warn("replacing trusted ui certificate with staging certificate", zap.String("name", activeCert.Name))
 		}
 	}
 
-	name := "acme-" + time.Now().Format("20060102-150405")
-	c.ScaleLogger.Info("importing certificate", zap.String("name", name), zap.Strings("san", currentCert.Leaf.DNSNames))
-	certImport, err := client.CertificateImport(ctx, name, currentCert.Certificate)
+	certImport, err := c.truenasCertificate(ctx, d)
 	if err != nil {
-		return settings.UICertificate, fmt.Errorf("error importing certificate %q: %w", name, err)
+		return err
 	}
 
-	err = client.SystemGeneralUpdate(ctx, truenas.SystemGeneralUpdateParams{UICertificate: &certImport.ID})
+	if d.plan != nil {
+		description := fmt.Sprintf("set ui certificate to %q and restart the ui", certImport.Name)
+		if settings.UICertificate != nil {
+			description = fmt.Sprintf("replace ui certificate %q (ID %d) with %q and restart the ui",
+				settings.UICertificate.Name, settings.UICertificate.ID, certImport.Name)
+		}
+		d.plan.add(planAction{Action: actionUI, Target: targetUI, CertificateID: certImport.ID, CertificateName: certImport.Name, Description: description})
+		d.updated = true
+		return nil
+	}
+
+	params := truenas.SystemGeneralUpdateParams{UICertificate: &certImport.ID}
+	if target.Verify {
+		port := settings.UIHTTPSPort
+		if port == 0 {
+			port = defaultUIHTTPSPort
+		}
+		params.Verify = func(ctx context.Context) error {
+			if err := d.client.ProbeUICertificate(ctx, port, d.cert.Leaf); err != nil {
+				c.ScaleLogger.Error("ui does not present the new certificate, letting TrueNAS roll back", zap.Int("port", port), zap.Error(err))
+				return err
+			}
+			c.ScaleLogger.Info("ui presents the new certificate", zap.Int("port", port))
+			return nil
+		}
+	}
+	err = d.client.SystemGeneralUpdate(ctx, params)
 	if err != nil {
-		return settings.UICertificate, fmt.Errorf("error setting ui certificate to %q: %w", name, err)
+		return fmt.Errorf("error setting ui certificate to %q: %w", certImport.Name, err)
 	}
 	c.ScaleLogger.Info("ui certificate updated")
+	d.updated = true
 
-	return certImport, nil
+	return nil
 }
 
+// acmeClient creates the certmagic config obtaining certificates as configured
+// by config. Every certificate gets its own config and issuers, so the account
+// and solver settings of one never leak into another.
 func (c cmd) acmeClient(config ACMEConfig) (*certmagic.Config, error) {
-	certmagicLogger := c.CertLogger.Named("certmagic")
-	certmagic.Default.Logger = certmagicLogger.WithOptions(zap.IncreaseLevel(zap.WarnLevel))
-	certmagic.DefaultACME.Logger = certmagicLogger.Named("acme")
-	certmagic.DefaultACME.Agreed = config.TOSAgreed
-	certmagic.DefaultACME.Email = config.Email
-	certmagic.Default.Storage = &certmagic.FileStorage{Path: config.Storage}
-
 	provider, err := config.DNSProvider()
 	if err != nil {
 		return nil, fmt.Errorf("dns provider could not be loaded: %w", err)
 	}
-	certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{DNSManager: certmagic.DNSManager{
+	if p, ok := provider.(*execdns.Provider); ok {
+		p.Logger = c.CertLogger.Named("exec")
+	}
+	solver := &certmagic.DNS01Solver{DNSManager: certmagic.DNSManager{
 		Resolvers:   config.Resolvers,
 		DNSProvider: provider,
 	}}
 
-	magic := certmagic.NewDefault()
-	magic.Issuers = []certmagic.Issuer{
-		certmagic.NewACMEIssuer(magic, certmagic.ACMEIssuer{
-			CA:     certmagic.LetsEncryptProductionCA,
-			TestCA: certmagic.LetsEncryptStagingCA,
-		}),
-		// ZeroSSL requires EAB
-		certmagic.NewACMEIssuer(magic, certmagic.ACMEIssuer{
-			CA: certmagic.ZeroSSLProductionCA,
-			NewAccountFunc: func(ctx context.Context, issuer *certmagic.ACMEIssuer, account acme.Account) (acme.Account, error) {
-				if issuer.ExternalAccount != nil {
-					return account, nil
-				}
-				credentials, account, err := zerossl.EABCredentials(ctx, config.Email, account)
-				if err != nil {
-					return account, fmt.Errorf("error getting ZeroSSL EAB credentials: %w", err)
-				}
-				issuer.ExternalAccount = credentials
+	issuers := config.issuers()
+	if len(issuers) == 0 {
+		return nil, errNoStagingIssuer
+	}
 
-				return account, nil
-			},
-		}),
+	certmagicLogger := c.CertLogger.Named("certmagic")
+	var magic *certmagic.Config
+	cache := certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) { return magic, nil },
+		Logger:           certmagicLogger,
+	})
+	template := certmagic.Config{
+		Storage:          &certmagic.FileStorage{Path: config.storage()},
+		Logger:           certmagicLogger.WithOptions(zap.IncreaseLevel(zap.WarnLevel)),
+		ReusePrivateKeys: config.ReusePrivateKeys,
+	}
+	if config.KeyType != "" {
+		template.KeySource = certmagic.StandardKeyGenerator{KeyType: certmagic.KeyType(config.KeyType)}
+	}
+	magic = certmagic.New(cache, template)
+	// replace the default issuer certmagic adds, which uses the global
+	// certmagic.DefaultACME
+	magic.Issuers = make([]certmagic.Issuer, 0, len(issuers))
+	for _, ic := range issuers {
+		issuer, err := c.acmeIssuer(magic, config, ic, solver)
+		if err != nil {
+			cache.Stop()
+			return nil, fmt.Errorf("issuer %s: %w", ic.Directory, err)
+		}
+		magic.Issuers = append(magic.Issuers, issuer)
 	}
 
 	return magic, nil
 }
 
-func (c cmd) removeExpiredCerts(ctx context.Context, client *truenas.Client, domain string, activeCert *truenas.Certificate) error {
+// acmeIssuer creates the issuer for the CA described by ic.
+func (c cmd) acmeIssuer(magic *certmagic.Config, config ACMEConfig, ic IssuerConfig, solver *certmagic.DNS01Solver) (*certmagic.ACMEIssuer, error) {
+	roots, err := ic.trustedRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	template := certmagic.ACMEIssuer{
+		CA:           ic.Directory,
+		TrustedRoots: roots,
+		Email:        config.Email,
+		Agreed:       config.TOSAgreed,
+		DNS01Solver:  solver,
+		Profile:      config.Profile,
+		Logger:       c.CertLogger.Named("certmagic").Named("acme"),
+	}
+	if ic.Directory == certmagic.LetsEncryptProductionCA {
+		template.TestCA = certmagic.LetsEncryptStagingCA
+	}
+
+	switch {
+	case ic.EAB != nil:
+		template.ExternalAccount = &acme.EAB{KeyID: ic.EAB.KeyID, MACKey: ic.EAB.MACKey}
+	case ic.Directory == certmagic.ZeroSSLProductionCA:
+		// ZeroSSL requires EAB, which it hands out for an email address
+		template.NewAccountFunc = func(ctx context.Context, issuer *certmagic.ACMEIssuer, account acme.Account) (acme.Account, error) {
+			if issuer.ExternalAccount != nil {
+				return account, nil
+			}
+			credentials, account, err := zerossl.EABCredentials(ctx, config.Email, account)
+			if err != nil {
+				return account, fmt.Errorf("error getting ZeroSSL EAB credentials: %w", err)
+			}
+			issuer.ExternalAccount = credentials
+
+			return account, nil
+		}
+	}
+
+	return certmagic.NewACMEIssuer(magic, template), nil
+}
+
+// removeExpiredCerts removes the expired certificates that are not in use and
+// either cover the names of config or were imported by this tool, so the
+// certificates of names since dropped from config are removed as well. In a
+// dry run, they are added to p instead. inUse are the certificates in use, as
+// returned by [certificatesInUse] for the targets of all certificates.
+func (c cmd) removeExpiredCerts(ctx context.Context, client *truenas.Client, config *CertificateConfig, inUse map[int]bool, p *certificatePlan) error {
 	certs, err := client.Certificates(ctx)
 	if err != nil {
 		return fmt.Errorf("error listing certificates: %w", err)
 	}
 
-	for _, cert := range certs {
-		if cert.Common != domain {
-			continue
-		}
-		if !cert.Expired {
+	for _, cert := range prunableCertificates(certs, inUse, []CertificateConfig{*config}) {
+		if p != nil {
+			p.add(planAction{
+				Action: actionDelete, CertificateID: cert.ID, CertificateName: cert.Name,
+				Description: fmt.Sprintf("remove certificate %q (ID %d), expired %s", cert.Name, cert.ID, formatTime(cert.Until.Time)),
+			})
 			continue
 		}
-		if activeCert != nil && cert.ID == activeCert.ID {
-			continue
-		}
-
 		c.ScaleLogger.Info("removing expired certificate", zap.Int("id", cert.ID), zap.String("cn", cert.Common), zap.Time("expired", cert.Until.Time))
 		err = client.CertificateDelete(ctx, cert.ID)
 		if err != nil {
 			return fmt.Errorf("error removing certificate %d for %s: %w", cert.ID, cert.Common, err)
 		}
+		c.report.add(certificateEvent(notify.EventPruned, config, nil, "removed expired certificate %q (ID %d)", cert.Name, cert.ID))
 	}
 
 	return nil
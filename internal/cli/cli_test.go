@@ -4,6 +4,11 @@ import (
 	"net"
 	"net/url"
 	"testing"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/cloudflare"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
 )
 
 func Test_defaultURL(t *testing.T) {
@@ -31,3 +36,75 @@ func Test_exampleConfig(t *testing.T) {
 		t.Errorf("exampleConfig invalid: %s", err)
 	}
 }
+
+func Test_managedCertificates(t *testing.T) {
+	t.Parallel()
+
+	config := exampleConfig
+	config.ACME.Storage = t.TempDir()
+	config.Certificates = []CertificateConfig{
+		{Name: "ui", Domains: []string{"nas.example.com"}},
+		{Name: "apps", Domains: []string{"*.apps.example.com"}, ACME: &SolverConfig{Cloudflare: &cloudflare.Provider{APIToken: "s3cure"}}},
+	}
+
+	certs, err := newTestCmd().managedCertificates(&config)
+	if err != nil {
+		t.Fatalf("managedCertificates() error = %v", err)
+	}
+	if certs[0].acmeClient == certs[1].acmeClient {
+		t.Fatal("managedCertificates() shares the certmagic config between certificates")
+	}
+	for _, cert := range certs {
+		if got, want := len(cert.acmeClient.Issuers), len(config.ACME.issuers()); got != want {
+			t.Errorf("issuers of %s = %d, want %d", cert, got, want)
+		}
+		for _, issuer := range cert.acmeClient.Issuers {
+			acme, ok := issuer.(*certmagic.ACMEIssuer)
+			if !ok {
+				t.Fatalf("issuer = %T, want *certmagic.ACMEIssuer", issuer)
+			}
+			if acme.Email != config.ACME.Email {
+				t.Errorf("issuer email of %s = %q, want %q", cert, acme.Email, config.ACME.Email)
+			}
+			_, cf := acme.DNS01Solver.(*certmagic.DNS01Solver).DNSProvider.(*cloudflare.Provider)
+			if want := cert.Name == "apps"; cf != want {
+				t.Errorf("solver of %s uses cloudflare = %t, want %t", cert, cf, want)
+			}
+		}
+	}
+	if certmagic.DefaultACME.Email != "" {
+		t.Errorf("certmagic.DefaultACME.Email = %q, want it untouched", certmagic.DefaultACME.Email)
+	}
+}
+
+func Test_ensureCertificates_removeExpired(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+	addTestCertificate(t, srv, testCertificate{name: "acme-20250101-000000", expired: true})
+
+	var certs []managedCertificate
+	for _, name := range []string{"nas.example.com", "s3.example.com"} {
+		certs = append(certs, managedCertificate{
+			CertificateConfig: &CertificateConfig{Domains: []string{name}, Targets: []TargetConfig{}},
+			acmeClient:        newTestMagic(t, newTestIssuer(t)),
+			renewal:           &renewalInfo{},
+		})
+	}
+	if err := newTestCmd().ensureCertificates(t.Context(), certs, client); err != nil {
+		t.Fatalf("ensureCertificates() error = %v", err)
+	}
+
+	if got := srv.Calls("ftp.config"); got != 1 {
+		t.Errorf("certificates in use read %d times, want once per run", got)
+	}
+	if certs := srv.Certificates(); len(certs) != 0 {
+		t.Errorf("certificates = %v, want the expired certificate removed", certs)
+	}
+}
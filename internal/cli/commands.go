@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	// errUnknownCommand is returned for a command that does not exist.
+	errUnknownCommand = errors.New("unknown command")
+	// errUnsupportedFlag is returned for a flag the command does not take.
+	errUnsupportedFlag = errors.New("flag not supported")
+	// errUnexpectedArguments is returned for arguments following the command.
+	errUnexpectedArguments = errors.New("unexpected arguments")
+	// errDaemonDryRun is returned if a dry run is asked of the daemon.
+	errDaemonDryRun = errors.New("--dry-run cannot be combined with --daemon")
+	// errListenWithoutDaemon is returned if --listen is given without --daemon.
+	errListenWithoutDaemon = errors.New("--listen requires --daemon")
+	// errScheduleWithoutDaemon is returned if --schedule is given without
+	// --daemon.
+	errScheduleWithoutDaemon = errors.New("--schedule requires --daemon")
+)
+
+// options holds the command-line flags.
+type options struct {
+	configPath   string
+	staging      bool
+	forceStaging bool
+	help         bool
+	version      bool
+
+	daemon   bool
+	schedule string
+	listen   string
+	force    bool
+	dryRun   bool
+	output   string
+	id       int
+}
+
+// globalFlags are the flags every command takes.
+var globalFlags = []string{"config", "staging", "force-staging", "help", "version"}
+
+// flagSet returns the flags of all commands, parsed into o.
+func (o *options) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("truenas-scale-acme", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&o.configPath, "config", defaultConfigPath(), "Configuration path")
+	fs.BoolVar(&o.staging, "staging", false, "Request certificates from the staging directories of the CA's")
+	fs.BoolVar(&o.forceStaging, "force-staging", false, "Replace a trusted UI certificate with a staging certificate")
+	fs.BoolVarP(&o.help, "help", "h", false, "Print help message")
+	fs.BoolVarP(&o.version, "version", "v", false, "Print version information")
+	fs.BoolVar(&o.daemon, "daemon", false, "Run in daemon mode, like the daemon command")
+	fs.StringVar(&o.schedule, "schedule", "22 22 * * *", "Cron schedule of the daemon")
+	fs.StringVar(&o.listen, "listen", "", "Address the daemon serves /healthz, /readyz, /status and /metrics on, e.g. :8080")
+	fs.BoolVar(&o.force, "force", false, "Renew the certificates even if they are not due")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Only show the changes, without making them")
+	fs.StringVar(&o.output, "output", outputText, "Output format of --dry-run, text or json")
+	fs.IntVar(&o.id, "id", 0, "ID of the certificate to roll back to, instead of the previous one")
+
+	return fs
+}
+
+// command is a command of the CLI.
+type command struct {
+	name    string
+	summary string
+	// flags names the flags the command takes besides the global ones.
+	flags []string
+	run   func(c cmd, ctx context.Context, opts *options) error
+}
+
+// commands are the commands of the CLI. The first one runs if none is given.
+var commands = []command{
+	{
+		name:    "run",
+		summary: "Obtain the certificates and deploy them once (default)",
+		flags:   []string{"daemon", "schedule", "listen", "dry-run", "output"},
+		run:     cmd.runOnce,
+	},
+	{
+		name:    "daemon",
+		summary: "Obtain the certificates and deploy them on a schedule",
+		flags:   []string{"schedule", "listen"},
+		run:     cmd.runDaemon,
+	},
+	{
+		name:    "renew",
+		summary: "Renew the certificates that are due, or all with --force, and deploy them",
+		flags:   []string{"force", "dry-run", "output"},
+		run:     cmd.renew,
+	},
+	{
+		name:    "status",
+		summary: "Show the UI certificate and the stored ACME certificates",
+		run:     cmd.status,
+	},
+	{
+		name:    "list",
+		summary: "List the certificates in TrueNAS",
+		run:     cmd.list,
+	},
+	{
+		name:    "prune",
+		summary: "Remove expired certificates that are not in use from TrueNAS",
+		flags:   []string{"dry-run"},
+		run:     cmd.prune,
+	},
+	{
+		name:    "validate",
+		summary: "Check the configuration, the DNS provider credentials and the TrueNAS API key",
+		run:     cmd.validate,
+	},
+	{
+		name:    "rollback",
+		summary: "Make the previous certificate the UI certificate again",
+		flags:   []string{"id"},
+		run:     cmd.runRollback,
+	},
+}
+
+// parseArgs parses the command-line arguments into the command they name and
+// its options.
+func parseArgs(args []string) (*command, *options, error) {
+	opts := &options{}
+	fs := opts.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	selected := &commands[0]
+	if fs.NArg() > 0 {
+		i := slices.IndexFunc(commands, func(c command) bool { return c.name == fs.Arg(0) })
+		if i < 0 {
+			return nil, nil, fmt.Errorf("%w %q", errUnknownCommand, fs.Arg(0))
+		}
+		selected = &commands[i]
+	}
+	if fs.NArg() > 1 {
+		return nil, nil, fmt.Errorf("%w for %s: %q", errUnexpectedArguments, selected.name, fs.Args()[1:])
+	}
+
+	var errs []error
+	schedule := false
+	fs.Visit(func(f *flag.Flag) {
+		schedule = schedule || f.Name == "schedule"
+		if !slices.Contains(globalFlags, f.Name) && !slices.Contains(selected.flags, f.Name) {
+			errs = append(errs, fmt.Errorf("%w by %s: --%s", errUnsupportedFlag, selected.name, f.Name))
+		}
+	})
+	if opts.output != outputText && opts.output != outputJSON {
+		errs = append(errs, fmt.Errorf("%w %q, use %s or %s", errInvalidOutput, opts.output, outputText, outputJSON))
+	}
+	if opts.daemon && opts.dryRun {
+		errs = append(errs, errDaemonDryRun)
+	}
+	if opts.listen != "" && !opts.daemon && selected.name != "daemon" {
+		errs = append(errs, errListenWithoutDaemon)
+	}
+	if schedule && !opts.daemon && selected.name != "daemon" {
+		errs = append(errs, errScheduleWithoutDaemon)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	return selected, opts, nil
+}
+
+// usage prints the help message of selected. The default command also lists
+// all commands.
+func (c cmd) usage(selected *command) {
+	all := (&options{}).flagSet()
+	fs := flag.NewFlagSet(selected.name, flag.ContinueOnError)
+	all.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(globalFlags, f.Name) || slices.Contains(selected.flags, f.Name) {
+			fs.AddFlag(f)
+		}
+	})
+
+	fmt.Fprintf(c.Out, "Usage of %s %s:\n", os.Args[0], c.Version)
+	if selected == &commands[0] {
+		fmt.Fprintf(c.Out, "  %s [command] [flags]\n\nCommands:\n", os.Args[0])
+		for _, command := range commands {
+			fmt.Fprintf(c.Out, "  %-10s%s\n", command.name, command.summary)
+		}
+	} else {
+		fmt.Fprintf(c.Out, "  %s %s [flags]\n\n%s\n", os.Args[0], selected.name, selected.summary)
+	}
+	fmt.Fprintf(c.Out, "\nFlags:\n%s", fs.FlagUsages())
+}
+
+// runOnce ensures the certificates once, or runs the daemon if --daemon is
+// given. With --dry-run, the changes are only shown.
+func (c cmd) runOnce(ctx context.Context, opts *options) error {
+	if opts.daemon {
+		return c.runDaemon(ctx, opts)
+	}
+
+	config, tnClient, certs, err := c.setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	if opts.dryRun {
+		return c.dryRun(ctx, certs, tnClient, opts.output)
+	}
+	return c.ensureAndNotify(ctx, config, certs, tnClient)
+}
+
+// renew ensures the certificates once. With --force, they are renewed even if
+// they are not due yet.
+func (c cmd) renew(ctx context.Context, opts *options) error {
+	config, tnClient, certs, err := c.setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	for i := range certs {
+		certs[i].forceRenewal = opts.force
+	}
+
+	if opts.dryRun {
+		return c.dryRun(ctx, certs, tnClient, opts.output)
+	}
+	return c.ensureAndNotify(ctx, config, certs, tnClient)
+}
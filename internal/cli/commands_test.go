@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_parseArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantOptions options
+		wantErr     error
+	}{
+		{
+			name:        "no command",
+			args:        []string{"--config", "config.json"},
+			wantCommand: "run",
+			wantOptions: options{configPath: "config.json", schedule: "22 22 * * *", output: outputText},
+		},
+		{
+			name:        "daemon flag",
+			args:        []string{"--config", "config.json", "--daemon", "--schedule", "0 3 * * *"},
+			wantCommand: "run",
+			wantOptions: options{configPath: "config.json", daemon: true, schedule: "0 3 * * *", output: outputText},
+		},
+		{
+			name:        "flags before command",
+			args:        []string{"--config", "config.json", "--staging", "renew", "--force"},
+			wantCommand: "renew",
+			wantOptions: options{configPath: "config.json", staging: true, force: true, schedule: "22 22 * * *", output: outputText},
+		},
+		{
+			name:        "rollback",
+			args:        []string{"rollback", "--id", "12", "--config=config.json"},
+			wantCommand: "rollback",
+			wantOptions: options{configPath: "config.json", id: 12, schedule: "22 22 * * *", output: outputText},
+		},
+		{
+			name:        "dry run",
+			args:        []string{"--dry-run", "--output", "json"},
+			wantCommand: "run",
+			wantOptions: options{configPath: defaultConfigPath(), dryRun: true, schedule: "22 22 * * *", output: outputJSON},
+		},
+		{
+			name:    "invalid output",
+			args:    []string{"--dry-run", "--output", "yaml"},
+			wantErr: errInvalidOutput,
+		},
+		{
+			name:    "daemon dry run",
+			args:    []string{"--daemon", "--dry-run"},
+			wantErr: errDaemonDryRun,
+		},
+		{
+			name:        "daemon listen",
+			args:        []string{"daemon", "--listen", ":8080"},
+			wantCommand: "daemon",
+			wantOptions: options{configPath: defaultConfigPath(), schedule: "22 22 * * *", listen: ":8080", output: outputText},
+		},
+		{
+			name:    "listen without daemon",
+			args:    []string{"--listen", ":8080"},
+			wantErr: errListenWithoutDaemon,
+		},
+		{
+			name:    "schedule without daemon",
+			args:    []string{"--schedule", "0 3 * * *"},
+			wantErr: errScheduleWithoutDaemon,
+		},
+		{
+			name:        "daemon schedule",
+			args:        []string{"--daemon", "--schedule", "0 3 * * *"},
+			wantCommand: "run",
+			wantOptions: options{configPath: defaultConfigPath(), daemon: true, schedule: "0 3 * * *", output: outputText},
+		},
+		{
+			name:    "unknown command",
+			args:    []string{"deploy"},
+			wantErr: errUnknownCommand,
+		},
+		{
+			name:    "flag of other command",
+			args:    []string{"list", "--force"},
+			wantErr: errUnsupportedFlag,
+		},
+		{
+			name:    "arguments",
+			args:    []string{"prune", "now"},
+			wantErr: errUnexpectedArguments,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			command, opts, err := parseArgs(tt.args)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseArgs() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if command.name != tt.wantCommand {
+				t.Errorf("parseArgs() command = %s, want %s", command.name, tt.wantCommand)
+			}
+			if *opts != tt.wantOptions {
+				t.Errorf("parseArgs() options = %+v, want %+v", *opts, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func Test_usage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		command  *command
+		want     []string
+		dontWant []string
+	}{
+		{"default", &commands[0], []string{"Commands:", "rollback", "--daemon", "--config"}, []string{"--id"}},
+		{"rollback", &commands[len(commands)-1], []string{"rollback [flags]", "--id", "--config"}, []string{"Commands:", "--daemon"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var out strings.Builder
+			c := newTestCmd()
+			c.Out = &out
+			c.usage(tt.command)
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("usage() = %q, want it to contain %q", out.String(), want)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(out.String(), dontWant) {
+					t.Errorf("usage() = %q, want it not to contain %q", out.String(), dontWant)
+				}
+			}
+		})
+	}
+}
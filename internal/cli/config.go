@@ -1,18 +1,28 @@
 package cli
 
 import (
+	"cmp"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/mail"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/libdns/acmedns"
 	"github.com/libdns/cloudflare"
+	"github.com/thde/truenas-scale-acme/internal/hook"
+	"github.com/thde/truenas-scale-acme/internal/notify"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
 	"go.uber.org/zap"
 )
 
@@ -27,10 +37,24 @@ const (
 var (
 	errNoSolver         = errors.New("no solver configured")
 	errNoDomain         = errors.New("no domain specified")
+	errInvalidDomain    = errors.New("invalid domain")
+	errNoCertificates   = errors.New("no certificates specified")
+	errDuplicateName    = errors.New("duplicate certificate name")
+	errUnknownTarget    = errors.New("unknown target type")
+	errInvalidTarget    = errors.New("invalid target")
+	errDuplicateTarget  = errors.New("target used by more than one certificate")
 	errNoAPIConfig      = errors.New("no api config specified")
 	errNoAPIKey         = errors.New("no api.api_key specified")
 	errNoACMEEmail      = errors.New("no acme.email specified")
 	errInvalidResolvers = errors.New("invalid acme.resolvers")
+	errInvalidIssuer    = errors.New("invalid acme.issuers entry")
+	errNoStagingIssuer  = errors.New("no issuer with a staging directory configured")
+	errInvalidKeyType   = errors.New("invalid acme.key_type")
+	errInvalidHook      = errors.New("invalid hook")
+	errInvalidRetry     = errors.New("invalid retry policy")
+	errInvalidAlert     = errors.New("invalid alert")
+	errInvalidMail      = errors.New("invalid mail")
+	errInvalidNotify    = errors.New("invalid notify")
 )
 
 // APIConfig describes how to reach the TrueNAS API.
@@ -42,18 +66,46 @@ type APIConfig struct {
 
 // ACMEConfig holds the ACME account settings and the DNS-01 solver credentials.
 type ACMEConfig struct {
-	Email      string               `json:"email"`
-	TOSAgreed  bool                 `json:"tos_agreed"`
-	Resolvers  []string             `json:"resolvers,omitempty"`
-	Storage    string               `json:"storage,omitempty"`
-	ACMEDNS    *acmedns.Provider    `json:"acme-dns,omitempty"`
+	Email     string   `json:"email"`
+	TOSAgreed bool     `json:"tos_agreed"`
+	Resolvers []string `json:"resolvers,omitempty"`
+	Storage   string   `json:"storage,omitempty"`
+	// Issuers are the CAs certificates are requested from, in order of
+	// [IssuerConfig.Priority]. It defaults to [defaultIssuers].
+	Issuers []IssuerConfig `json:"issuers,omitempty"`
+	// Staging requests certificates from the staging directories of the
+	// issuers, which are not trusted but have generous rate limits.
+	Staging bool `json:"staging,omitempty"`
+	// ForceStaging allows replacing a trusted UI certificate with a staging
+	// one.
+	ForceStaging bool `json:"force_staging,omitempty"`
+	// Profile selects a certificate profile offered by the CA, e.g.
+	// "shortlived" or "tlsserver" at Let's Encrypt.
+	Profile string `json:"profile,omitempty"`
+	// KeyType is the type of the certificate keys, one of [keyTypes]. It
+	// defaults to certmagic's default, ECDSA P-256.
+	KeyType string `json:"key_type,omitempty"`
+	// ReusePrivateKeys keeps the private key when a certificate is renewed.
+	ReusePrivateKeys bool `json:"reuse_private_keys,omitempty"`
+	// DNS selects the DNS-01 solver from the supported libdns providers.
+	DNS *DNSProviderConfig `json:"dns,omitempty"`
+	// ACMEDNS configures an acme-dns solver.
+	//
+	// Deprecated: Use [ACMEConfig.DNS] with provider "acme-dns" instead.
+	ACMEDNS *acmedns.Provider `json:"acme-dns,omitempty"`
+	// Cloudflare configures a Cloudflare solver.
+	//
+	// Deprecated: Use [ACMEConfig.DNS] with provider "cloudflare" instead.
 	Cloudflare *cloudflare.Provider `json:"cloudflare,omitempty"`
 }
 
-// DNSProvider returns the configured DNS-01 solver. ACME-DNS takes precedence
-// if more than one provider is configured.
+// DNSProvider returns the configured DNS-01 solver. [ACMEConfig.DNS] takes
+// precedence over the deprecated provider fields, of which ACME-DNS takes
+// precedence if more than one is configured.
 func (ac *ACMEConfig) DNSProvider() (certmagic.DNSProvider, error) {
-	if ac.ACMEDNS != nil {
+	if ac.DNS != nil {
+		return ac.DNS.DNSProvider()
+	} else if ac.ACMEDNS != nil {
 		return ac.ACMEDNS, nil
 	} else if ac.Cloudflare != nil {
 		return ac.Cloudflare, nil
@@ -62,9 +114,631 @@ func (ac *ACMEConfig) DNSProvider() (certmagic.DNSProvider, error) {
 	return nil, errNoSolver
 }
 
+// keyTypes are the supported values of [ACMEConfig.KeyType].
+var keyTypes = []certmagic.KeyType{
+	certmagic.ED25519,
+	certmagic.P256,
+	certmagic.P384,
+	certmagic.RSA2048,
+	certmagic.RSA4096,
+}
+
+// EABConfig holds external account binding credentials issued by a CA.
+type EABConfig struct {
+	KeyID  string `json:"key_id"`
+	MACKey string `json:"mac_key"`
+}
+
+// IssuerConfig describes an ACME CA.
+type IssuerConfig struct {
+	// Directory is the URL of the CA's ACME directory.
+	Directory string `json:"directory"`
+	// StagingDirectory is used instead of Directory in staging mode. Issuers
+	// without one are skipped in staging mode, unless their staging directory
+	// is known, see [stagingDirectories].
+	StagingDirectory string `json:"staging_directory,omitempty"`
+	// CABundle is the path of a PEM file with the root certificates to trust
+	// when talking to the CA, e.g. for a private step-ca.
+	CABundle string `json:"ca_bundle,omitempty"`
+	// EAB holds the external account binding credentials the CA requires.
+	EAB *EABConfig `json:"eab,omitempty"`
+	// Priority orders the issuers, lower values are tried first. Issuers with
+	// the same priority keep the order of the configuration.
+	Priority int `json:"priority,omitempty"`
+}
+
+// defaultIssuers are used if no issuers are configured, so a certificate can
+// be obtained even if one CA is unavailable.
+var defaultIssuers = []IssuerConfig{
+	{Directory: certmagic.LetsEncryptProductionCA},
+	{Directory: certmagic.ZeroSSLProductionCA},
+}
+
+// stagingDirectories maps the directories of well-known CAs to their staging
+// directory. ZeroSSL does not offer one.
+var stagingDirectories = map[string]string{
+	certmagic.LetsEncryptProductionCA: certmagic.LetsEncryptStagingCA,
+}
+
+// issuers returns the configured issuers, or the defaults, ordered by priority.
+// In staging mode, the directory of every issuer is replaced by its staging
+// directory and issuers without one are left out.
+func (ac *ACMEConfig) issuers() []IssuerConfig {
+	issuers := defaultIssuers
+	if len(ac.Issuers) > 0 {
+		issuers = slices.Clone(ac.Issuers)
+		slices.SortStableFunc(issuers, func(a, b IssuerConfig) int { return cmp.Compare(a.Priority, b.Priority) })
+	}
+	if !ac.Staging {
+		return issuers
+	}
+
+	staging := make([]IssuerConfig, 0, len(issuers))
+	for _, ic := range issuers {
+		if dir := ic.stagingDirectory(); dir != "" {
+			ic.Directory = dir
+			staging = append(staging, ic)
+		}
+	}
+
+	return staging
+}
+
+// storage returns the path of the ACME storage. Staging certificates and
+// accounts are kept apart from the production ones.
+func (ac *ACMEConfig) storage() string {
+	if ac.Staging {
+		return filepath.Join(ac.Storage, "staging")
+	}
+
+	return ac.Storage
+}
+
+// stagingDirectory returns the staging directory of the issuer, if it has one.
+func (ic *IssuerConfig) stagingDirectory() string {
+	if ic.StagingDirectory != "" {
+		return ic.StagingDirectory
+	}
+
+	return stagingDirectories[ic.Directory]
+}
+
+// trustedRoots loads the CA bundle, if one is configured.
+func (ic *IssuerConfig) trustedRoots() (*x509.CertPool, error) {
+	if ic.CABundle == "" {
+		return nil, nil
+	}
+
+	bundle, err := os.ReadFile(ic.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("%w: no certificates in ca bundle %s", errInvalidIssuer, ic.CABundle)
+	}
+
+	return pool, nil
+}
+
+// valid returns the problems of an issuer definition.
+func (ic *IssuerConfig) valid() []error {
+	var errs []error
+
+	if !isDirectoryURL(ic.Directory) {
+		errs = append(errs, fmt.Errorf("%w: invalid directory '%s'", errInvalidIssuer, ic.Directory))
+	}
+	if ic.StagingDirectory != "" && !isDirectoryURL(ic.StagingDirectory) {
+		errs = append(errs, fmt.Errorf("%w: invalid staging_directory '%s'", errInvalidIssuer, ic.StagingDirectory))
+	}
+	if ic.EAB != nil && (ic.EAB.KeyID == "" || ic.EAB.MACKey == "") {
+		errs = append(errs, fmt.Errorf("%w: eab of '%s' needs key_id and mac_key", errInvalidIssuer, ic.Directory))
+	}
+	if _, err := ic.trustedRoots(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func joinKeyTypes(types []certmagic.KeyType) string {
+	names := make([]string, 0, len(types))
+	for _, kt := range types {
+		names = append(names, string(kt))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// isDirectoryURL reports whether dir is an absolute URL.
+func isDirectoryURL(dir string) bool {
+	u, err := url.Parse(dir)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
+
+// isResolver reports whether resolver is an IP address, optionally with a
+// port, e.g. "9.9.9.9" or "[2620:fe::fe]:53".
+func isResolver(resolver string) bool {
+	if host, _, err := net.SplitHostPort(resolver); err == nil {
+		resolver = host
+	}
+
+	return net.ParseIP(resolver) != nil
+}
+
+// SolverConfig overrides the DNS-01 solver of [Config.ACME] for one
+// certificate. The account and issuer settings are shared by all certificates.
+type SolverConfig struct {
+	Resolvers []string `json:"resolvers,omitempty"`
+	// DNS selects the DNS-01 solver from the supported libdns providers.
+	DNS *DNSProviderConfig `json:"dns,omitempty"`
+	// ACMEDNS configures an acme-dns solver.
+	//
+	// Deprecated: Use [SolverConfig.DNS] with provider "acme-dns" instead.
+	ACMEDNS *acmedns.Provider `json:"acme-dns,omitempty"`
+	// Cloudflare configures a Cloudflare solver.
+	//
+	// Deprecated: Use [SolverConfig.DNS] with provider "cloudflare" instead.
+	Cloudflare *cloudflare.Provider `json:"cloudflare,omitempty"`
+}
+
+// withSolver returns ac with the DNS-01 solver settings of override applied, if
+// override configures any. The account settings always stay those of ac.
+func (ac *ACMEConfig) withSolver(override *SolverConfig) ACMEConfig {
+	merged := *ac
+	if override == nil {
+		return merged
+	}
+
+	if len(override.Resolvers) > 0 {
+		merged.Resolvers = override.Resolvers
+	}
+	if override.DNS != nil || override.ACMEDNS != nil || override.Cloudflare != nil {
+		merged.DNS = override.DNS
+		merged.ACMEDNS = override.ACMEDNS
+		merged.Cloudflare = override.Cloudflare
+	}
+
+	return merged
+}
+
+// Target types a certificate can be deployed to.
+const (
+	// targetUI is the TrueNAS web UI.
+	targetUI = "ui"
+	// targetS3 is the S3 (MinIO) service.
+	targetS3 = "s3"
+	// targetFTP is the FTP service, serving FTPS with the certificate.
+	targetFTP = "ftp"
+	// targetWebDAV is the WebDAV service.
+	targetWebDAV = "webdav"
+	// targetApp are apps referencing the certificate in their values.
+	targetApp = "app"
+	// targetFile are PEM files in a directory, for consumers outside of
+	// TrueNAS.
+	targetFile = "file"
+)
+
+// defaultFileMode is the mode of the files of the file target unless one is
+// configured, they contain the private key.
+const defaultFileMode = "0600"
+
+// defaultUIHTTPSPort is the HTTPS port of the UI if TrueNAS reports none.
+const defaultUIHTTPSPort = 443
+
+// targetTypes are all target types.
+var targetTypes = []string{targetUI, targetS3, targetFTP, targetWebDAV, targetApp, targetFile}
+
+// defaultAppValuesPath is where catalog apps such as Nextcloud or Immich keep
+// the ID of their certificate.
+const defaultAppValuesPath = "network.certificate_id"
+
+// webDAVProtocols are the protocols of the webdav target using a certificate.
+var webDAVProtocols = []string{truenas.WebDAVHTTPS, truenas.WebDAVHTTPHTTPS}
+
+// TargetConfig describes a TrueNAS consumer a certificate is deployed to. In
+// the configuration it is either an object or just the type as a string.
+type TargetConfig struct {
+	Type string `json:"type"`
+	// Restart restarts a running service after its certificate changed. It
+	// defaults to true and is not supported by the ui target, which TrueNAS
+	// always restarts, and the app target, which TrueNAS redeploys.
+	Restart *bool `json:"restart,omitempty"`
+	// Verify makes the ui target check the UI presents the new certificate
+	// on its HTTPS port before confirming the switch. Otherwise the switch is
+	// left unconfirmed, so TrueNAS rolls it back.
+	Verify bool `json:"verify,omitempty"`
+	// TLS enables FTPS on the ftp target.
+	TLS bool `json:"tls,omitempty"`
+	// Protocol sets the protocol of the webdav target, "HTTPS" or
+	// "HTTPHTTPS". The protocol is left unchanged if it is unset.
+	Protocol string `json:"protocol,omitempty"`
+	// Apps are the names of the apps the app target updates.
+	Apps []string `json:"apps,omitempty"`
+	// Path is the dot separated path of the certificate ID in the values of
+	// the apps. It defaults to defaultAppValuesPath.
+	Path string `json:"path,omitempty"`
+	// Directory is the absolute path the file target writes its files to.
+	Directory string `json:"directory,omitempty"`
+	// Owner owns the files of the file target, as "user" or "user:group" by
+	// name or ID. The files are owned by the user running the command if it
+	// is unset.
+	Owner string `json:"owner,omitempty"`
+	// Mode is the octal mode of the files of the file target, it defaults to
+	// defaultFileMode.
+	Mode string `json:"mode,omitempty"`
+	// PKCS12 makes the file target also write a PKCS #12 bundle, encrypted
+	// with PKCS12Password.
+	PKCS12         bool   `json:"pkcs12,omitempty"`
+	PKCS12Password string `json:"pkcs12_password,omitempty"`
+}
+
+// restart reports whether the service of the target is restarted after its
+// certificate changed.
+func (t TargetConfig) restart() bool {
+	return t.Restart == nil || *t.Restart
+}
+
+// valuesPath returns the keys leading to the certificate ID in the values of
+// the apps of the app target.
+func (t TargetConfig) valuesPath() []string {
+	if t.Path == "" {
+		return strings.Split(defaultAppValuesPath, ".")
+	}
+	return strings.Split(t.Path, ".")
+}
+
+// fileMode returns the mode of the files of the file target.
+func (t TargetConfig) fileMode() (os.FileMode, error) {
+	mode := t.Mode
+	if mode == "" {
+		mode = defaultFileMode
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("%w: mode '%s'", errInvalidTarget, t.Mode)
+	}
+
+	return os.FileMode(m), nil
+}
+
+// consumers returns what the target deploys to, which only one certificate
+// may do: the type, every app of the app target or the directory of the file
+// target.
+func (t TargetConfig) consumers() []string {
+	switch t.Type {
+	case targetApp:
+		consumers := make([]string, 0, len(t.Apps))
+		for _, app := range t.Apps {
+			consumers = append(consumers, targetApp+" "+app)
+		}
+		return consumers
+	case targetFile:
+		return []string{targetFile + " " + filepath.Clean(t.Directory)}
+	default:
+		return []string{t.Type}
+	}
+}
+
+// valid returns the problems of a target definition.
+func (t TargetConfig) valid() []error {
+	if !slices.Contains(targetTypes, t.Type) {
+		return []error{fmt.Errorf("%w: '%s'", errUnknownTarget, t.Type)}
+	}
+
+	var errs []error
+	if t.Restart != nil && !slices.Contains([]string{targetS3, targetFTP, targetWebDAV}, t.Type) {
+		errs = append(errs, fmt.Errorf("%w: restart is not supported by '%s'", errInvalidTarget, t.Type))
+	}
+	if t.Verify && t.Type != targetUI {
+		errs = append(errs, fmt.Errorf("%w: verify is only supported by '%s'", errInvalidTarget, targetUI))
+	}
+	if t.TLS && t.Type != targetFTP {
+		errs = append(errs, fmt.Errorf("%w: tls is only supported by '%s'", errInvalidTarget, targetFTP))
+	}
+	if t.Protocol != "" && t.Type != targetWebDAV {
+		errs = append(errs, fmt.Errorf("%w: protocol is only supported by '%s'", errInvalidTarget, targetWebDAV))
+	} else if t.Protocol != "" && !slices.Contains(webDAVProtocols, t.Protocol) {
+		errs = append(errs, fmt.Errorf("%w: protocol '%s' (supported: %s)", errInvalidTarget, t.Protocol, strings.Join(webDAVProtocols, ", ")))
+	}
+
+	switch t.Type {
+	case targetApp:
+		if len(t.Apps) == 0 {
+			errs = append(errs, fmt.Errorf("%w: no apps specified for '%s'", errInvalidTarget, targetApp))
+		}
+		if slices.Contains(t.Apps, "") {
+			errs = append(errs, fmt.Errorf("%w: empty app name", errInvalidTarget))
+		}
+		if slices.Contains(t.valuesPath(), "") {
+			errs = append(errs, fmt.Errorf("%w: path '%s'", errInvalidTarget, t.Path))
+		}
+	case targetFile:
+		if !filepath.IsAbs(t.Directory) {
+			errs = append(errs, fmt.Errorf("%w: directory '%s' is not an absolute path", errInvalidTarget, t.Directory))
+		}
+		if _, err := t.fileMode(); err != nil {
+			errs = append(errs, err)
+		}
+		if user, group, hasGroup := strings.Cut(t.Owner, ":"); t.Owner != "" && (user == "" || hasGroup && group == "") {
+			errs = append(errs, fmt.Errorf("%w: owner '%s'", errInvalidTarget, t.Owner))
+		}
+		if t.PKCS12Password != "" && !t.PKCS12 {
+			errs = append(errs, fmt.Errorf("%w: pkcs12_password without pkcs12", errInvalidTarget))
+		}
+	}
+	if t.Type != targetApp && (len(t.Apps) > 0 || t.Path != "") {
+		errs = append(errs, fmt.Errorf("%w: apps and path are only supported by '%s'", errInvalidTarget, targetApp))
+	}
+	if t.Type != targetFile && (t.Directory != "" || t.Owner != "" || t.Mode != "" || t.PKCS12) {
+		errs = append(errs, fmt.Errorf("%w: directory, owner, mode and pkcs12 are only supported by '%s'", errInvalidTarget, targetFile))
+	}
+
+	return errs
+}
+
+// Policies for a failing hook.
+const (
+	// hookFailureWarn logs the failure.
+	hookFailureWarn = "warn"
+	// hookFailureFail also fails the certificate.
+	hookFailureFail = "fail"
+)
+
+// HookConfig is a command or webhook run after a certificate was deployed to
+// a target.
+type HookConfig struct {
+	hook.Hook
+	// Targets are the target types the hook runs for. It runs after every
+	// target if unset.
+	Targets []string `json:"targets,omitempty"`
+	// OnFailure is the policy for a failing hook, "warn" or "fail". It
+	// defaults to "warn".
+	OnFailure string `json:"on_failure,omitempty"`
+}
+
+// runsFor reports whether the hook runs after a deployment to target.
+func (h *HookConfig) runsFor(target string) bool {
+	return len(h.Targets) == 0 || slices.Contains(h.Targets, target)
+}
+
+// valid returns the problems of a hook definition.
+func (h *HookConfig) valid() []error {
+	var errs []error
+	if err := h.Hook.Valid(); err != nil {
+		errs = append(errs, fmt.Errorf("%w '%s': %w", errInvalidHook, &h.Hook, err))
+	}
+	for _, target := range h.Targets {
+		if !slices.Contains(targetTypes, target) {
+			errs = append(errs, fmt.Errorf("%w '%s': unknown target '%s'", errInvalidHook, &h.Hook, target))
+		}
+	}
+	switch h.OnFailure {
+	case "", hookFailureWarn, hookFailureFail:
+	default:
+		errs = append(errs, fmt.Errorf("%w '%s': on_failure '%s' (supported: %s, %s)", errInvalidHook, &h.Hook, h.OnFailure, hookFailureWarn, hookFailureFail))
+	}
+
+	return errs
+}
+
+// UnmarshalJSON accepts both the object form and the plain type string.
+func (t *TargetConfig) UnmarshalJSON(b []byte) error {
+	var typ string
+	if err := json.Unmarshal(b, &typ); err == nil {
+		*t = TargetConfig{Type: typ}
+		return nil
+	}
+
+	type plain TargetConfig
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return fmt.Errorf("parsing target: %w", err)
+	}
+	*t = TargetConfig(p)
+
+	return nil
+}
+
+// CertificateConfig describes one certificate and the consumers it is
+// deployed to.
+type CertificateConfig struct {
+	// Name identifies the certificate in logs. It defaults to the first domain.
+	Name string `json:"name,omitempty"`
+	// Domains are the names issued together on the certificate, wildcards
+	// such as "*.nas.example.com" included.
+	Domains []string `json:"domains"`
+	// Targets are the consumers receiving the certificate. It defaults to the
+	// web UI if unset; an empty list only keeps the certificate in storage.
+	Targets []TargetConfig `json:"targets,omitempty"`
+	// ACME overrides the DNS-01 solver of [Config.ACME] for this certificate.
+	ACME *SolverConfig `json:"acme,omitempty"`
+	// Hooks run after the certificate was deployed to a target, after the
+	// hooks of [Config.Hooks].
+	Hooks []HookConfig `json:"hooks,omitempty"`
+}
+
+// String returns the name of the certificate.
+func (cc *CertificateConfig) String() string {
+	if cc.Name != "" {
+		return cc.Name
+	}
+	if len(cc.Domains) > 0 {
+		return cc.Domains[0]
+	}
+
+	return ""
+}
+
+// RetryConfig holds the retry policies of the daemon per kind of failure.
+// Unset policies and values fall back to the defaults.
+type RetryConfig struct {
+	// ACME applies to failures obtaining a certificate from the CA.
+	ACME *RetryPolicy `json:"acme,omitempty"`
+	// Connect applies to failures reaching the TrueNAS API.
+	Connect *RetryPolicy `json:"connect,omitempty"`
+	// Deploy applies to failures deploying a certificate to its targets.
+	Deploy *RetryPolicy `json:"deploy,omitempty"`
+}
+
+// RetryPolicy describes how a failed daemon run is retried.
+type RetryPolicy struct {
+	// InitialDelay is the time in seconds before the first retry. It doubles
+	// with every consecutive failure.
+	InitialDelay int `json:"initial_delay,omitempty"`
+	// MaxDelay caps the time in seconds between retries.
+	MaxDelay int `json:"max_delay,omitempty"`
+	// MaxFailures is the number of consecutive failures after which the
+	// daemon gives up and exits.
+	MaxFailures int `json:"max_failures,omitempty"`
+}
+
+// valid returns the problems of the retry policies.
+func (rc *RetryConfig) valid() []error {
+	var errs []error
+	for _, kind := range failureKinds {
+		p := rc.configured(kind)
+		if p == nil {
+			continue
+		}
+		if p.InitialDelay < 0 || p.MaxDelay < 0 || p.MaxFailures < 0 {
+			errs = append(errs, fmt.Errorf("%w '%s': negative value", errInvalidRetry, kind))
+		}
+		if p.InitialDelay > 0 && p.MaxDelay > 0 && p.InitialDelay > p.MaxDelay {
+			errs = append(errs, fmt.Errorf("%w '%s': initial_delay exceeds max_delay", errInvalidRetry, kind))
+		}
+	}
+
+	return errs
+}
+
+// Outcomes of a run the mail summary can be sent for.
+const (
+	// mailOnSuccess sends the summary after a run that changed certificates.
+	mailOnSuccess = "success"
+	// mailOnFailure sends the summary after a failed run.
+	mailOnFailure = "failure"
+)
+
+// AlertConfig raises a TrueNAS alert while a run fails and clears it once a
+// run succeeds, so failures show up in the TrueNAS alerts and its alert
+// services.
+type AlertConfig struct {
+	// Class is the one-shot alert class the alert is raised with, one of
+	// [alertClasses]. It defaults to [defaultAlertClass]. Its arguments are a
+	// dict of the id and name truenas-scale-acme and the error as message.
+	//
+	// TrueNAS has no one-shot class for the alerts of other tools, so the
+	// alert reads like a failed task of the class, e.g. "Replication
+	// truenas-scale-acme failed: <error>".
+	Class string `json:"class,omitempty"`
+}
+
+// defaultAlertClass is the default of [AlertConfig.Class], the class showing
+// the error of the run.
+const defaultAlertClass = "ReplicationFailed"
+
+// alertClasses are the supported values of [AlertConfig.Class], the one-shot
+// alert classes of TrueNAS keyed by id whose text formats the name.
+var alertClasses = []string{defaultAlertClass, "CloudSyncTaskFailed"}
+
+// class returns the class the alert is raised with.
+func (ac *AlertConfig) class() string {
+	if ac.Class == "" {
+		return defaultAlertClass
+	}
+
+	return ac.Class
+}
+
+// MailConfig sends a summary of a run with the TrueNAS mail service.
+type MailConfig struct {
+	// On are the outcomes the summary is sent for, "success" or "failure". It
+	// defaults to "failure".
+	On []string `json:"on,omitempty"`
+	// To are the recipients. The summary is sent to the email address of the
+	// TrueNAS administrator if it is unset.
+	To []string `json:"to,omitempty"`
+}
+
+// sendsFor reports whether the summary of a run failing with err is sent.
+func (mc *MailConfig) sendsFor(err error) bool {
+	on := mc.On
+	if len(on) == 0 {
+		on = []string{mailOnFailure}
+	}
+	if err != nil {
+		return slices.Contains(on, mailOnFailure)
+	}
+
+	return slices.Contains(on, mailOnSuccess)
+}
+
+// valid returns the problems of the mail configuration.
+func (mc *MailConfig) valid() []error {
+	var errs []error
+	for _, on := range mc.On {
+		if on != mailOnSuccess && on != mailOnFailure {
+			errs = append(errs, fmt.Errorf("%w: on '%s' (supported: %s, %s)", errInvalidMail, on, mailOnSuccess, mailOnFailure))
+		}
+	}
+	for _, to := range mc.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			errs = append(errs, fmt.Errorf("%w: to '%s': %w", errInvalidMail, to, err))
+		}
+	}
+
+	return errs
+}
+
+// defaultExpiringSoon is how many days before its expiry a certificate that
+// could not be renewed is reported as expiring soon.
+const defaultExpiringSoon = 14
+
+// NotifyConfig sends the events of the runs to notification services.
+type NotifyConfig struct {
+	// Sinks are the services the events are sent to.
+	Sinks []notify.Sink `json:"sinks"`
+	// ExpiringSoon is how many days before its expiry a certificate that
+	// could not be renewed is reported as expiring soon. It defaults to
+	// [defaultExpiringSoon].
+	ExpiringSoon int `json:"expiring_soon,omitempty"`
+}
+
+// expiringSoon returns how long before its expiry a certificate is expiring
+// soon.
+func (nc *NotifyConfig) expiringSoon() time.Duration {
+	days := defaultExpiringSoon
+	if nc != nil && nc.ExpiringSoon > 0 {
+		days = nc.ExpiringSoon
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// valid returns the problems of the notify configuration.
+func (nc *NotifyConfig) valid() []error {
+	var errs []error
+	if nc.ExpiringSoon < 0 {
+		errs = append(errs, fmt.Errorf("%w: negative expiring_soon", errInvalidNotify))
+	}
+	for i := range nc.Sinks {
+		if err := nc.Sinks[i].Valid(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", errInvalidNotify, err))
+		}
+	}
+
+	return errs
+}
+
 // Config is the on-disk configuration of the command.
 type Config struct {
-	Domain string `json:"domain"`
+	// Certificates are the certificates to obtain and deploy.
+	Certificates []CertificateConfig `json:"certificates"`
+	// Domain is the single name the certificate is issued for.
+	//
+	// Deprecated: Use [Config.Certificates] instead.
+	Domain string `json:"domain,omitempty"`
 	// API is the configuration for the TrueNAS JSONRPC 2.0 WebSocket API.
 	API *APIConfig `json:"api"`
 	// Scale is the configuration for the TrueNAS SCALE REST API.
@@ -72,6 +746,26 @@ type Config struct {
 	// Deprecated: Use [Config.API] instead.
 	Scale *APIConfig `json:"scale"`
 	ACME  ACMEConfig `json:"acme"`
+	// Hooks run after any certificate was deployed to a target.
+	Hooks []HookConfig `json:"hooks,omitempty"`
+	// Retry configures how the daemon retries failed runs.
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Alert raises a TrueNAS alert while a run fails.
+	Alert *AlertConfig `json:"alert,omitempty"`
+	// Mail sends a summary of a run with the TrueNAS mail service.
+	Mail *MailConfig `json:"mail,omitempty"`
+	// Notify sends the events of the runs to notification services.
+	Notify *NotifyConfig `json:"notify,omitempty"`
+}
+
+// targets returns the targets of all certificates.
+func (c *Config) targets() []TargetConfig {
+	var targets []TargetConfig
+	for _, cert := range c.Certificates {
+		targets = append(targets, cert.Targets...)
+	}
+
+	return targets
 }
 
 func defaultConfigPath() string {
@@ -111,6 +805,9 @@ func (c *Config) Merge(r io.Reader) error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
+	if len(cf.Certificates) > 0 {
+		c.Certificates = cf.Certificates
+	}
 	if cf.Domain != "" {
 		c.Domain = cf.Domain
 	}
@@ -131,6 +828,21 @@ func (c *Config) Merge(r io.Reader) error {
 	if cf.Scale != nil {
 		c.Scale = cf.Scale
 	}
+	if len(cf.Hooks) > 0 {
+		c.Hooks = cf.Hooks
+	}
+	if cf.Retry != nil {
+		c.Retry = cf.Retry
+	}
+	if cf.Alert != nil {
+		c.Alert = cf.Alert
+	}
+	if cf.Mail != nil {
+		c.Mail = cf.Mail
+	}
+	if cf.Notify != nil {
+		c.Notify = cf.Notify
+	}
 
 	if cf.ACME.Email != "" {
 		c.ACME.Email = cf.ACME.Email
@@ -138,12 +850,33 @@ func (c *Config) Merge(r io.Reader) error {
 	if cf.ACME.TOSAgreed {
 		c.ACME.TOSAgreed = cf.ACME.TOSAgreed
 	}
+	if cf.ACME.Profile != "" {
+		c.ACME.Profile = cf.ACME.Profile
+	}
+	if cf.ACME.KeyType != "" {
+		c.ACME.KeyType = cf.ACME.KeyType
+	}
+	if cf.ACME.ReusePrivateKeys {
+		c.ACME.ReusePrivateKeys = cf.ACME.ReusePrivateKeys
+	}
+	if cf.ACME.Staging {
+		c.ACME.Staging = cf.ACME.Staging
+	}
+	if cf.ACME.ForceStaging {
+		c.ACME.ForceStaging = cf.ACME.ForceStaging
+	}
 	if len(cf.ACME.Resolvers) > 0 {
 		c.ACME.Resolvers = cf.ACME.Resolvers
 	}
+	if len(cf.ACME.Issuers) > 0 {
+		c.ACME.Issuers = cf.ACME.Issuers
+	}
 	if cf.ACME.Storage != "" {
 		c.ACME.Storage = cf.ACME.Storage
 	}
+	if cf.ACME.DNS != nil {
+		c.ACME.DNS = cf.ACME.DNS
+	}
 	if cf.ACME.ACMEDNS != nil {
 		c.ACME.ACMEDNS = cf.ACME.ACMEDNS
 	}
@@ -165,13 +898,75 @@ func (c *Config) Write(w io.Writer) error {
 	return nil
 }
 
+// setDefaults fills in the settings that have a default but were not
+// configured.
+func (c *Config) setDefaults() {
+	for i := range c.Certificates {
+		if c.Certificates[i].Targets == nil {
+			c.Certificates[i].Targets = []TargetConfig{{Type: targetUI}}
+		}
+	}
+}
+
 // Valid reports every problem that prevents the configuration from being used,
 // joined into a single error.
 func (c *Config) Valid() error {
 	errs := []error{}
 
-	if c.Domain == "" {
-		errs = append(errs, errNoDomain)
+	if len(c.Certificates) == 0 {
+		errs = append(errs, errNoCertificates)
+	}
+	for i := range c.Hooks {
+		errs = append(errs, c.Hooks[i].valid()...)
+	}
+	if c.Retry != nil {
+		errs = append(errs, c.Retry.valid()...)
+	}
+	if c.Alert != nil && c.Alert.Class != "" && !slices.Contains(alertClasses, c.Alert.Class) {
+		errs = append(errs, fmt.Errorf("%w: class '%s' (supported: %s)", errInvalidAlert, c.Alert.Class, strings.Join(alertClasses, ", ")))
+	}
+	if c.Mail != nil {
+		errs = append(errs, c.Mail.valid()...)
+	}
+	if c.Notify != nil {
+		errs = append(errs, c.Notify.valid()...)
+	}
+	names := map[string]bool{}
+	targets := map[string]string{}
+	for i := range c.Certificates {
+		cert := &c.Certificates[i]
+		if names[cert.String()] {
+			errs = append(errs, fmt.Errorf("%w: '%s'", errDuplicateName, cert))
+		}
+		names[cert.String()] = true
+
+		for _, err := range cert.valid() {
+			errs = append(errs, fmt.Errorf("certificate '%s': %w", cert, err))
+		}
+		if cert.ACME != nil {
+			for _, resolver := range cert.ACME.Resolvers {
+				if !isResolver(resolver) {
+					errs = append(errs, fmt.Errorf("certificate '%s': %w: '%s'", cert, errInvalidResolvers, resolver))
+				}
+			}
+		}
+		acme := c.ACME.withSolver(cert.ACME)
+		if acme.DNS != nil {
+			if err := acme.DNS.Valid(); err != nil {
+				errs = append(errs, fmt.Errorf("certificate '%s': %w", cert, err))
+			}
+		} else if _, err := acme.DNSProvider(); err != nil {
+			errs = append(errs, fmt.Errorf("certificate '%s': %w", cert, err))
+		}
+
+		for _, target := range cert.Targets {
+			for _, consumer := range target.consumers() {
+				if other, ok := targets[consumer]; ok {
+					errs = append(errs, fmt.Errorf("%w: '%s' (%s, %s)", errDuplicateTarget, consumer, other, cert))
+				}
+				targets[consumer] = cert.String()
+			}
+		}
 	}
 
 	if c.API == nil {
@@ -186,7 +981,7 @@ func (c *Config) Valid() error {
 	}
 
 	for _, resolver := range c.ACME.Resolvers {
-		if ip := net.ParseIP(resolver); ip == nil {
+		if !isResolver(resolver) {
 			errs = append(errs, fmt.Errorf("%w: '%s'", errInvalidResolvers, resolver))
 		}
 	}
@@ -195,8 +990,14 @@ func (c *Config) Valid() error {
 		errs = append(errs, errNoACMEEmail)
 	}
 
-	if _, err := c.ACME.DNSProvider(); err != nil {
-		errs = append(errs, err)
+	for i := range c.ACME.Issuers {
+		errs = append(errs, c.ACME.Issuers[i].valid()...)
+	}
+	if c.ACME.KeyType != "" && !slices.Contains(keyTypes, certmagic.KeyType(c.ACME.KeyType)) {
+		errs = append(errs, fmt.Errorf("%w: '%s' (supported: %s)", errInvalidKeyType, c.ACME.KeyType, joinKeyTypes(keyTypes)))
+	}
+	if c.ACME.Staging && len(c.ACME.issuers()) == 0 {
+		errs = append(errs, errNoStagingIssuer)
 	}
 
 	if len(errs) != 0 {
@@ -206,6 +1007,29 @@ func (c *Config) Valid() error {
 	return nil
 }
 
+// valid returns the problems of a single certificate definition.
+func (cc *CertificateConfig) valid() []error {
+	var errs []error
+
+	if len(cc.Domains) == 0 {
+		errs = append(errs, errNoDomain)
+	}
+	for _, domain := range cc.Domains {
+		if !certmagic.SubjectQualifiesForCert(domain) || certmagic.SubjectIsIP(domain) {
+			errs = append(errs, fmt.Errorf("%w: '%s'", errInvalidDomain, domain))
+		}
+	}
+
+	for _, target := range cc.Targets {
+		errs = append(errs, target.valid()...)
+	}
+	for i := range cc.Hooks {
+		errs = append(errs, cc.Hooks[i].valid()...)
+	}
+
+	return errs
+}
+
 func (c cmd) loadConfig(path string) (*Config, error) {
 	flags := os.O_RDONLY
 
@@ -245,13 +1069,23 @@ func (c cmd) loadConfig(path string) (*Config, error) {
 	}
 
 	config = c.handleDeprecatedConfig(config)
+	config.setDefaults()
 
 	return &config, config.Valid()
 }
 
 func (c cmd) handleDeprecatedConfig(conf Config) Config {
+	if len(conf.Certificates) == 0 && conf.Domain != "" {
+		c.CLILogger.Warn("config is using deprecated domain field, use certificates instead", zap.String("domain", conf.Domain))
+		conf.Certificates = []CertificateConfig{{
+			Domains: []string{conf.Domain},
+			Targets: []TargetConfig{{Type: targetUI}},
+		}}
+	}
+	conf.Domain = ""
+
 	if conf.API == nil && conf.Scale != nil {
-		c.CLILogger.Warn("config is using deprecated scale fields", zap.String("domain", conf.Domain))
+		c.CLILogger.Warn("config is using deprecated scale fields")
 		apiURL := defaultURL
 		if conf.Scale.URL != "" {
 			old, err := url.Parse(conf.Scale.URL)
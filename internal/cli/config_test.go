@@ -1,12 +1,18 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/libdns/acmedns"
 	"github.com/libdns/cloudflare"
+	"github.com/thde/truenas-scale-acme/internal/notify"
 )
 
 func TestACMEConfig_DNSProvider(t *testing.T) {
@@ -46,3 +52,322 @@ func TestACMEConfig_DNSProvider(t *testing.T) {
 		})
 	}
 }
+
+func Test_handleDeprecatedConfig_domain(t *testing.T) {
+	t.Parallel()
+
+	got := newTestCmd().handleDeprecatedConfig(Config{Domain: "nas.example.com"})
+	want := []CertificateConfig{{
+		Domains: []string{"nas.example.com"},
+		Targets: []TargetConfig{{Type: targetUI}},
+	}}
+	if !reflect.DeepEqual(got.Certificates, want) {
+		t.Errorf("handleDeprecatedConfig() Certificates = %+v, want %+v", got.Certificates, want)
+	}
+	if got.Domain != "" {
+		t.Errorf("handleDeprecatedConfig() Domain = %q, want empty", got.Domain)
+	}
+}
+
+func TestConfig_Valid_domains(t *testing.T) {
+	t.Parallel()
+
+	cfg := exampleConfig
+	cfg.Certificates = []CertificateConfig{{
+		Domains: []string{"nas.example.com", "*.nas.example.com", "192.0.2.1", ""},
+	}}
+
+	err := cfg.Valid()
+	if !errors.Is(err, errInvalidDomain) {
+		t.Fatalf("Valid() error = %v, want %v", err, errInvalidDomain)
+	}
+	for _, want := range []string{"'192.0.2.1'", "''"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Valid() error = %v, want it to mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "'*.nas.example.com'") {
+		t.Errorf("Valid() rejected wildcard: %v", err)
+	}
+}
+
+func TestConfig_Valid_certificates(t *testing.T) {
+	t.Parallel()
+
+	cfg := exampleConfig
+	cfg.ACME.DNS = nil
+	cfg.Certificates = []CertificateConfig{
+		{Name: "ui", Domains: []string{"nas.example.com"}, Targets: []TargetConfig{{Type: targetUI}}, ACME: &SolverConfig{Cloudflare: &cloudflare.Provider{}}},
+		{Name: "ui", Domains: []string{"s3.example.com"}, Targets: []TargetConfig{{Type: targetUI}, {Type: "nope"}}, ACME: &SolverConfig{Resolvers: []string{"dns.example.com"}}},
+	}
+
+	err := cfg.Valid()
+	for _, want := range []error{errDuplicateName, errDuplicateTarget, errUnknownTarget, errNoSolver, errInvalidResolvers} {
+		if !errors.Is(err, want) {
+			t.Errorf("Valid() error = %v, want %v", err, want)
+		}
+	}
+}
+
+func TestConfig_Valid_apps(t *testing.T) {
+	t.Parallel()
+
+	cfg := exampleConfig
+	cfg.Certificates = []CertificateConfig{
+		{Domains: []string{"cloud.example.com"}, Targets: []TargetConfig{{Type: targetApp, Apps: []string{"nextcloud"}}}},
+		{Domains: []string{"photos.example.com"}, Targets: []TargetConfig{{Type: targetApp, Apps: []string{"immich"}}}},
+	}
+	if err := cfg.Valid(); err != nil {
+		t.Fatalf("Valid() error = %v", err)
+	}
+
+	cfg.Certificates[1].Targets[0].Apps = append(cfg.Certificates[1].Targets[0].Apps, "nextcloud")
+	err := cfg.Valid()
+	if !errors.Is(err, errDuplicateTarget) || !strings.Contains(err.Error(), "'app nextcloud'") {
+		t.Errorf("Valid() error = %v, want %v for 'app nextcloud'", err, errDuplicateTarget)
+	}
+}
+
+func TestTargetConfig_UnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	var got []TargetConfig
+	if err := json.Unmarshal([]byte(`["ui", {"type": "ui"}]`), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := []TargetConfig{{Type: targetUI}, {Type: targetUI}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, want)
+	}
+}
+
+func TestACMEConfig_issuers(t *testing.T) {
+	t.Parallel()
+
+	if got := (&ACMEConfig{}).issuers(); !reflect.DeepEqual(got, defaultIssuers) {
+		t.Errorf("issuers() = %+v, want defaults %+v", got, defaultIssuers)
+	}
+
+	ac := &ACMEConfig{Issuers: []IssuerConfig{
+		{Directory: "https://c.example.com/directory", Priority: 2},
+		{Directory: "https://a.example.com/directory"},
+		{Directory: "https://b.example.com/directory"},
+	}}
+	var got []string
+	for _, ic := range ac.issuers() {
+		got = append(got, ic.Directory)
+	}
+	want := []string{"https://a.example.com/directory", "https://b.example.com/directory", "https://c.example.com/directory"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issuers() = %v, want %v", got, want)
+	}
+}
+
+func TestConfig_Valid_issuers(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		issuer  IssuerConfig
+		wantErr bool
+	}{
+		{"valid", IssuerConfig{Directory: "https://ca.example.com/acme/directory", EAB: &EABConfig{KeyID: "kid", MACKey: "mac"}}, false},
+		{"relative directory", IssuerConfig{Directory: "/acme/directory"}, true},
+		{"no directory", IssuerConfig{}, true},
+		{"incomplete eab", IssuerConfig{Directory: "https://ca.example.com/acme/directory", EAB: &EABConfig{KeyID: "kid"}}, true},
+		{"missing bundle", IssuerConfig{Directory: "https://ca.example.com/acme/directory", CABundle: filepath.Join(dir, "missing.pem")}, true},
+		{"empty bundle", IssuerConfig{Directory: "https://ca.example.com/acme/directory", CABundle: empty}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := exampleConfig
+			cfg.ACME.Issuers = []IssuerConfig{tt.issuer}
+			if err := cfg.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACMEConfig_issuers_staging(t *testing.T) {
+	t.Parallel()
+
+	ac := &ACMEConfig{Staging: true}
+	want := []IssuerConfig{{Directory: certmagic.LetsEncryptStagingCA}}
+	if got := ac.issuers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("issuers() = %+v, want %+v", got, want)
+	}
+	if got, want := ac.storage(), "staging"; filepath.Base(got) != want {
+		t.Errorf("storage() = %s, want it to end in %s", got, want)
+	}
+
+	ac.Issuers = []IssuerConfig{
+		{Directory: "https://ca.example.com/directory", StagingDirectory: "https://staging.ca.example.com/directory"},
+		{Directory: "https://other.example.com/directory"},
+	}
+	want = []IssuerConfig{{Directory: "https://staging.ca.example.com/directory", StagingDirectory: "https://staging.ca.example.com/directory"}}
+	if got := ac.issuers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("issuers() = %+v, want %+v", got, want)
+	}
+
+	cfg := exampleConfig
+	cfg.ACME.Staging = true
+	cfg.ACME.Issuers = []IssuerConfig{{Directory: certmagic.ZeroSSLProductionCA}}
+	if err := cfg.Valid(); !errors.Is(err, errNoStagingIssuer) {
+		t.Errorf("Valid() error = %v, want %v", err, errNoStagingIssuer)
+	}
+}
+
+func TestConfig_Valid_keyType(t *testing.T) {
+	t.Parallel()
+
+	for _, kt := range keyTypes {
+		cfg := exampleConfig
+		cfg.ACME.KeyType = string(kt)
+		if err := cfg.Valid(); err != nil {
+			t.Errorf("Valid() with key_type %s error = %v", kt, err)
+		}
+	}
+
+	cfg := exampleConfig
+	cfg.ACME.KeyType = "dsa"
+	if err := cfg.Valid(); !errors.Is(err, errInvalidKeyType) {
+		t.Errorf("Valid() error = %v, want %v", err, errInvalidKeyType)
+	}
+}
+
+func Test_isResolver(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		resolver string
+		want     bool
+	}{
+		{"9.9.9.9", true},
+		{"2620:fe::fe", true},
+		{"127.0.0.1:5353", true},
+		{"[2620:fe::fe]:53", true},
+		{"dns.quad9.net", false},
+		{"dns.quad9.net:53", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isResolver(tt.resolver); got != tt.want {
+			t.Errorf("isResolver(%q) = %v, want %v", tt.resolver, got, tt.want)
+		}
+	}
+}
+
+func TestTargetConfig_valid(t *testing.T) {
+	t.Parallel()
+
+	no := false
+	tests := []struct {
+		name    string
+		target  TargetConfig
+		wantErr error
+	}{
+		{"ui", TargetConfig{Type: targetUI}, nil},
+		{"ui verify", TargetConfig{Type: targetUI, Verify: true}, nil},
+		{"s3 verify", TargetConfig{Type: targetS3, Verify: true}, errInvalidTarget},
+		{"ftp tls", TargetConfig{Type: targetFTP, TLS: true, Restart: &no}, nil},
+		{"webdav protocol", TargetConfig{Type: targetWebDAV, Protocol: "HTTPHTTPS"}, nil},
+		{"unknown", TargetConfig{Type: "smb"}, errUnknownTarget},
+		{"ui restart", TargetConfig{Type: targetUI, Restart: &no}, errInvalidTarget},
+		{"s3 tls", TargetConfig{Type: targetS3, TLS: true}, errInvalidTarget},
+		{"ftp protocol", TargetConfig{Type: targetFTP, Protocol: "HTTPS"}, errInvalidTarget},
+		{"webdav http", TargetConfig{Type: targetWebDAV, Protocol: "HTTP"}, errInvalidTarget},
+		{"app", TargetConfig{Type: targetApp, Apps: []string{"nextcloud"}, Path: "tls.certificate_id"}, nil},
+		{"app without apps", TargetConfig{Type: targetApp}, errInvalidTarget},
+		{"app restart", TargetConfig{Type: targetApp, Apps: []string{"nextcloud"}, Restart: &no}, errInvalidTarget},
+		{"app path", TargetConfig{Type: targetApp, Apps: []string{"nextcloud"}, Path: "network."}, errInvalidTarget},
+		{"ui apps", TargetConfig{Type: targetUI, Apps: []string{"nextcloud"}}, errInvalidTarget},
+		{"file", TargetConfig{Type: targetFile, Directory: "/mnt/tank/certs", Owner: "apps:apps", Mode: "0640", PKCS12: true}, nil},
+		{"file relative", TargetConfig{Type: targetFile, Directory: "certs"}, errInvalidTarget},
+		{"file mode", TargetConfig{Type: targetFile, Directory: "/mnt/tank/certs", Mode: "rw-r-----"}, errInvalidTarget},
+		{"file owner", TargetConfig{Type: targetFile, Directory: "/mnt/tank/certs", Owner: "apps:"}, errInvalidTarget},
+		{"file restart", TargetConfig{Type: targetFile, Directory: "/mnt/tank/certs", Restart: &no}, errInvalidTarget},
+		{"s3 directory", TargetConfig{Type: targetS3, Directory: "/mnt/tank/certs"}, errInvalidTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := errors.Join(tt.target.valid()...)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("valid() error = %v, want none", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("valid() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Valid_retry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		retry   *RetryConfig
+		wantErr error
+	}{
+		{"unset", nil, nil},
+		{"partial", &RetryConfig{Connect: &RetryPolicy{MaxFailures: 3}}, nil},
+		{"negative", &RetryConfig{ACME: &RetryPolicy{InitialDelay: -1}}, errInvalidRetry},
+		{"initial exceeds max", &RetryConfig{Deploy: &RetryPolicy{InitialDelay: 60, MaxDelay: 30}}, errInvalidRetry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := exampleConfig
+			cfg.Retry = tt.retry
+			if err := cfg.Valid(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Valid() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Valid_notifications(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		alert   *AlertConfig
+		mail    *MailConfig
+		notify  *NotifyConfig
+		wantErr error
+	}{
+		{"unset", nil, nil, nil, nil},
+		{"alert", &AlertConfig{Class: "ReplicationFailed"}, nil, nil, nil},
+		{"alert without class", &AlertConfig{}, nil, nil, nil},
+		{"alert of unsupported class", &AlertConfig{Class: "CertificateRenewalFailed"}, nil, nil, errInvalidAlert},
+		{"mail", nil, &MailConfig{On: []string{mailOnSuccess, mailOnFailure}, To: []string{"admin@example.com"}}, nil, nil},
+		{"mail unknown outcome", nil, &MailConfig{On: []string{"always"}}, nil, errInvalidMail},
+		{"mail invalid recipient", nil, &MailConfig{To: []string{"admin"}}, nil, errInvalidMail},
+		{"notify", nil, nil, &NotifyConfig{Sinks: []notify.Sink{{Type: notify.TypeNtfy, URL: "https://ntfy.sh/nas"}}, ExpiringSoon: 7}, nil},
+		{"notify invalid sink", nil, nil, &NotifyConfig{Sinks: []notify.Sink{{Type: notify.TypeSlack}}}, errInvalidNotify},
+		{"notify negative expiring soon", nil, nil, &NotifyConfig{ExpiringSoon: -1}, errInvalidNotify},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := exampleConfig
+			cfg.Alert, cfg.Mail, cfg.Notify = tt.alert, tt.mail, tt.notify
+			if err := cfg.Valid(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Valid() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/cron"
+	"github.com/thde/truenas-scale-acme/internal/notify"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+)
+
+// daemonStatusFile is the file in the ACME storage the daemon reports the
+// outcome of its runs to.
+const daemonStatusFile = "daemon.json"
+
+// daemonStatus is the outcome of the last runs of the daemon.
+type daemonStatus struct {
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	// LastError is the error of the last run, if it failed.
+	LastError string `json:"last_error,omitempty"`
+	// Failures are the consecutive failures per kind.
+	Failures map[string]int `json:"failures,omitempty"`
+	// NextRetry is set while a failed run is waiting to be retried.
+	NextRetry time.Time `json:"next_retry,omitzero"`
+	// NextRun is the next run, on the schedule, for a renewal or a retry.
+	NextRun time.Time `json:"next_run,omitzero"`
+	// UICertificateID is the ID of the UI certificate after the last
+	// successful run.
+	UICertificateID int `json:"ui_certificate_id,omitempty"`
+}
+
+// daemonStatusPath returns the path of the daemon status of config.
+func daemonStatusPath(config *Config) string {
+	return filepath.Join(config.ACME.storage(), daemonStatusFile)
+}
+
+// readDaemonStatus reads the daemon status at path. It returns
+// [fs.ErrNotExist] if the daemon never ran.
+func readDaemonStatus(path string) (*daemonStatus, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading daemon status %s: %w", path, err)
+	}
+
+	var status daemonStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("error parsing daemon status %s: %w", path, err)
+	}
+
+	return &status, nil
+}
+
+// write stores the status at path.
+func (s *daemonStatus) write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding daemon status: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), configDirPerm); err != nil {
+		return fmt.Errorf("error creating %s: %w", filepath.Dir(path), err)
+	}
+
+	return writeFileAtomic(path, data, configFilePerm, -1, -1)
+}
+
+// runDaemon runs the daemon. TrueNAS is connected to by the daemon, so it
+// keeps retrying while TrueNAS is unreachable.
+func (c cmd) runDaemon(ctx context.Context, opts *options) error {
+	config, err := c.config(opts)
+	if err != nil {
+		return err
+	}
+	certs, err := c.managedCertificates(config)
+	if err != nil {
+		return err
+	}
+
+	return c.daemon(ctx, config, certs, opts)
+}
+
+// daemon ensures the certificates on the cron schedule and whenever one is due
+// for renewal, until ctx is cancelled. A failed run is retried with the backoff
+// of the retry policy of its kind of failure, the daemon gives up once a kind
+// failed too many times in a row. With --listen, the state and the metrics of
+// the daemon are served over HTTP.
+func (c cmd) daemon(ctx context.Context, config *Config, certs []managedCertificate, opts *options) error {
+	c.CLILogger.Info("daemon mode enabled", zap.String("schedule", opts.schedule))
+
+	ticker, err := cron.NewTickerWithLocation(opts.schedule, time.Local)
+	if err != nil {
+		return fmt.Errorf("error parsing schedule %s: %w", opts.schedule, err)
+	}
+	defer ticker.Stop()
+
+	// the notifier lives as long as the daemon, for the rate limits to span
+	// runs
+	c.notifier = c.newNotifier(config)
+	state := &daemonState{}
+	if opts.listen != "" {
+		c.metrics = newMetrics()
+		srv, err := c.serve(ctx, opts.listen, state, certs)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	var tnClient *truenas.Client
+	defer func() {
+		if tnClient != nil {
+			tnClient.Close()
+		}
+	}()
+
+	retry := newRetryState(config.Retry)
+	statusPath := daemonStatusPath(config)
+	status := &daemonStatus{}
+	for {
+		status.LastRun = time.Now()
+		err := c.daemonRun(ctx, config, certs, &tnClient, status)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var retryAfter <-chan time.Time
+		status.NextRetry = time.Time{}
+		if err != nil {
+			delay, giveUp := retry.failed(err)
+			status.LastError, status.Failures = err.Error(), maps.Clone(retry.failures)
+			if giveUp {
+				c.CLILogger.Error("giving up after consecutive failures", zap.Any("failures", retry.failures), zap.Error(err))
+				c.writeDaemonStatus(status, statusPath)
+				return fmt.Errorf("%w: %w", errTooManyFailures, err)
+			}
+
+			status.NextRetry = time.Now().Add(delay)
+			c.CLILogger.Warn("run failed, retrying",
+				zap.Strings("kinds", failureKindsOf(err)),
+				zap.Any("failures", retry.failures),
+				zap.Duration("delay", delay),
+				zap.Time("at", status.NextRetry),
+				zap.Error(err),
+			)
+			retryAfter = time.After(delay)
+		} else {
+			retry.succeeded()
+			status.LastSuccess, status.LastError, status.Failures = status.LastRun, "", nil
+		}
+
+		status.NextRun = status.NextRetry
+		if next, err := cron.Next(opts.schedule, time.Local, time.Now()); err == nil && (status.NextRun.IsZero() || next.Before(status.NextRun)) {
+			status.NextRun = next
+		}
+
+		// besides the schedule, wake up when a certificate is due for renewal
+		// or its CA wants to be asked for a new renewal window
+		var renewalCheck <-chan time.Time
+		if next := nextRenewalCheck(certs); !next.IsZero() && retryAfter == nil {
+			c.CLILogger.Info("next renewal check", zap.Time("at", next))
+			wait := max(time.Until(next), minRenewalCheckInterval)
+			renewalCheck = time.After(wait)
+			if at := time.Now().Add(wait); at.Before(status.NextRun) {
+				status.NextRun = at
+			}
+		}
+
+		state.set(status, tnClient != nil)
+		c.writeDaemonStatus(status, statusPath)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-renewalCheck:
+			c.CLILogger.Info("renewal check due")
+		case <-retryAfter:
+			c.CLILogger.Info("retrying failed run")
+		}
+	}
+}
+
+// daemonRun ensures the certificates once, connecting to TrueNAS first if
+// there is no connection, and records the UI certificate in status. The
+// connection is dropped if TrueNAS became unreachable, so the next run
+// connects again.
+func (c cmd) daemonRun(ctx context.Context, config *Config, certs []managedCertificate, tnClient **truenas.Client, status *daemonStatus) error {
+	if *tnClient == nil {
+		client, err := c.dial(ctx, config)
+		if err != nil {
+			event := notify.NewEvent(notify.EventFailed, "error connecting to TrueNAS")
+			event.Error = err.Error()
+			c.sendEvents(ctx, event)
+			return fmt.Errorf("%w: %w", errConnectFailure, err)
+		}
+		*tnClient = client
+	}
+
+	err := c.ensureAndNotify(ctx, config, certs, *tnClient)
+	if err == nil {
+		settings, serr := (*tnClient).SystemGeneralConfig(ctx)
+		if serr != nil {
+			err = deployFailure(fmt.Errorf("error reading system configuration: %w", serr))
+		} else if settings.UICertificate != nil {
+			status.UICertificateID = settings.UICertificate.ID
+		}
+	}
+	if errors.Is(err, errConnectFailure) {
+		(*tnClient).Close()
+		*tnClient = nil
+	}
+
+	return err
+}
+
+// writeDaemonStatus stores status at path, a failure is only logged.
+func (c cmd) writeDaemonStatus(status *daemonStatus, path string) {
+	if err := status.write(path); err != nil {
+		c.CLILogger.Warn("error writing daemon status", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// printDaemonStatus prints the daemon status of config, if the daemon ran.
+func (c cmd) printDaemonStatus(w io.Writer, config *Config) error {
+	status, err := readDaemonStatus(daemonStatusPath(config))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("error printing daemon status: %w", err)
+	}
+
+	fmt.Fprintf(w, "Daemon:\tlast run %s, last success %s\n", formatTime(status.LastRun), formatTime(status.LastSuccess))
+	if status.LastError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", status.LastError)
+		for _, kind := range failureKinds {
+			if n := status.Failures[kind]; n > 0 {
+				fmt.Fprintf(w, "Failures:\t%d consecutive %s failures\n", n, kind)
+			}
+		}
+	}
+	if !status.NextRetry.IsZero() {
+		fmt.Fprintf(w, "Next retry:\t%s\n", formatTime(status.NextRetry))
+	} else if !status.NextRun.IsZero() {
+		fmt.Fprintf(w, "Next run:\t%s\n", formatTime(status.NextRun))
+	}
+
+	return nil
+}
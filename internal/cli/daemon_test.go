@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func newTestDaemonConfig(t *testing.T, srv *truenastest.Server) *Config {
+	t.Helper()
+
+	return &Config{
+		API:   &APIConfig{APIKey: truenastest.DefaultAPIKey, URL: srv.URL.String()},
+		ACME:  ACMEConfig{Storage: t.TempDir()},
+		Retry: &RetryConfig{Connect: &RetryPolicy{InitialDelay: 1, MaxFailures: 2}},
+	}
+}
+
+func Test_daemon_giveUp(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	srv.SetUnavailable(true)
+	t.Cleanup(srv.Close)
+	config := newTestDaemonConfig(t, srv)
+
+	err := newTestCmd().daemon(t.Context(), config, nil, &options{schedule: "@yearly"})
+	if !errors.Is(err, errTooManyFailures) || !errors.Is(err, errConnectFailure) {
+		t.Fatalf("daemon() error = %v, want %v and %v", err, errTooManyFailures, errConnectFailure)
+	}
+
+	status, err := readDaemonStatus(daemonStatusPath(config))
+	if err != nil {
+		t.Fatalf("readDaemonStatus() error = %v", err)
+	}
+	if status.LastError == "" || status.Failures[failureConnect] != 2 || !status.LastSuccess.IsZero() {
+		t.Errorf("daemon status = %+v, want 2 connect failures", status)
+	}
+}
+
+func Test_daemon_recover(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	srv.SetUnavailable(true)
+	t.Cleanup(srv.Close)
+	config := newTestDaemonConfig(t, srv)
+	config.Retry.Connect.MaxFailures = 5
+	time.AfterFunc(500*time.Millisecond, func() { srv.SetUnavailable(false) })
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- newTestCmd().daemon(ctx, config, nil, &options{schedule: "@yearly"}) }()
+
+	path := daemonStatusPath(config)
+	for {
+		status, err := readDaemonStatus(path)
+		if err == nil && !status.LastSuccess.IsZero() {
+			if status.LastError != "" || len(status.Failures) != 0 || !status.NextRetry.IsZero() {
+				t.Errorf("daemon status = %+v, want the failures reset", status)
+			}
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("daemon() exited with %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("daemon() error = %v", err)
+	}
+}
+
+func Test_ensureCertificate_acmeFailure(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDeployment(t, "nas.example.com")
+	cert := managedCertificate{
+		CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com"}, Targets: []TargetConfig{{Type: targetUI}}},
+		acmeClient:        newTestMagic(t),
+		renewal:           &renewalInfo{},
+	}
+
+	if err := newTestCmd().ensureCertificate(t.Context(), cert, d.client); !errors.Is(err, errACMEFailure) {
+		t.Errorf("ensureCertificate() error = %v, want %v", err, errACMEFailure)
+	}
+}
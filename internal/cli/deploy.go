@@ -0,0 +1,462 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/thde/truenas-scale-acme/internal/hook"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+)
+
+// deployment carries an obtained certificate to the targets of its definition.
+// The certificate is imported into TrueNAS at most once, by the first target
+// that needs it.
+type deployment struct {
+	config *CertificateConfig
+	cert   certmagic.Certificate
+	client *truenas.Client
+	// staging is set for certificates of a staging CA, which must not
+	// replace a trusted UI certificate unless forceStaging is set.
+	staging      bool
+	forceStaging bool
+
+	imported *truenas.Certificate
+	// updated is set once a target was changed to use the certificate.
+	updated bool
+	// plan collects the changes instead of making them, in a dry run.
+	plan *certificatePlan
+}
+
+// renewing reports whether a dry run plans to obtain a new certificate, which
+// no target holds yet.
+func (d *deployment) renewing() bool {
+	return d.plan != nil && d.plan.Renew
+}
+
+// event describes the deployment's certificate deployed to target for hooks.
+func (d *deployment) event(target TargetConfig) hook.Event {
+	event := hook.Event{
+		Certificate: d.config.String(),
+		Domains:     d.config.Domains,
+		Target:      target.Type,
+		Serial:      d.cert.Leaf.SerialNumber.Text(16),
+		NotAfter:    d.cert.Leaf.NotAfter,
+	}
+	if len(d.config.Domains) > 0 {
+		event.Domain = d.config.Domains[0]
+	}
+	if d.imported != nil && target.Type != targetFile {
+		event.TrueNASCertificateID = d.imported.ID
+		event.TrueNASCertificateName = d.imported.Name
+	}
+
+	return event
+}
+
+// truenasCertificate returns the TrueNAS certificate entry holding the
+// deployment's certificate. An existing entry with the same leaf is reused,
+// otherwise the certificate is imported.
+func (c cmd) truenasCertificate(ctx context.Context, d *deployment) (*truenas.Certificate, error) {
+	if d.imported != nil {
+		return d.imported, nil
+	}
+
+	certs, err := d.client.Certificates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing certificates: %w", err)
+	}
+	for i := range certs {
+		if d.renewing() {
+			break
+		}
+		leaf, err := certs[i].Leaf()
+		if err != nil {
+			continue
+		}
+		if leaf.Equal(d.cert.Leaf) {
+			c.ScaleLogger.Info("certificate already imported", zap.Int("id", certs[i].ID), zap.String("name", certs[i].Name))
+			d.imported = &certs[i]
+			return d.imported, nil
+		}
+	}
+
+	prefix := "acme-"
+	if d.staging {
+		prefix = "acme-staging-"
+	}
+	name := prefix + time.Now().Format("20060102-150405")
+	if d.plan != nil {
+		d.plan.add(planAction{Action: actionImport, CertificateName: name, Description: fmt.Sprintf("import certificate %q", name)})
+		d.imported = &truenas.Certificate{Name: name}
+		return d.imported, nil
+	}
+	c.ScaleLogger.Info("importing certificate", zap.String("name", name), zap.Strings("san", d.cert.Leaf.DNSNames))
+	imported, err := d.client.CertificateImport(ctx, name, d.cert.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("error importing certificate %q: %w", name, err)
+	}
+	d.imported = imported
+
+	return imported, nil
+}
+
+// errTrustedUICertificate is returned when a staging certificate would replace
+// a trusted UI certificate.
+var errTrustedUICertificate = errors.New("refusing to replace trusted ui certificate with a staging certificate")
+
+// isTrusted reports whether cert chains up to a root trusted by the system.
+func isTrusted(cert tls.Certificate) bool {
+	intermediates := x509.NewCertPool()
+	for _, der := range cert.Certificate[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+
+	_, err := cert.Leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	return err == nil
+}
+
+// deploy installs the deployment's certificate into target.
+func (c cmd) deploy(ctx context.Context, d *deployment, target TargetConfig) error {
+	switch target.Type {
+	case targetUI:
+		return c.ensureUICertificate(ctx, d, target)
+	case targetS3:
+		return c.ensureS3Certificate(ctx, d, target)
+	case targetFTP:
+		return c.ensureFTPCertificate(ctx, d, target)
+	case targetWebDAV:
+		return c.ensureWebDAVCertificate(ctx, d, target)
+	case targetApp:
+		return c.ensureAppCertificates(ctx, d, target)
+	case targetFile:
+		return c.ensureFileCertificate(ctx, d, target)
+	default:
+		return fmt.Errorf("%w: '%s'", errUnknownTarget, target.Type)
+	}
+}
+
+// serviceCertificate reads the ID of the certificate a service is configured
+// with, nil if none is set.
+type serviceCertificate func(ctx context.Context, client *truenas.Client) (*int, error)
+
+// serviceCertificates holds how the certificate of every service referencing
+// one is read, by service name.
+var serviceCertificates = map[string]serviceCertificate{
+	"ftp": func(ctx context.Context, client *truenas.Client) (*int, error) {
+		ftp, err := client.FTPConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ftp.SSLTLSCertificate, nil
+	},
+	"s3": func(ctx context.Context, client *truenas.Client) (*int, error) {
+		s3, err := client.S3Config(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s3.Certificate, nil
+	},
+	"webdav": func(ctx context.Context, client *truenas.Client) (*int, error) {
+		webdav, err := client.WebDAVConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return webdav.CertSSL, nil
+	},
+}
+
+// certificatesInUse returns the IDs of the TrueNAS certificates a consumer is
+// configured to use, so they are never removed. Apps use the certificates at
+// the path of an app target and any certificate_id in their values.
+func certificatesInUse(ctx context.Context, client *truenas.Client, targets []TargetConfig) (map[int]bool, error) {
+	inUse := map[int]bool{}
+
+	settings, err := client.SystemGeneralConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading system configuration: %w", err)
+	}
+	if settings.UICertificate != nil {
+		inUse[settings.UICertificate.ID] = true
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(serviceCertificates)) {
+		id, err := serviceCertificates[name](ctx, client)
+		switch {
+		case truenas.IsMethodNotFound(err):
+			// the service is not part of this TrueNAS version, e.g. S3 since
+			// TrueNAS SCALE 25.04
+		case err != nil:
+			return nil, fmt.Errorf("error reading %s configuration: %w", name, err)
+		case id != nil:
+			inUse[*id] = true
+		}
+	}
+
+	apps, err := client.Apps(ctx)
+	switch {
+	case truenas.IsMethodNotFound(err):
+		// apps are managed with app.* since TrueNAS SCALE 24.10
+	case err != nil:
+		return nil, fmt.Errorf("error listing apps: %w", err)
+	}
+	for _, app := range apps {
+		for _, id := range appCertificates(app.Config) {
+			inUse[id] = true
+		}
+		for _, target := range targets {
+			if target.Type != targetApp || !slices.Contains(target.Apps, app.Name) {
+				continue
+			}
+			if id, ok := appValue(app.Config, target.valuesPath()).(float64); ok {
+				inUse[int(id)] = true
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// appCertificates returns the IDs of the certificates referenced by a
+// certificate_id, the key catalog apps use, anywhere in values.
+func appCertificates(values map[string]any) []int {
+	var ids []int
+	for key, value := range values {
+		switch v := value.(type) {
+		case map[string]any:
+			ids = append(ids, appCertificates(v)...)
+		case float64:
+			if key == "certificate_id" {
+				ids = append(ids, int(v))
+			}
+		}
+	}
+
+	return ids
+}
+
+// appValue returns the value at path in values, nil if there is none.
+func appValue(values map[string]any, path []string) any {
+	var value any = values
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+
+	return value
+}
+
+// ensureAppCertificates sets the certificate ID in the values of the apps of
+// target to the deployment's certificate. TrueNAS redeploys an app once its
+// values changed.
+func (c cmd) ensureAppCertificates(ctx context.Context, d *deployment, target TargetConfig) error {
+	certImport, err := c.truenasCertificate(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	path := target.valuesPath()
+	for _, name := range target.Apps {
+		app, err := d.client.App(ctx, name)
+		if err != nil {
+			return fmt.Errorf("error reading app %s: %w", name, err)
+		}
+		if id, ok := appValue(app.Config, path).(float64); ok && int(id) == certImport.ID {
+			c.ScaleLogger.Info("app certificate up to date", zap.String("app", name))
+			continue
+		}
+
+		// send the whole top-level value, so the other values below it are
+		// kept however TrueNAS merges the update
+		if d.plan != nil {
+			d.plan.add(planAction{
+				Action: actionApp, Target: "app " + name, CertificateID: certImport.ID, CertificateName: certImport.Name,
+				Description: fmt.Sprintf("set %s of app %s to certificate %q", strings.Join(path, "."), name, certImport.Name),
+			})
+			d.updated = true
+			continue
+		}
+		values := map[string]any{path[0]: withAppValue(app.Config[path[0]], path[1:], certImport.ID)}
+		if err := d.client.AppUpdate(ctx, name, truenas.AppUpdateParams{Values: values}); err != nil {
+			return fmt.Errorf("error setting app %s certificate to %q: %w", name, certImport.Name, err)
+		}
+		c.ScaleLogger.Info("app certificate updated", zap.String("app", name), zap.String("name", certImport.Name))
+		d.updated = true
+	}
+
+	return nil
+}
+
+// withAppValue returns a copy of parent with the value at path set to value.
+func withAppValue(parent any, path []string, value any) any {
+	if len(path) == 0 {
+		return value
+	}
+
+	m, _ := parent.(map[string]any)
+	m = maps.Clone(m)
+	if m == nil {
+		m = map[string]any{}
+	}
+	m[path[0]] = withAppValue(m[path[0]], path[1:], value)
+
+	return m
+}
+
+// ensureServiceCertificate imports the deployment's certificate and, unless
+// upToDate reports the service of target already uses it as configured,
+// switches the service to it with update and restarts it.
+func (c cmd) ensureServiceCertificate(ctx context.Context, d *deployment, target TargetConfig, upToDate func(id int) bool, update func(id int) error) error {
+	certImport, err := c.truenasCertificate(ctx, d)
+	if err != nil {
+		return err
+	}
+	if upToDate(certImport.ID) {
+		c.ScaleLogger.Info("service certificate up to date", zap.String("service", target.Type))
+		return nil
+	}
+	if d.plan != nil {
+		description := fmt.Sprintf("set %s certificate to %q", target.Type, certImport.Name)
+		if target.restart() {
+			description += " and restart it if running"
+		}
+		d.plan.add(planAction{
+			Action: actionService, Target: target.Type, CertificateID: certImport.ID, CertificateName: certImport.Name,
+			Description: description,
+		})
+		d.updated = true
+		return nil
+	}
+
+	if err := update(certImport.ID); err != nil {
+		return fmt.Errorf("error setting %s certificate to %q: %w", target.Type, certImport.Name, err)
+	}
+	c.ScaleLogger.Info("service certificate updated", zap.String("service", target.Type), zap.String("name", certImport.Name))
+	d.updated = true
+
+	if !target.restart() {
+		return nil
+	}
+	return c.restartService(ctx, d.client, target.Type)
+}
+
+// ensureS3Certificate switches the S3 service to the deployment's certificate.
+func (c cmd) ensureS3Certificate(ctx context.Context, d *deployment, target TargetConfig) error {
+	s3, err := d.client.S3Config(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading s3 configuration: %w", err)
+	}
+
+	upToDate := func(id int) bool {
+		return s3.Certificate != nil && *s3.Certificate == id
+	}
+	return c.ensureServiceCertificate(ctx, d, target, upToDate, func(id int) error {
+		params := truenas.S3UpdateParams{Certificate: &id}
+		// the name clients reach the service with must be on the certificate
+		if d.cert.Leaf.VerifyHostname(s3.TLSServerURI) != nil {
+			if name, ok := serverName(d.config.Domains); ok {
+				params.TLSServerURI = &name
+			}
+		}
+		_, err := d.client.S3Update(ctx, params)
+		return err
+	})
+}
+
+// ensureFTPCertificate switches the FTP service to the deployment's
+// certificate, and enables FTPS if the target asks for it.
+func (c cmd) ensureFTPCertificate(ctx context.Context, d *deployment, target TargetConfig) error {
+	ftp, err := d.client.FTPConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading ftp configuration: %w", err)
+	}
+
+	upToDate := func(id int) bool {
+		return ftp.SSLTLSCertificate != nil && *ftp.SSLTLSCertificate == id && (!target.TLS || ftp.TLS)
+	}
+	return c.ensureServiceCertificate(ctx, d, target, upToDate, func(id int) error {
+		params := truenas.FTPUpdateParams{SSLTLSCertificate: &id}
+		if target.TLS {
+			params.TLS = &target.TLS
+		}
+		_, err := d.client.FTPUpdate(ctx, params)
+		return err
+	})
+}
+
+// ensureWebDAVCertificate switches the WebDAV service to the deployment's
+// certificate, and sets the protocol if the target configures one.
+func (c cmd) ensureWebDAVCertificate(ctx context.Context, d *deployment, target TargetConfig) error {
+	webdav, err := d.client.WebDAVConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading webdav configuration: %w", err)
+	}
+
+	upToDate := func(id int) bool {
+		return webdav.CertSSL != nil && *webdav.CertSSL == id && (target.Protocol == "" || webdav.Protocol == target.Protocol)
+	}
+	return c.ensureServiceCertificate(ctx, d, target, upToDate, func(id int) error {
+		params := truenas.WebDAVUpdateParams{CertSSL: &id}
+		if target.Protocol != "" {
+			params.Protocol = &target.Protocol
+		}
+		_, err := d.client.WebDAVUpdate(ctx, params)
+		return err
+	})
+}
+
+// serverName returns the first domain that is not a wildcard.
+func serverName(domains []string) (string, bool) {
+	for _, domain := range domains {
+		if !strings.HasPrefix(domain, "*.") {
+			return domain, true
+		}
+	}
+
+	return "", false
+}
+
+// errServiceNotRunning is returned when a service does not come back after a
+// restart.
+var errServiceNotRunning = errors.New("service not running after restart")
+
+// restartService restarts a running service, so it picks up a new
+// certificate, and confirms it is running again. A stopped service is left
+// alone, it uses the certificate once it is started.
+func (c cmd) restartService(ctx context.Context, client *truenas.Client, name string) error {
+	svc, err := client.Service(ctx, name)
+	if err != nil {
+		return fmt.Errorf("error reading %s service: %w", name, err)
+	}
+	if !svc.Running() {
+		c.ScaleLogger.Info("service not running, skipping restart", zap.String("service", name), zap.String("state", svc.State))
+		return nil
+	}
+
+	if err := client.ServiceRestart(ctx, name); err != nil {
+		return fmt.Errorf("error restarting %s service: %w", name, err)
+	}
+	svc, err = client.Service(ctx, name)
+	if err != nil {
+		return fmt.Errorf("error reading %s service: %w", name, err)
+	}
+	if !svc.Running() {
+		return fmt.Errorf("%w: %s is %s", errServiceNotRunning, name, svc.State)
+	}
+	c.ScaleLogger.Info("service restarted", zap.String("service", name))
+
+	return nil
+}
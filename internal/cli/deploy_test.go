@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+// newTestDeployment obtains a certificate for names from a test issuer and
+// connects to a fake TrueNAS server to deploy it to.
+func newTestDeployment(t *testing.T, names ...string) (*deployment, *truenastest.Server) {
+	t.Helper()
+
+	srv := truenastest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	cert, err := newTestCmd().obtainCertificate(t.Context(), newTestMagic(t, newTestIssuer(t)), names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+
+	return &deployment{config: &CertificateConfig{Domains: names}, cert: cert, client: client}, srv
+}
+
+func Test_ensureS3Certificate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		state        string
+		failRestarts bool
+		wantRestarts int
+		wantErr      bool
+	}{
+		{"running", truenastest.ServiceRunning, false, 1, false},
+		{"stopped", truenastest.ServiceStopped, false, 0, false},
+		{"restart fails", truenastest.ServiceRunning, true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, srv := newTestDeployment(t, "*.example.com", "s3.example.com")
+			srv.SetServiceState("s3", tt.state)
+			if tt.failRestarts {
+				srv.FailServiceRestarts("s3")
+			}
+
+			err := newTestCmd().ensureS3Certificate(t.Context(), d, TargetConfig{Type: targetS3})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureS3Certificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := srv.ServiceCertificate("s3"); got != d.imported.ID {
+				t.Errorf("s3 certificate = %d, want %d", got, d.imported.ID)
+			}
+			if got := srv.ServiceRestarts("s3"); got != tt.wantRestarts {
+				t.Errorf("restarts = %d, want %d", got, tt.wantRestarts)
+			}
+			s3, err := d.client.S3Config(t.Context())
+			if err != nil {
+				t.Fatalf("S3Config() error = %v", err)
+			}
+			if s3.TLSServerURI != "s3.example.com" {
+				t.Errorf("tls_server_uri = %q, want the first name that is no wildcard", s3.TLSServerURI)
+			}
+		})
+	}
+}
+
+func Test_ensureS3Certificate_upToDate(t *testing.T) {
+	t.Parallel()
+
+	d, srv := newTestDeployment(t, "s3.example.com")
+	srv.SetServiceState("s3", truenastest.ServiceRunning)
+	if err := newTestCmd().ensureS3Certificate(t.Context(), d, TargetConfig{Type: targetS3}); err != nil {
+		t.Fatalf("ensureS3Certificate() error = %v", err)
+	}
+
+	d.imported = nil // a later run
+	if err := newTestCmd().ensureS3Certificate(t.Context(), d, TargetConfig{Type: targetS3}); err != nil {
+		t.Fatalf("ensureS3Certificate() error = %v", err)
+	}
+	if got := srv.Calls("s3.update"); got != 1 {
+		t.Errorf("s3.update called %d times, want 1", got)
+	}
+	if got := srv.ServiceRestarts("s3"); got != 1 {
+		t.Errorf("restarts = %d, want 1", got)
+	}
+}
+
+func Test_deploy_services(t *testing.T) {
+	t.Parallel()
+
+	no := false
+	tests := []struct {
+		name         string
+		target       TargetConfig
+		wantRestarts int
+		// check verifies the service configuration besides the certificate.
+		check func(t *testing.T, client *truenas.Client)
+	}{
+		{
+			name:         "ftp",
+			target:       TargetConfig{Type: targetFTP, TLS: true},
+			wantRestarts: 1,
+			check: func(t *testing.T, client *truenas.Client) {
+				ftp, err := client.FTPConfig(t.Context())
+				if err != nil {
+					t.Fatalf("FTPConfig() error = %v", err)
+				}
+				if !ftp.TLS {
+					t.Error("ftp tls disabled, want it enabled")
+				}
+			},
+		},
+		{
+			name:         "webdav",
+			target:       TargetConfig{Type: targetWebDAV, Protocol: truenas.WebDAVHTTPS},
+			wantRestarts: 1,
+			check: func(t *testing.T, client *truenas.Client) {
+				webdav, err := client.WebDAVConfig(t.Context())
+				if err != nil {
+					t.Fatalf("WebDAVConfig() error = %v", err)
+				}
+				if webdav.Protocol != truenas.WebDAVHTTPS {
+					t.Errorf("webdav protocol = %s, want %s", webdav.Protocol, truenas.WebDAVHTTPS)
+				}
+			},
+		},
+		{
+			name:         "no restart",
+			target:       TargetConfig{Type: targetFTP, Restart: &no},
+			wantRestarts: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, srv := newTestDeployment(t, "nas.example.com")
+			srv.SetServiceState(tt.target.Type, truenastest.ServiceRunning)
+
+			if err := newTestCmd().deploy(t.Context(), d, tt.target); err != nil {
+				t.Fatalf("deploy() error = %v", err)
+			}
+			if got := srv.ServiceCertificate(tt.target.Type); got != d.imported.ID {
+				t.Errorf("%s certificate = %d, want %d", tt.target.Type, got, d.imported.ID)
+			}
+			if got := srv.ServiceRestarts(tt.target.Type); got != tt.wantRestarts {
+				t.Errorf("restarts = %d, want %d", got, tt.wantRestarts)
+			}
+			if tt.check != nil {
+				tt.check(t, d.client)
+			}
+
+			// a second deployment finds the service up to date
+			if err := newTestCmd().deploy(t.Context(), d, tt.target); err != nil {
+				t.Fatalf("deploy() error = %v", err)
+			}
+			if got := srv.ServiceRestarts(tt.target.Type); got != tt.wantRestarts {
+				t.Errorf("restarts = %d after second deployment, want %d", got, tt.wantRestarts)
+			}
+		})
+	}
+}
+
+func Test_ensureAppCertificates(t *testing.T) {
+	t.Parallel()
+
+	d, srv := newTestDeployment(t, "nas.example.com")
+	srv.AddApp("nextcloud", map[string]any{
+		"network": map[string]any{"web_port": 30027, "certificate_id": nil},
+	})
+	srv.AddApp("custom", map[string]any{})
+	targets := []TargetConfig{
+		{Type: targetApp, Apps: []string{"nextcloud"}},
+		{Type: targetApp, Apps: []string{"custom"}, Path: "tls.certificate"},
+	}
+
+	for _, target := range targets {
+		if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+			t.Fatalf("deploy() error = %v", err)
+		}
+	}
+
+	want := float64(d.imported.ID)
+	nextcloud := srv.AppValues("nextcloud")
+	if got := appValue(nextcloud, []string{"network", "certificate_id"}); got != want {
+		t.Errorf("nextcloud certificate_id = %v, want %v", got, want)
+	}
+	if got := appValue(nextcloud, []string{"network", "web_port"}); got != float64(30027) {
+		t.Errorf("nextcloud web_port = %v, want it kept", got)
+	}
+	if got := appValue(srv.AppValues("custom"), []string{"tls", "certificate"}); got != want {
+		t.Errorf("custom tls.certificate = %v, want %v", got, want)
+	}
+
+	// a second deployment finds the apps up to date
+	for _, target := range targets {
+		if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+			t.Fatalf("deploy() error = %v", err)
+		}
+	}
+	for _, app := range []string{"nextcloud", "custom"} {
+		if got := srv.AppUpdates(app); got != 1 {
+			t.Errorf("%s updates = %d, want 1", app, got)
+		}
+	}
+
+	err := newTestCmd().deploy(t.Context(), d, TargetConfig{Type: targetApp, Apps: []string{"missing"}})
+	if err == nil {
+		t.Error("deploy() to a missing app succeeded")
+	}
+}
+
+func Test_certificatesInUse(t *testing.T) {
+	t.Parallel()
+
+	d, srv := newTestDeployment(t, "nas.example.com")
+	imported, err := newTestCmd().truenasCertificate(t.Context(), d)
+	if err != nil {
+		t.Fatalf("truenasCertificate() error = %v", err)
+	}
+	ids := map[string]int{}
+	for _, service := range []string{"s3", "ftp", "webdav"} {
+		id, err := srv.AddCertificate(service, imported.Certificate, imported.Privatekey)
+		if err != nil {
+			t.Fatalf("AddCertificate() error = %v", err)
+		}
+		srv.SetServiceCertificate(service, id)
+		ids[service] = id
+	}
+	for _, app := range []string{"nextcloud", "custom"} {
+		id, err := srv.AddCertificate(app, imported.Certificate, imported.Privatekey)
+		if err != nil {
+			t.Fatalf("AddCertificate() error = %v", err)
+		}
+		ids[app] = id
+	}
+	srv.AddApp("nextcloud", map[string]any{"network": map[string]any{"certificate_id": ids["nextcloud"]}})
+	srv.AddApp("custom", map[string]any{"tls": map[string]any{"cert": ids["custom"]}})
+	targets := []TargetConfig{{Type: targetApp, Apps: []string{"custom"}, Path: "tls.cert"}}
+
+	inUse, err := certificatesInUse(t.Context(), d.client, targets)
+	if err != nil {
+		t.Fatalf("certificatesInUse() error = %v", err)
+	}
+	for service, id := range ids {
+		if !inUse[id] {
+			t.Errorf("certificatesInUse() = %v, want %d of %s in use", inUse, id, service)
+		}
+	}
+
+	// TrueNAS versions without the S3 and WebDAV services or apps
+	srv.DisableMethods("s3.config", "webdav.config", "app.query")
+	inUse, err = certificatesInUse(t.Context(), d.client, targets)
+	if err != nil {
+		t.Fatalf("certificatesInUse() without s3, webdav and apps error = %v", err)
+	}
+	if !inUse[ids["ftp"]] {
+		t.Errorf("certificatesInUse() = %v, want %d of ftp in use", inUse, ids["ftp"])
+	}
+}
+
+func Test_ensureUICertificate_verify(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		frozen  bool
+		wantErr error
+	}{
+		{"presented", false, nil},
+		{"not presented", true, truenas.ErrUICertificateMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, srv := newTestDeployment(t, "nas.example.com")
+			previous, _ := newTestDeployment(t, "nas.example.com")
+			key, err := truenas.EncodePrivateKeyPEM(previous.cert.Certificate)
+			if err != nil {
+				t.Fatalf("EncodePrivateKeyPEM() error = %v", err)
+			}
+			id, err := srv.AddCertificate("previous", truenas.EncodeChainPEM(previous.cert.Certificate), key)
+			if err != nil {
+				t.Fatalf("AddCertificate() error = %v", err)
+			}
+			srv.SetUICertificate(id)
+			srv.ServeUI()
+			if tt.frozen {
+				srv.FreezeUI()
+			}
+
+			err = newTestCmd().deploy(t.Context(), d, TargetConfig{Type: targetUI, Verify: true})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("deploy() error = %v, want %v", err, tt.wantErr)
+			}
+			wantCheckins := 1
+			if tt.wantErr != nil {
+				wantCheckins = 0
+			}
+			if got := srv.Checkins(); got != wantCheckins {
+				t.Errorf("checkins = %d, want %d", got, wantCheckins)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/acmedns"
+	"github.com/libdns/cloudflare"
+	"github.com/libdns/desec"
+	"github.com/libdns/digitalocean"
+	"github.com/libdns/gandi"
+	"github.com/libdns/hetzner"
+	"github.com/libdns/ovh"
+	"github.com/libdns/porkbun"
+	"github.com/libdns/powerdns"
+	"github.com/libdns/route53"
+	"github.com/thde/truenas-scale-acme/internal/execdns"
+	"github.com/thde/truenas-scale-acme/internal/rfc2136"
+)
+
+// Errors reported for a [DNSProviderConfig].
+var (
+	errNoProviderName  = errors.New("no acme.dns.provider specified")
+	errUnknownProvider = errors.New("unknown dns provider")
+	errMissingField    = errors.New("missing required field")
+)
+
+// dnsProviders holds every supported libdns provider by the name used in
+// acme.dns.provider. Supporting another provider only takes adding it here.
+var dnsProviders = map[string]dnsProviderType{
+	"acme-dns":     newDNSProviderType[acmedns.Provider](),
+	"cloudflare":   newDNSProviderType[cloudflare.Provider]("api_token"),
+	"desec":        newDNSProviderType[desec.Provider]("token"),
+	"digitalocean": newDNSProviderType[digitalocean.Provider]("auth_token"),
+	"exec":         newDNSProviderType[execdns.Provider]("command"),
+	"gandi":        newDNSProviderType[gandi.Provider]("bearer_token"),
+	"hetzner":      newDNSProviderType[hetzner.Provider]("auth_api_token"),
+	"ovh":          newDNSProviderType[ovh.Provider]("endpoint", "application_key", "application_secret", "consumer_key"),
+	"porkbun":      newDNSProviderType[porkbun.Provider]("api_key", "api_secret_key"),
+	"powerdns":     newDNSProviderType[powerdns.Provider]("server_url", "api_token"),
+	"rfc2136":      newDNSProviderType[rfc2136.Provider]("server", "key_name", "key"),
+	"route53":      newDNSProviderType[route53.Provider](),
+}
+
+// dnsProviderType creates a libdns provider of one type.
+type dnsProviderType struct {
+	new func() certmagic.DNSProvider
+	// required lists the JSON fields the configuration must set.
+	required []string
+}
+
+func newDNSProviderType[T any, PT interface {
+	*T
+	certmagic.DNSProvider
+}](required ...string) dnsProviderType {
+	return dnsProviderType{
+		new:      func() certmagic.DNSProvider { return PT(new(T)) },
+		required: required,
+	}
+}
+
+// DNSProviderConfig selects a libdns provider by name. The remaining fields
+// are the configuration of that provider, decoded into its type, e.g.
+//
+//	{"provider": "cloudflare", "api_token": "s3cure"}
+type DNSProviderConfig struct {
+	Provider string
+	raw      json.RawMessage
+}
+
+// UnmarshalJSON keeps the provider configuration until it is decoded by
+// [DNSProviderConfig.DNSProvider].
+func (dc *DNSProviderConfig) UnmarshalJSON(b []byte) error {
+	var head struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return fmt.Errorf("parsing dns provider: %w", err)
+	}
+
+	dc.Provider = head.Provider
+	dc.raw = slices.Clone(b)
+
+	return nil
+}
+
+// MarshalJSON returns the configuration as it was read.
+func (dc *DNSProviderConfig) MarshalJSON() ([]byte, error) {
+	if dc.raw != nil {
+		return dc.raw, nil
+	}
+
+	b, err := json.Marshal(map[string]string{"provider": dc.Provider})
+	if err != nil {
+		return nil, fmt.Errorf("encoding dns provider: %w", err)
+	}
+
+	return b, nil
+}
+
+// DNSProvider decodes the configuration into the named provider.
+func (dc *DNSProviderConfig) DNSProvider() (certmagic.DNSProvider, error) {
+	typ, err := dc.providerType()
+	if err != nil {
+		return nil, err
+	}
+
+	provider := typ.new()
+	if dc.raw != nil {
+		if err := json.Unmarshal(dc.raw, provider); err != nil {
+			return nil, fmt.Errorf("parsing %s provider: %w", dc.Provider, err)
+		}
+	}
+
+	return provider, nil
+}
+
+// Valid reports an unknown provider name and every required field the
+// configuration does not set.
+func (dc *DNSProviderConfig) Valid() error {
+	typ, err := dc.providerType()
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]any{}
+	if dc.raw != nil {
+		if err := json.Unmarshal(dc.raw, &fields); err != nil {
+			return fmt.Errorf("parsing %s provider: %w", dc.Provider, err)
+		}
+	}
+
+	var errs []error
+	for _, field := range typ.required {
+		if v, ok := fields[field]; !ok || isEmpty(v) {
+			errs = append(errs, fmt.Errorf("%w: acme.dns.%s for %s", errMissingField, field, dc.Provider))
+		}
+	}
+	if _, err := dc.DNSProvider(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isEmpty reports whether a decoded JSON value is null, an empty string or an
+// empty list.
+func isEmpty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+func (dc *DNSProviderConfig) providerType() (dnsProviderType, error) {
+	if dc.Provider == "" {
+		return dnsProviderType{}, errNoProviderName
+	}
+
+	typ, ok := dnsProviders[dc.Provider]
+	if !ok {
+		names := slices.Sorted(maps.Keys(dnsProviders))
+		return dnsProviderType{}, fmt.Errorf("%w: '%s' (supported: %s)", errUnknownProvider, dc.Provider, strings.Join(names, ", "))
+	}
+
+	return typ, nil
+}
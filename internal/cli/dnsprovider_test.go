@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/libdns/acmedns"
+	"github.com/libdns/cloudflare"
+	"github.com/libdns/desec"
+	"github.com/libdns/digitalocean"
+	"github.com/libdns/gandi"
+	"github.com/libdns/hetzner"
+	"github.com/libdns/ovh"
+	"github.com/libdns/porkbun"
+	"github.com/libdns/powerdns"
+	"github.com/libdns/route53"
+	"github.com/thde/truenas-scale-acme/internal/execdns"
+	"github.com/thde/truenas-scale-acme/internal/rfc2136"
+)
+
+func TestDNSProviderConfig_DNSProvider(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  string
+		want    any
+		wantErr error
+	}{
+		{"cloudflare", `{"provider": "cloudflare", "api_token": "s3cure"}`, &cloudflare.Provider{APIToken: "s3cure"}, nil},
+		{"acme-dns", `{"provider": "acme-dns", "username": "user", "server_url": "https://auth.acme-dns.io"}`, &acmedns.Provider{Username: "user", ServerURL: "https://auth.acme-dns.io"}, nil},
+		{"route53", `{"provider": "route53", "region": "eu-central-1"}`, &route53.Provider{Region: "eu-central-1"}, nil},
+		{"hetzner", `{"provider": "hetzner", "auth_api_token": "s3cure"}`, &hetzner.Provider{AuthAPIToken: "s3cure"}, nil},
+		{"desec", `{"provider": "desec", "token": "s3cure"}`, &desec.Provider{Token: "s3cure"}, nil},
+		{"digitalocean", `{"provider": "digitalocean", "auth_token": "s3cure"}`, &digitalocean.Provider{APIToken: "s3cure"}, nil},
+		{"porkbun", `{"provider": "porkbun", "api_key": "pk1", "api_secret_key": "sk1"}`, &porkbun.Provider{APIKey: "pk1", APISecretKey: "sk1"}, nil},
+		{"ovh", `{"provider": "ovh", "endpoint": "ovh-eu", "application_key": "ak", "application_secret": "as", "consumer_key": "ck"}`, &ovh.Provider{Endpoint: "ovh-eu", ApplicationKey: "ak", ApplicationSecret: "as", ConsumerKey: "ck"}, nil},
+		{"gandi", `{"provider": "gandi", "bearer_token": "s3cure"}`, &gandi.Provider{BearerToken: "s3cure"}, nil},
+		{"powerdns", `{"provider": "powerdns", "server_url": "https://ns1.example.com:8081", "api_token": "s3cure"}`, &powerdns.Provider{ServerURL: "https://ns1.example.com:8081", APIToken: "s3cure"}, nil},
+		{"rfc2136", `{"provider": "rfc2136", "server": "ns1.example.com", "key_name": "acme", "key_alg": "hmac-sha512", "key": "c2VjcmV0"}`, &rfc2136.Provider{Server: "ns1.example.com", KeyName: "acme", KeyAlgorithm: "hmac-sha512", Key: "c2VjcmV0"}, nil},
+		{"exec", `{"provider": "exec", "command": ["/usr/local/bin/dns-hook", "--zone-file"], "timeout": 30}`, &execdns.Provider{Command: []string{"/usr/local/bin/dns-hook", "--zone-file"}, Timeout: 30}, nil},
+		{"unknown", `{"provider": "nope"}`, nil, errUnknownProvider},
+		{"no name", `{"api_token": "s3cure"}`, nil, errNoProviderName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var dc DNSProviderConfig
+			if err := json.Unmarshal([]byte(tt.config), &dc); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			got, err := dc.DNSProvider()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DNSProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DNSProvider() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSProviderConfig_Valid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr error
+	}{
+		{"route53", `{"provider": "route53"}`, nil},
+		{"hetzner", `{"provider": "hetzner", "auth_api_token": "s3cure"}`, nil},
+		{"hetzner without token", `{"provider": "hetzner"}`, errMissingField},
+		{"desec without token", `{"provider": "desec", "token": ""}`, errMissingField},
+		{"digitalocean without token", `{"provider": "digitalocean"}`, errMissingField},
+		{"porkbun without secret", `{"provider": "porkbun", "api_key": "pk1"}`, errMissingField},
+		{"ovh without consumer key", `{"provider": "ovh", "endpoint": "ovh-eu", "application_key": "ak", "application_secret": "as"}`, errMissingField},
+		{"gandi without token", `{"provider": "gandi"}`, errMissingField},
+		{"powerdns without url", `{"provider": "powerdns", "api_token": "s3cure"}`, errMissingField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var dc DNSProviderConfig
+			if err := json.Unmarshal([]byte(tt.config), &dc); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if err := dc.Valid(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDNSProviderConfig_Valid_fields(t *testing.T) {
+	t.Parallel()
+
+	var dc DNSProviderConfig
+	if err := json.Unmarshal([]byte(`{"provider": "cloudflare", "api_token": ""}`), &dc); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := dc.Valid(); !errors.Is(err, errMissingField) {
+		t.Errorf("Valid() error = %v, want %v", err, errMissingField)
+	}
+
+	if err := json.Unmarshal([]byte(`{"provider": "exec", "command": []}`), &dc); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := dc.Valid(); !errors.Is(err, errMissingField) {
+		t.Errorf("Valid() error = %v, want %v", err, errMissingField)
+	}
+
+	if err := exampleConfig.ACME.DNS.Valid(); err != nil {
+		t.Errorf("Valid() error = %v for example config", err)
+	}
+}
+
+func TestDNSProviders(t *testing.T) {
+	t.Parallel()
+
+	for name, typ := range dnsProviders {
+		fields := map[string]bool{}
+		v := reflect.ValueOf(typ.new()).Elem().Type()
+		for i := range v.NumField() {
+			tag, _, _ := strings.Cut(v.Field(i).Tag.Get("json"), ",")
+			fields[tag] = true
+		}
+		for _, field := range typ.required {
+			if !fields[field] {
+				t.Errorf("%s requires %s, which is not a field of %s", name, field, v)
+			}
+		}
+	}
+}
+
+func TestDNSProviderConfig_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	in := `{"provider":"cloudflare","api_token":"s3cure"}`
+	var dc DNSProviderConfig
+	if err := json.Unmarshal([]byte(in), &dc); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	out, err := json.Marshal(&dc)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() = %s, want %s", out, in)
+	}
+}
@@ -0,0 +1,349 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/acmetest"
+	"github.com/thde/truenas-scale-acme/internal/hook"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+	"go.uber.org/zap/zaptest"
+)
+
+// e2eHarness runs the command against an in-process ACME CA and TrueNAS
+// server. As it sets the command-line arguments and the environment, it cannot
+// be used by parallel tests.
+type e2eHarness struct {
+	acme    *acmetest.Server
+	truenas *truenastest.Server
+	domains []string
+	config  string
+	// events is the file the hook appends the events it receives to.
+	events string
+}
+
+func newE2EHarness(t *testing.T) *e2eHarness {
+	t.Helper()
+
+	h := &e2eHarness{
+		acme:    acmetest.NewServer(t, "example.com"),
+		truenas: truenastest.NewServer(),
+		domains: []string{"nas.example.com", "*.nas.example.com"},
+	}
+	t.Cleanup(h.truenas.Close)
+	h.truenas.ServeUI()
+
+	dir := t.TempDir()
+	caBundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caBundle, h.acme.CABundle(), 0o600); err != nil {
+		t.Fatalf("writing ca bundle: %v", err)
+	}
+
+	h.events = filepath.Join(dir, "events")
+	config, err := json.Marshal(map[string]any{
+		"certificates": []any{map[string]any{
+			"domains": h.domains,
+			"targets": []any{map[string]any{"type": targetUI, "verify": true}},
+		}},
+		"hooks": []any{map[string]any{
+			"command":    []string{"/bin/sh", "-c", `cat >> "$0"; echo >> "$0"`, h.events},
+			"on_failure": hookFailureFail,
+		}},
+		"api": map[string]any{
+			"api_key": truenastest.DefaultAPIKey,
+			"url":     h.truenas.URL.String(),
+		},
+		"acme": map[string]any{
+			"email":      "admin@example.com",
+			"tos_agreed": true,
+			"resolvers":  []string{h.acme.DNSAddr},
+			"storage":    filepath.Join(dir, "storage"),
+			"issuers":    []any{map[string]any{"directory": h.acme.DirectoryURL, "ca_bundle": caBundle}},
+			"dns": map[string]any{
+				"provider": "rfc2136",
+				"server":   h.acme.DNSAddr,
+				"key_name": acmetest.TSIGKeyName,
+				"key":      h.acme.TSIGKey,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("encoding config: %v", err)
+	}
+	h.config = filepath.Join(dir, "config.json")
+	if err := os.WriteFile(h.config, config, 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	return h
+}
+
+// run runs the command once with the harness configuration.
+func (h *e2eHarness) run(t *testing.T, args ...string) error {
+	t.Helper()
+
+	osArgs := os.Args
+	t.Cleanup(func() { os.Args = osArgs })
+	os.Args = append([]string{"truenas-scale-acme", "--config", h.config}, args...)
+
+	return Run(t.Context(), zaptest.NewLogger(t), &BuildInfo{Version: "test"})
+}
+
+// output runs a command with the harness configuration and returns what it
+// printed.
+func (h *e2eHarness) output(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out strings.Builder
+	logger := zaptest.NewLogger(t)
+	err := cmd{
+		CertLogger:  logger.Named("certificate"),
+		ScaleLogger: logger.Named("scale"),
+		CLILogger:   logger.Named("cli"),
+		Out:         &out,
+		BuildInfo:   &BuildInfo{Version: "test"},
+	}.Run(t.Context(), append([]string{"--config", h.config}, args...))
+
+	return out.String(), err
+}
+
+// hookEvents returns the events the hook received.
+func (h *e2eHarness) hookEvents(t *testing.T) []hook.Event {
+	t.Helper()
+
+	b, err := os.ReadFile(h.events)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("reading hook events: %v", err)
+	}
+
+	var events []hook.Event
+	for line := range strings.Lines(string(b)) {
+		var event hook.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("decoding hook event %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// addExpiredCertificate adds an expired self-signed certificate for the
+// harness domains to TrueNAS.
+func (h *e2eHarness) addExpiredCertificate(t *testing.T, name string) int {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: h.domains[0]},
+		DNSNames:     h.domains,
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	id, err := h.truenas.AddCertificate(name,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	)
+	if err != nil {
+		t.Fatalf("AddCertificate() error = %v", err)
+	}
+
+	return id
+}
+
+// uiCertificate returns the UI certificate and its verified leaf.
+func (h *e2eHarness) uiCertificate(t *testing.T) (truenastest.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	id := h.truenas.UICertificate()
+	for _, cert := range h.truenas.Certificates() {
+		if cert.ID != id {
+			continue
+		}
+
+		tnCert := truenas.Certificate{Certificate: cert.Certificate, Privatekey: cert.PrivateKey}
+		tlsCert, err := tnCert.TLSCertificate()
+		if err != nil {
+			t.Fatalf("ui certificate %q: %v", cert.Name, err)
+		}
+		intermediates := x509.NewCertPool()
+		for _, der := range tlsCert.Certificate[1:] {
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatalf("ui certificate %q: %v", cert.Name, err)
+			}
+			intermediates.AddCert(c)
+		}
+		if _, err := tlsCert.Leaf.Verify(x509.VerifyOptions{
+			DNSName:       h.domains[0],
+			Roots:         h.acme.Roots(),
+			Intermediates: intermediates,
+		}); err != nil {
+			t.Fatalf("ui certificate %q: %v", cert.Name, err)
+		}
+
+		return cert, tlsCert.Leaf
+	}
+
+	t.Fatalf("ui certificate %d not found", id)
+	return truenastest.Certificate{}, nil
+}
+
+func TestRun_e2e(t *testing.T) {
+	h := newE2EHarness(t)
+	expired := h.addExpiredCertificate(t, "expired")
+	h.truenas.SetUICertificate(expired)
+
+	// issue, import, switch the UI, check in and prune the expired certificate
+	if err := h.run(t); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	cert, leaf := h.uiCertificate(t)
+	if !strings.HasPrefix(cert.Name, "acme-") {
+		t.Errorf("ui certificate name = %q, want acme- prefix", cert.Name)
+	}
+	if !sameNames(leaf.DNSNames, h.domains) {
+		t.Errorf("ui certificate SANs = %v, want %v", leaf.DNSNames, h.domains)
+	}
+	if got := h.truenas.Checkins(); got != 1 {
+		t.Errorf("checkins = %d, want 1", got)
+	}
+	if got := h.truenas.Restarts(); got != 1 {
+		t.Errorf("restarts = %d, want 1", got)
+	}
+	if got := h.truenas.Certificates(); len(got) != 1 || got[0].ID != cert.ID {
+		t.Errorf("certificates = %+v, want only the ui certificate", got)
+	}
+	if got := h.acme.TXT("_acme-challenge.nas.example.com"); len(got) != 0 {
+		t.Errorf("challenge records = %v, want them cleaned up", got)
+	}
+	events := h.hookEvents(t)
+	if len(events) != 1 || events[0].Target != targetUI || events[0].TrueNASCertificateID != cert.ID || events[0].Serial != leaf.SerialNumber.Text(16) {
+		t.Errorf("hook events = %+v, want one for the ui certificate %d", events, cert.ID)
+	}
+
+	// a second run finds everything up to date
+	if err := h.run(t); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if again, _ := h.uiCertificate(t); again.ID != cert.ID {
+		t.Errorf("ui certificate = %d after second run, want %d", again.ID, cert.ID)
+	}
+	if got := h.truenas.Calls("certificate.create"); got != 1 {
+		t.Errorf("certificate.create called %d times, want 1", got)
+	}
+	if got := h.truenas.Restarts(); got != 1 {
+		t.Errorf("restarts = %d after second run, want 1", got)
+	}
+	if got := h.hookEvents(t); len(got) != 1 {
+		t.Errorf("hook events = %+v after second run, want no new one", got)
+	}
+}
+
+func TestRun_e2e_commands(t *testing.T) {
+	h := newE2EHarness(t)
+
+	out, err := h.output(t, "validate")
+	if err != nil {
+		t.Fatalf("validate error = %v, output:\n%s", err, out)
+	}
+	if !strings.Contains(out, "truenas "+h.truenas.URL.String()+": ok") {
+		t.Errorf("validate output = %q, want truenas ok", out)
+	}
+
+	out, err = h.output(t, "status")
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	if !strings.Contains(out, "UI certificate:  none") || !strings.Contains(out, "not obtained") {
+		t.Errorf("status output = %q, want no certificates", out)
+	}
+
+	if _, err := h.output(t, "run"); err != nil {
+		t.Fatalf("run error = %v", err)
+	}
+	first, firstLeaf := h.uiCertificate(t)
+
+	// a forced renewal deploys a new certificate although the first is not due
+	if _, err := h.output(t, "renew", "--force"); err != nil {
+		t.Fatalf("renew --force error = %v", err)
+	}
+	second, secondLeaf := h.uiCertificate(t)
+	if second.ID == first.ID || secondLeaf.Equal(firstLeaf) {
+		t.Errorf("ui certificate = %d after forced renewal, want a new one", second.ID)
+	}
+	if got := h.hookEvents(t); len(got) != 2 {
+		t.Errorf("hook events = %+v, want one per deployment", got)
+	}
+
+	out, err = h.output(t, "status")
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	serial := secondLeaf.SerialNumber.Text(16)
+	if strings.Count(out, serial) != 2 {
+		t.Errorf("status output = %q, want the ui and the stored certificate with serial %s", out, serial)
+	}
+
+	out, err = h.output(t, "list")
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if !strings.Contains(out, second.Name) || !strings.Contains(out, first.Name) || !strings.Contains(out, "ui") {
+		t.Errorf("list output = %q, want both certificates", out)
+	}
+
+	expired := h.addExpiredCertificate(t, "expired")
+	out, err = h.output(t, "prune", "--dry-run")
+	if err != nil {
+		t.Fatalf("prune --dry-run error = %v", err)
+	}
+	if !strings.Contains(out, "1 certificates would be removed") {
+		t.Errorf("prune --dry-run output = %q, want a preview", out)
+	}
+	if _, err := h.output(t, "prune"); err != nil {
+		t.Fatalf("prune error = %v", err)
+	}
+	for _, cert := range h.truenas.Certificates() {
+		if cert.ID == expired {
+			t.Errorf("expired certificate %d not pruned", expired)
+		}
+	}
+
+	if _, err := h.output(t, "rollback"); err != nil {
+		t.Fatalf("rollback error = %v", err)
+	}
+	if got, _ := h.uiCertificate(t); got.ID != first.ID {
+		t.Errorf("ui certificate = %d after rollback, want %d", got.ID, first.ID)
+	}
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Files written by the file target.
+const (
+	fileFullchain = "fullchain.pem"
+	filePrivkey   = "privkey.pem"
+	// fileCombined holds the chain followed by the key, as e.g. HAProxy
+	// expects it.
+	fileCombined = "combined.pem"
+	filePKCS12   = "certificate.p12"
+)
+
+// certificateFile is a file of the file target with its content.
+type certificateFile struct {
+	name string
+	data []byte
+}
+
+// ensureFileCertificate writes the deployment's certificate to the directory
+// of target, unless the files there already hold it.
+func (c cmd) ensureFileCertificate(_ context.Context, d *deployment, target TargetConfig) error {
+	mode, err := target.fileMode()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := lookupOwner(target.Owner)
+	if err != nil {
+		return err
+	}
+
+	var files []certificateFile
+	// a certificate a dry run plans to obtain is in no files yet
+	if !d.renewing() {
+		files, err = certificateFiles(d, target)
+		if err != nil {
+			return err
+		}
+		if filesUpToDate(target.Directory, files, mode, uid, gid, func(p12 []byte) bool {
+			_, leaf, _, err := pkcs12.DecodeChain(p12, target.PKCS12Password)
+			return err == nil && leaf.Equal(d.cert.Leaf)
+		}) {
+			c.CertLogger.Info("certificate files up to date", zap.String("directory", target.Directory))
+			return nil
+		}
+	}
+	if d.plan != nil {
+		d.plan.add(planAction{Action: actionFiles, Target: "file " + target.Directory, Description: "write certificate files to " + target.Directory})
+		d.updated = true
+		return nil
+	}
+
+	if err := os.MkdirAll(target.Directory, 0o750); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(target.Directory, f.name), f.data, mode, uid, gid); err != nil {
+			return err
+		}
+	}
+	c.CertLogger.Info("certificate files written", zap.String("directory", target.Directory), zap.Strings("san", d.cert.Leaf.DNSNames))
+	d.updated = true
+
+	return nil
+}
+
+// certificateFiles returns the files of target holding the deployment's
+// certificate. The full chain comes last: it is written last, so files left
+// behind by an interrupted deployment are detected as changed.
+func certificateFiles(d *deployment, target TargetConfig) ([]certificateFile, error) {
+	chain := truenas.EncodeChainPEM(d.cert.Certificate)
+	key, err := truenas.EncodePrivateKeyPEM(d.cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []certificateFile
+	if target.PKCS12 {
+		p12, err := encodePKCS12(d, target.PKCS12Password)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, certificateFile{filePKCS12, p12})
+	}
+
+	return append(files,
+		certificateFile{fileCombined, []byte(chain + key)},
+		certificateFile{filePrivkey, []byte(key)},
+		certificateFile{fileFullchain, []byte(chain)},
+	), nil
+}
+
+// encodePKCS12 encodes the deployment's certificate as PKCS #12 bundle.
+func encodePKCS12(d *deployment, password string) ([]byte, error) {
+	var intermediates []*x509.Certificate
+	for _, der := range d.cert.Certificate.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing intermediate certificate: %w", err)
+		}
+		intermediates = append(intermediates, c)
+	}
+
+	p12, err := pkcs12.Modern.Encode(d.cert.PrivateKey, d.cert.Leaf, intermediates, password)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PKCS #12 bundle: %w", err)
+	}
+
+	return p12, nil
+}
+
+// filesUpToDate reports whether the directory holds the PEM files with mode
+// and owner. The PKCS #12 bundle is encrypted with a random salt, so instead
+// of its content, pkcs12Current checks that it opens with the password and
+// holds the certificate.
+func filesUpToDate(dir string, files []certificateFile, mode os.FileMode, uid, gid int, pkcs12Current func([]byte) bool) bool {
+	for _, f := range files {
+		name := filepath.Join(dir, f.name)
+		fi, err := os.Stat(name)
+		if err != nil || !fileAttributesMatch(fi, mode, uid, gid) {
+			return false
+		}
+		current, err := os.ReadFile(name)
+		if err != nil {
+			return false
+		}
+		if f.name == filePKCS12 {
+			if !pkcs12Current(current) {
+				return false
+			}
+		} else if !bytes.Equal(current, f.data) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// errUnknownOwner is returned when the owner of the file target does not
+// exist.
+var errUnknownOwner = errors.New("unknown owner")
+
+// lookupOwner returns the user and group ID of an owner given as "user" or
+// "user:group", by name or ID. An ID is -1 if it is not to be changed.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	name, group, hasGroup := strings.Cut(owner, ":")
+	uid, err = strconv.Atoi(name)
+	if err != nil {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: user '%s': %w", errUnknownOwner, name, err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if !hasGroup {
+		return uid, -1, nil
+	}
+
+	gid, err = strconv.Atoi(group)
+	if err != nil {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: group '%s': %w", errUnknownOwner, group, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+// writeFileAtomic replaces the file name with data by renaming a temporary
+// file, so readers never see a partially written file. The owner is changed
+// unless uid and gid are -1.
+func writeFileAtomic(name string, data []byte, mode os.FileMode, uid, gid int) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %w", name, err)
+	}
+	defer os.Remove(f.Name()) // fails once the file is renamed
+
+	if err := writeFile(f, data, mode, uid, gid); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("error replacing %s: %w", name, err)
+	}
+	// persist the rename, which is only recorded in the directory
+	if err := syncDir(filepath.Dir(name)); err != nil {
+		return fmt.Errorf("error syncing directory of %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func writeFile(f *os.File, data []byte, mode os.FileMode, uid, gid int) error {
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("error changing mode of %s to %04o: %w", f.Name(), mode.Perm(), err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := f.Chown(uid, gid); err != nil {
+			return fmt.Errorf("error changing owner of %s to %d:%d: %w", f.Name(), uid, gid, err)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %w", f.Name(), err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing %s: %w", f.Name(), err)
+	}
+
+	return nil
+}
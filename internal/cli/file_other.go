@@ -0,0 +1,17 @@
+//go:build !unix
+
+package cli
+
+import "os"
+
+// fileAttributesMatch reports whether the file of fi has mode and owner. They
+// are not comparable outside of unix, so it always does.
+func fileAttributesMatch(os.FileInfo, os.FileMode, int, int) bool {
+	return true
+}
+
+// syncDir flushes the entries of the directory dir to disk. Directories cannot
+// be synced outside of unix, so it does nothing.
+func syncDir(string) error {
+	return nil
+}
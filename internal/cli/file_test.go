@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func Test_ensureFileCertificate(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDeployment(t, "nas.example.com")
+	dir := filepath.Join(t.TempDir(), "certs")
+	target := TargetConfig{Type: targetFile, Directory: dir, Mode: "0640", PKCS12: true, PKCS12Password: "secret"}
+
+	if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+
+	stats := map[string]os.FileInfo{}
+	for _, name := range []string{fileFullchain, filePrivkey, fileCombined, filePKCS12} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fi.Mode().Perm() != 0o640 {
+			t.Errorf("%s mode = %v, want 0640", name, fi.Mode().Perm())
+		}
+		stats[name] = fi
+	}
+
+	p12, err := os.ReadFile(filepath.Join(dir, filePKCS12))
+	if err != nil {
+		t.Fatalf("reading %s: %v", filePKCS12, err)
+	}
+	_, leaf, _, err := pkcs12.DecodeChain(p12, "secret")
+	if err != nil {
+		t.Fatalf("DecodeChain() error = %v", err)
+	}
+	if !leaf.Equal(d.cert.Leaf) {
+		t.Errorf("%s holds %v, want the deployed certificate", filePKCS12, leaf.DNSNames)
+	}
+
+	// the files are only rewritten once the certificate changed
+	if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	for name, before := range stats {
+		after, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !os.SameFile(before, after) {
+			t.Errorf("%s rewritten with an unchanged certificate", name)
+		}
+	}
+
+	renewed, _ := newTestDeployment(t, "nas.example.com")
+	if err := newTestCmd().deploy(t.Context(), renewed, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	for name, before := range stats {
+		after, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if os.SameFile(before, after) {
+			t.Errorf("%s not rewritten with a new certificate", name)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != len(stats) {
+		t.Errorf("directory holds %d files, want %d without temporary files", len(entries), len(stats))
+	}
+}
+
+func Test_ensureFileCertificate_settings(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDeployment(t, "nas.example.com")
+	dir := filepath.Join(t.TempDir(), "certs")
+	target := TargetConfig{Type: targetFile, Directory: dir, Mode: "0640", PKCS12: true, PKCS12Password: "secret"}
+	if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+
+	// only the password of the PKCS #12 bundle changes
+	target.PKCS12Password = "changed"
+	if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	p12, err := os.ReadFile(filepath.Join(dir, filePKCS12))
+	if err != nil {
+		t.Fatalf("reading %s: %v", filePKCS12, err)
+	}
+	if _, _, _, err := pkcs12.DecodeChain(p12, "changed"); err != nil {
+		t.Errorf("DecodeChain() with the changed password error = %v", err)
+	}
+
+	// only the mode changes
+	target.Mode = "0600"
+	if err := newTestCmd().deploy(t.Context(), d, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	for _, name := range []string{fileFullchain, filePrivkey, fileCombined, filePKCS12} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", name, fi.Mode().Perm())
+		}
+	}
+}
+
+func Test_lookupOwner(t *testing.T) {
+	t.Parallel()
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user: %v", err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+
+	tests := []struct {
+		owner   string
+		wantUID int
+		wantGID int
+		wantErr bool
+	}{
+		{"", -1, -1, false},
+		{"1000", 1000, -1, false},
+		{"1000:1001", 1000, 1001, false},
+		{current.Username, uid, -1, false},
+		{"truenas-scale-acme-nobody", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			t.Parallel()
+
+			uid, gid, err := lookupOwner(tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupOwner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (uid != tt.wantUID || gid != tt.wantGID) {
+				t.Errorf("lookupOwner() = %d, %d, want %d, %d", uid, gid, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}
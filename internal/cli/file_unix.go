@@ -0,0 +1,36 @@
+//go:build unix
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileAttributesMatch reports whether the file of fi has mode and is owned by
+// uid and gid, each unless it is -1.
+func fileAttributesMatch(fi os.FileInfo, mode os.FileMode, uid, gid int) bool {
+	if fi.Mode().Perm() != mode.Perm() {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+
+	return (uid == -1 || int(stat.Uid) == uid) && (gid == -1 || int(stat.Gid) == gid)
+}
+
+// syncDir flushes the entries of the directory dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
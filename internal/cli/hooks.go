@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/thde/truenas-scale-acme/internal/hook"
+	"go.uber.org/zap"
+)
+
+// runHooks runs the hooks for the target of event in order. A failing hook is
+// logged and, if its policy says so, fails the certificate once all hooks ran.
+func (c cmd) runHooks(ctx context.Context, hooks []HookConfig, event hook.Event) error {
+	logger := c.CLILogger.Named("hook")
+
+	var errs []error
+	for _, h := range hooks {
+		if !h.runsFor(event.Target) {
+			continue
+		}
+
+		h.Logger = logger
+		if err := h.Run(ctx, event); err != nil {
+			logger.Error("hook failed", zap.Stringer("hook", &h.Hook), zap.String("target", event.Target), zap.String("on_failure", h.OnFailure), zap.Error(err))
+			if h.OnFailure == hookFailureFail {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		logger.Info("hook succeeded", zap.Stringer("hook", &h.Hook), zap.String("target", event.Target))
+	}
+
+	return errors.Join(errs...)
+}
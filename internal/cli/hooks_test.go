@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thde/truenas-scale-acme/internal/hook"
+)
+
+func Test_runHooks(t *testing.T) {
+	t.Parallel()
+
+	event := hook.Event{Certificate: "nas", Domain: "nas.example.com", Target: targetFile}
+	tests := []struct {
+		name    string
+		hooks   func(out string) []HookConfig
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "in order",
+			hooks: func(out string) []HookConfig {
+				return []HookConfig{
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo first >> "$0"`, out}}},
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo second >> "$0"`, out}}},
+				}
+			},
+			want: "first\nsecond\n",
+		},
+		{
+			name: "other target",
+			hooks: func(out string) []HookConfig {
+				return []HookConfig{
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo ui >> "$0"`, out}}, Targets: []string{targetUI}},
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo file >> "$0"`, out}}, Targets: []string{targetUI, targetFile}},
+				}
+			},
+			want: "file\n",
+		},
+		{
+			name: "warn",
+			hooks: func(out string) []HookConfig {
+				return []HookConfig{
+					{Hook: hook.Hook{Command: []string{"/bin/false"}}},
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo after >> "$0"`, out}}},
+				}
+			},
+			want: "after\n",
+		},
+		{
+			name: "fail",
+			hooks: func(out string) []HookConfig {
+				return []HookConfig{
+					{Hook: hook.Hook{Command: []string{"/bin/false"}}, OnFailure: hookFailureFail},
+					{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `echo after >> "$0"`, out}}},
+				}
+			},
+			want:    "after\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := filepath.Join(t.TempDir(), "out")
+			err := newTestCmd().runHooks(t.Context(), tt.hooks(out), event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, hook.ErrHookFailed) {
+				t.Errorf("runHooks() error = %v, want %v", err, hook.ErrHookFailed)
+			}
+
+			got, err := os.ReadFile(out)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("reading output: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("hooks ran %q, want %q", strings.TrimSpace(string(got)), strings.TrimSpace(tt.want))
+			}
+		})
+	}
+}
+
+func TestHookConfig_valid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hook    HookConfig
+		wantErr bool
+	}{
+		{"command", HookConfig{Hook: hook.Hook{Command: []string{"/usr/local/bin/reload"}}, Targets: []string{targetFile}, OnFailure: hookFailureFail}, false},
+		{"url", HookConfig{Hook: hook.Hook{URL: "https://monitoring.example.com/hook"}}, false},
+		{"none", HookConfig{}, true},
+		{"unknown target", HookConfig{Hook: hook.Hook{URL: "https://monitoring.example.com/hook"}, Targets: []string{"smb"}}, true},
+		{"policy", HookConfig{Hook: hook.Hook{URL: "https://monitoring.example.com/hook"}, OnFailure: "retry"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := errors.Join(tt.hook.valid()...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errInvalidHook) {
+				t.Errorf("valid() error = %v, want %v", err, errInvalidHook)
+			}
+		})
+	}
+}
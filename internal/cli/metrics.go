@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the names of all metrics.
+const metricsNamespace = "truenas_scale_acme"
+
+// metrics are the Prometheus metrics of the daemon. They are recorded only
+// while the daemon serves them, a nil *metrics records nothing.
+type metrics struct {
+	registry *prometheus.Registry
+
+	certificateNotAfter *prometheus.GaugeVec
+	targetNotAfter      *prometheus.GaugeVec
+	lastIssued          *prometheus.GaugeVec
+	lastDeployed        *prometheus.GaugeVec
+	renewalAttempts     *prometheus.CounterVec
+	renewalFailures     *prometheus.CounterVec
+	rpcDuration         *prometheus.HistogramVec
+	rpcErrors           *prometheus.CounterVec
+	reconnects          *prometheus.CounterVec
+	jobDuration         *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		certificateNotAfter: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_not_after_timestamp_seconds",
+			Help:      "Expiry of the current certificate per domain.",
+		}, []string{"certificate", "domain"}),
+		targetNotAfter: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "target_not_after_timestamp_seconds",
+			Help:      "Expiry of the certificate deployed to a target.",
+		}, []string{"certificate", "target"}),
+		lastIssued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_last_issued_timestamp_seconds",
+			Help:      "Time a certificate was last issued by the CA.",
+		}, []string{"certificate"}),
+		lastDeployed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_last_deployed_timestamp_seconds",
+			Help:      "Time a certificate was last deployed to a target.",
+		}, []string{"certificate", "target"}),
+		renewalAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "renewal_attempts_total",
+			Help:      "Certificates requested from an issuer.",
+		}, []string{"issuer"}),
+		renewalFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "renewal_failures_total",
+			Help:      "Certificates requested from an issuer that were not issued.",
+		}, []string{"issuer"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "truenas_rpc_duration_seconds",
+			Help:      "Duration of the TrueNAS API calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		rpcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "truenas_rpc_errors_total",
+			Help:      "TrueNAS API calls that failed.",
+		}, []string{"method"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "truenas_reconnects_total",
+			Help:      "Attempts to reconnect to the TrueNAS API by result.",
+		}, []string{"result"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "truenas_job_duration_seconds",
+			Help:      "Time waited for TrueNAS jobs to finish.",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"method", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.certificateNotAfter,
+		m.targetNotAfter,
+		m.lastIssued,
+		m.lastDeployed,
+		m.renewalAttempts,
+		m.renewalFailures,
+		m.rpcDuration,
+		m.rpcErrors,
+		m.reconnects,
+		m.jobDuration,
+	)
+
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// result is the result label of err.
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
+
+// certificate records the expiry of the current certificate of cert.
+func (m *metrics) certificate(cert managedCertificate, notAfter time.Time) {
+	if m == nil {
+		return
+	}
+	for _, domain := range cert.Domains {
+		m.certificateNotAfter.WithLabelValues(cert.String(), domain).Set(float64(notAfter.Unix()))
+	}
+}
+
+// issued records that a new certificate was issued for cert.
+func (m *metrics) issued(cert managedCertificate) {
+	if m == nil {
+		return
+	}
+	m.lastIssued.WithLabelValues(cert.String()).SetToCurrentTime()
+}
+
+// target records the expiry of the certificate of cert on target, and the
+// time of the deployment if the target was updated.
+func (m *metrics) target(cert managedCertificate, target TargetConfig, notAfter time.Time, updated bool) {
+	if m == nil {
+		return
+	}
+	for _, consumer := range target.consumers() {
+		m.targetNotAfter.WithLabelValues(cert.String(), consumer).Set(float64(notAfter.Unix()))
+		if updated {
+			m.lastDeployed.WithLabelValues(cert.String(), consumer).SetToCurrentTime()
+		}
+	}
+}
+
+// renewal records a certificate requested from issuer.
+func (m *metrics) renewal(issuer string, err error) {
+	if m == nil {
+		return
+	}
+	m.renewalAttempts.WithLabelValues(issuer).Inc()
+	if err != nil {
+		m.renewalFailures.WithLabelValues(issuer).Inc()
+	}
+}
+
+// Call implements [truenas.Observer].
+func (m *metrics) Call(method string, took time.Duration, err error) {
+	m.rpcDuration.WithLabelValues(method).Observe(took.Seconds())
+	if err != nil {
+		m.rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// Reconnect implements [truenas.Observer].
+func (m *metrics) Reconnect(err error) {
+	m.reconnects.WithLabelValues(result(err)).Inc()
+}
+
+// Job implements [truenas.Observer].
+func (m *metrics) Job(method string, took time.Duration, err error) {
+	m.jobDuration.WithLabelValues(method, result(err)).Observe(took.Seconds())
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func Test_metrics(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.SetServiceState("s3", truenastest.ServiceRunning)
+
+	c := newTestCmd()
+	c.metrics = newMetrics()
+	client, err := c.dial(t.Context(), &Config{API: &APIConfig{APIKey: truenastest.DefaultAPIKey, URL: srv.URL.String()}})
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	cert := managedCertificate{
+		CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com", "s3.example.com"}, Targets: []TargetConfig{{Type: targetS3}}},
+		acmeClient:        newTestMagic(t, newTestIssuer(t)),
+		renewal:           &renewalInfo{},
+	}
+	for range 2 {
+		if err := c.ensureCertificate(t.Context(), cert, client); err != nil {
+			t.Fatalf("ensureCertificate() error = %v", err)
+		}
+	}
+
+	m := c.metrics
+	if got := testutil.ToFloat64(m.renewalAttempts.WithLabelValues("test-issuer")); got != 1 {
+		t.Errorf("renewal attempts = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.renewalFailures.WithLabelValues("test-issuer")); got != 0 {
+		t.Errorf("renewal failures = %v, want 0", got)
+	}
+	series := []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{"certificate_not_after_timestamp_seconds", testutil.CollectAndCount(m.certificateNotAfter), 2},
+		{"target_not_after_timestamp_seconds", testutil.CollectAndCount(m.targetNotAfter), 1},
+		{"certificate_last_issued_timestamp_seconds", testutil.CollectAndCount(m.lastIssued), 1},
+		{"certificate_last_deployed_timestamp_seconds", testutil.CollectAndCount(m.lastDeployed), 1},
+		// certificate.create and service.restart
+		{"truenas_job_duration_seconds", testutil.CollectAndCount(m.jobDuration), 2},
+	}
+	for _, s := range series {
+		if s.count != s.want {
+			t.Errorf("%s has %d series, want %d", s.name, s.count, s.want)
+		}
+	}
+
+	problems, err := testutil.GatherAndLint(m.registry)
+	if err != nil || len(problems) > 0 {
+		t.Errorf("GatherAndLint() = %v, %v", problems, err)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/metrics", http.NoBody))
+	for _, want := range []string{
+		`truenas_scale_acme_truenas_rpc_duration_seconds_count{method="certificate.create"} 1`,
+		`truenas_scale_acme_target_not_after_timestamp_seconds{certificate="nas.example.com",target="s3"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("/metrics does not contain %q", want)
+		}
+	}
+}
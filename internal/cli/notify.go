@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/notify"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+)
+
+// runReport collects the events of a run for the notifications sent after
+// it. A nil *runReport collects nothing.
+type runReport struct {
+	events []notify.Event
+	// expiringSoon is how long before its expiry a certificate that could
+	// not be renewed is reported.
+	expiringSoon time.Duration
+}
+
+// add records an event.
+func (r *runReport) add(event notify.Event) {
+	if r == nil {
+		return
+	}
+	r.events = append(r.events, event)
+}
+
+// changed reports whether anything changed in the run.
+func (r *runReport) changed() bool {
+	return slices.ContainsFunc(r.events, func(e notify.Event) bool {
+		return e.Severity == notify.SeverityInfo
+	})
+}
+
+// certificateEvent returns an event of typ about the certificate of config,
+// and about leaf if it is set.
+func certificateEvent(typ string, config *CertificateConfig, leaf *x509.Certificate, format string, args ...any) notify.Event {
+	event := notify.NewEvent(typ, fmt.Sprintf(format, args...))
+	event.Certificate, event.Domains = config.String(), config.Domains
+	if leaf != nil {
+		event.Serial, event.NotAfter = leaf.SerialNumber.Text(16), leaf.NotAfter
+	}
+
+	return event
+}
+
+// deployedEvent returns the event of the certificate of cert, leaf, deployed
+// to target.
+func deployedEvent(cert managedCertificate, target TargetConfig, leaf *x509.Certificate) notify.Event {
+	event := certificateEvent(notify.EventDeployed, cert.CertificateConfig, leaf, "deployed certificate for %s to %s", cert, target.Type)
+	if target.Type == targetUI {
+		event = certificateEvent(notify.EventUISwitched, cert.CertificateConfig, leaf, "switched the ui to the certificate for %s", cert)
+	}
+	event.Target = target.Type
+
+	return event
+}
+
+// reportExpiring reports the stored certificate of cert, which could not be
+// renewed, if it expires soon.
+func (c cmd) reportExpiring(ctx context.Context, cert managedCertificate) {
+	if c.report == nil {
+		return
+	}
+	stored, _, err := loadCertificate(ctx, cert.acmeClient, cert.Domains)
+	if err != nil || time.Until(stored.Leaf.NotAfter) > c.report.expiringSoon {
+		return
+	}
+
+	c.report.add(certificateEvent(notify.EventExpiringSoon, cert.CertificateConfig, stored.Leaf,
+		"certificate for %s could not be renewed and expires %s", cert, formatTime(stored.Leaf.NotAfter)))
+}
+
+// newNotifier returns the notifier of the sinks of config, nil if there are
+// none.
+func (c cmd) newNotifier(config *Config) *notify.Notifier {
+	if config.Notify == nil || len(config.Notify.Sinks) == 0 {
+		return nil
+	}
+	n, err := notify.New(config.Notify.Sinks, c.CLILogger.Named("notify"))
+	if err != nil {
+		c.CLILogger.Warn("error setting up notifications", zap.Error(err))
+		return nil
+	}
+
+	return n
+}
+
+// sendEvents sends events to the notification sinks, a failure is only
+// logged.
+func (c cmd) sendEvents(ctx context.Context, events ...notify.Event) {
+	if err := c.notifier.Notify(ctx, events...); err != nil {
+		c.CLILogger.Warn("error sending notifications", zap.Error(err))
+	}
+}
+
+// ensureAndNotify ensures the certificates and then sends the notifications
+// of config about the run.
+func (c cmd) ensureAndNotify(ctx context.Context, config *Config, certs []managedCertificate, tnClient *truenas.Client) error {
+	c.report = &runReport{expiringSoon: config.Notify.expiringSoon()}
+	if c.notifier == nil {
+		c.notifier = c.newNotifier(config)
+	}
+	err := c.ensureCertificates(ctx, certs, tnClient)
+	c.notify(ctx, config, tnClient, c.report, err)
+
+	return err
+}
+
+// notify raises or clears the TrueNAS alert, sends the mail summary and the
+// events of a run that reported report and failed with runErr, as far as
+// config asks for them. Failing notifications are only logged.
+func (c cmd) notify(ctx context.Context, config *Config, tnClient *truenas.Client, report *runReport, runErr error) {
+	if config.Alert != nil {
+		if err := c.updateAlert(ctx, tnClient, config.Alert, runErr); err != nil {
+			c.CLILogger.Warn("error updating alert", zap.String("class", config.Alert.class()), zap.Error(err))
+		}
+	}
+
+	if config.Mail != nil && config.Mail.sendsFor(runErr) && (runErr != nil || report.changed()) {
+		msg := mailSummary(report, runErr)
+		msg.To = config.Mail.To
+		if err := tnClient.MailSend(ctx, msg); err != nil {
+			c.CLILogger.Warn("error sending mail", zap.String("subject", msg.Subject), zap.Error(err))
+		} else {
+			c.CLILogger.Info("mail sent", zap.String("subject", msg.Subject))
+		}
+	}
+
+	c.sendEvents(ctx, report.events...)
+}
+
+// alertID is the id the alert is raised and cleared with.
+const alertID = "truenas-scale-acme"
+
+// updateAlert raises the alert of ac if runErr is set, and otherwise clears
+// it if a previous run raised it.
+func (c cmd) updateAlert(ctx context.Context, tnClient *truenas.Client, ac *AlertConfig, runErr error) error {
+	class := ac.class()
+	if runErr != nil {
+		c.CLILogger.Info("raising alert", zap.String("class", class))
+		return tnClient.AlertOneshotCreate(ctx, class, alertArgs(runErr))
+	}
+
+	raised, err := alertRaised(ctx, tnClient, class)
+	if err != nil || !raised {
+		return err
+	}
+	c.CLILogger.Info("clearing alert", zap.String("class", class))
+	return tnClient.AlertOneshotDelete(ctx, class, alertID)
+}
+
+// alertArgs returns the arguments of the alert of a run failing with err, in
+// the shape of the [alertClasses]: their text formats the name and, for
+// ReplicationFailed, the message.
+func alertArgs(err error) map[string]string {
+	return map[string]string{"id": alertID, "name": alertID, "message": err.Error()}
+}
+
+// alertRaised reports whether the alert of class is raised.
+func alertRaised(ctx context.Context, tnClient *truenas.Client, class string) (bool, error) {
+	alerts, err := tnClient.AlertList(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error listing alerts: %w", err)
+	}
+
+	return slices.ContainsFunc(alerts, func(a truenas.Alert) bool {
+		var args struct {
+			ID string `json:"id"`
+		}
+		return a.Klass == class && json.Unmarshal(a.Args, &args) == nil && args.ID == alertID
+	}), nil
+}
+
+// mailSummary returns the summary of a run that reported report and failed
+// with runErr.
+func mailSummary(report *runReport, runErr error) truenas.MailMessage {
+	var b strings.Builder
+	msg := truenas.MailMessage{Subject: "truenas-scale-acme: certificates updated"}
+	if runErr != nil {
+		msg.Subject = "truenas-scale-acme: run failed"
+		fmt.Fprintln(&b, "Errors:")
+		for _, err := range unwrapJoined(runErr) {
+			fmt.Fprintf(&b, "- %v\n", err)
+		}
+		fmt.Fprintln(&b)
+	}
+	if warnings := eventsOf(report, notify.SeverityWarning); len(warnings) > 0 {
+		fmt.Fprintln(&b, "Warnings:")
+		for _, event := range warnings {
+			fmt.Fprintf(&b, "- %s\n", event.Summary)
+		}
+		fmt.Fprintln(&b)
+	}
+	if changes := eventsOf(report, notify.SeverityInfo); len(changes) > 0 {
+		fmt.Fprintln(&b, "Changes:")
+		for _, event := range changes {
+			fmt.Fprintf(&b, "- %s\n", event.Summary)
+		}
+	} else {
+		fmt.Fprintln(&b, "No changes.")
+	}
+	msg.Text = b.String()
+
+	return msg
+}
+
+// eventsOf returns the events of report with severity.
+func eventsOf(report *runReport, severity notify.Severity) []notify.Event {
+	var events []notify.Event
+	for _, event := range report.events {
+		if event.Severity == severity {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// unwrapJoined returns the errors joined into err, or err itself.
+func unwrapJoined(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+
+	return []error{err}
+}
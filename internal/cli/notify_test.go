@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/notify"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func Test_notify(t *testing.T) {
+	t.Parallel()
+
+	errRun := errors.New("run failed")
+	issued := notify.NewEvent(notify.EventIssued, "issued")
+	tests := []struct {
+		name        string
+		mail        *MailConfig
+		failMails   bool
+		raised      bool
+		events      []notify.Event
+		runErr      error
+		wantAlert   bool
+		wantClears  int
+		wantSubject string
+	}{
+		{"success without changes", &MailConfig{On: []string{mailOnSuccess}}, false, true, nil, nil, false, 1, ""},
+		{"success without alert", &MailConfig{On: []string{mailOnSuccess}}, false, false, nil, nil, false, 0, ""},
+		{"success with changes", &MailConfig{On: []string{mailOnSuccess}}, false, true, []notify.Event{issued}, nil, false, 1, "truenas-scale-acme: certificates updated"},
+		{"success not mailed", &MailConfig{}, false, true, []notify.Event{issued}, nil, false, 1, ""},
+		{"failure", &MailConfig{}, false, true, nil, errRun, true, 0, "truenas-scale-acme: run failed"},
+		{"failure not mailed", &MailConfig{On: []string{mailOnSuccess}}, false, false, nil, errRun, true, 0, ""},
+		{"mail failing", &MailConfig{}, true, true, nil, errRun, true, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := truenastest.NewServer()
+			t.Cleanup(srv.Close)
+			if tt.failMails {
+				srv.FailMails()
+			}
+			client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			t.Cleanup(client.Close)
+
+			const class = defaultAlertClass
+			if tt.raised {
+				// a previous failure raised the alert
+				if err := client.AlertOneshotCreate(t.Context(), class, alertArgs(errors.New("previous"))); err != nil {
+					t.Fatalf("AlertOneshotCreate() error = %v", err)
+				}
+			}
+
+			config := &Config{Alert: &AlertConfig{}, Mail: tt.mail}
+			newTestCmd().notify(t.Context(), config, client, &runReport{events: tt.events}, tt.runErr)
+
+			alert, raised := srv.Alerts()[class]
+			if raised != tt.wantAlert {
+				t.Errorf("alert raised = %t, want %t", raised, tt.wantAlert)
+			}
+			if tt.wantAlert && !strings.Contains(string(alert), tt.runErr.Error()) {
+				t.Errorf("alert = %s, want it to contain %q", alert, tt.runErr)
+			}
+			if got := srv.Calls("alert.oneshot_delete"); got != tt.wantClears {
+				t.Errorf("alert cleared %d times, want %d", got, tt.wantClears)
+			}
+
+			mails := srv.Mails()
+			switch {
+			case tt.wantSubject == "" && len(mails) != 0:
+				t.Errorf("mails = %+v, want none", mails)
+			case tt.wantSubject != "" && (len(mails) != 1 || mails[0].Subject != tt.wantSubject):
+				t.Errorf("mails = %+v, want one with subject %q", mails, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func Test_updateAlert_unknownClass(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	if err := newTestCmd().updateAlert(t.Context(), client, &AlertConfig{Class: "CertificateRenewalFailed"}, errors.New("run failed")); err == nil {
+		t.Error("updateAlert() error = nil, want the unknown class rejected")
+	}
+}
+
+func Test_mailSummary(t *testing.T) {
+	t.Parallel()
+
+	cert := managedCertificate{CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com"}}}
+	report := &runReport{}
+	report.add(deployedEvent(cert, TargetConfig{Type: targetS3}, nil))
+	report.add(notify.NewEvent(notify.EventExpiringSoon, "expires soon"))
+	msg := mailSummary(report, errors.Join(errors.New("first"), errors.New("second")))
+
+	for _, want := range []string{"- first\n", "- second\n", "Warnings:\n- expires soon\n", "Changes:\n- deployed certificate for nas.example.com to s3\n"} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("mailSummary() text = %q, want it to contain %q", msg.Text, want)
+		}
+	}
+}
+
+func Test_notify_sinks(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan notify.Event, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err == nil {
+			received <- event
+		}
+	}))
+	t.Cleanup(sink.Close)
+
+	c := newTestCmd()
+	config := &Config{Notify: &NotifyConfig{Sinks: []notify.Sink{{Type: notify.TypeWebhook, URL: sink.URL, Severity: "warning"}}}}
+	c.notifier = c.newNotifier(config)
+
+	cert := managedCertificate{CertificateConfig: &CertificateConfig{Name: "nas", Domains: []string{"nas.example.com"}}}
+	failed := certificateEvent(notify.EventFailed, cert.CertificateConfig, nil, "error ensuring certificate for %s", cert)
+	report := &runReport{events: []notify.Event{deployedEvent(cert, TargetConfig{Type: targetUI}, nil), failed}}
+	c.notify(t.Context(), config, nil, report, errors.New("run failed"))
+
+	if len(received) != 1 {
+		t.Fatalf("received %d events, want 1", len(received))
+	}
+	if event := <-received; event.Type != notify.EventFailed || event.Certificate != "nas" || event.Severity != notify.SeverityError {
+		t.Errorf("event = %+v, want the failed event of nas", event)
+	}
+}
+
+func Test_cmd_reportExpiring(t *testing.T) {
+	t.Parallel()
+
+	magic := newTestMagic(t, newTestIssuer(t))
+	cert := managedCertificate{CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com"}}, acmeClient: magic}
+	if _, err := newTestCmd().obtainCertificate(t.Context(), magic, cert.Domains); err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		expiringSoon time.Duration
+		want         int
+	}{
+		{"not yet", time.Hour, 0},
+		{"expiring soon", 10 * 365 * 24 * time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestCmd()
+			c.report = &runReport{expiringSoon: tt.expiringSoon}
+			c.reportExpiring(t.Context(), cert)
+			if len(c.report.events) != tt.want {
+				t.Fatalf("events = %+v, want %d", c.report.events, tt.want)
+			}
+			if tt.want > 0 && (c.report.events[0].Type != notify.EventExpiringSoon || c.report.events[0].NotAfter.IsZero()) {
+				t.Errorf("event = %+v, want expiring soon", c.report.events[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+)
+
+// Output formats of a dry run.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// errInvalidOutput is returned for an unknown output format.
+var errInvalidOutput = errors.New("invalid output format")
+
+// Actions of a plan.
+const (
+	actionImport  = "import"
+	actionUI      = "ui"
+	actionService = "service"
+	actionApp     = "app"
+	actionFiles   = "files"
+	actionHooks   = "hooks"
+	actionDelete  = "delete"
+)
+
+// plan describes the changes ensuring the certificates would make.
+type plan struct {
+	Certificates []*certificatePlan `json:"certificates"`
+}
+
+// certificatePlan describes the changes ensuring a certificate would make.
+type certificatePlan struct {
+	Certificate string   `json:"certificate"`
+	Domains     []string `json:"domains"`
+	// Renew is set if a new certificate would be obtained from the CA, Reason
+	// tells why.
+	Renew  bool   `json:"renew"`
+	Reason string `json:"reason,omitempty"`
+	// NotAfter and RenewAt describe the certificate in storage, if any.
+	NotAfter time.Time    `json:"not_after,omitzero"`
+	RenewAt  time.Time    `json:"renew_at,omitzero"`
+	Actions  []planAction `json:"actions"`
+	// Error is set if the plan could not be completed.
+	Error string `json:"error,omitempty"`
+}
+
+// planAction is a change to TrueNAS or the targets.
+type planAction struct {
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	// CertificateID is the TrueNAS certificate used or removed, 0 for a
+	// certificate that would be imported first.
+	CertificateID   int    `json:"certificate_id,omitempty"`
+	CertificateName string `json:"certificate_name,omitempty"`
+	Description     string `json:"description"`
+}
+
+func (p *certificatePlan) add(action planAction) {
+	p.Actions = append(p.Actions, action)
+}
+
+// dryRun prints the changes ensuring certs would make in the output format,
+// without making them or contacting the CA. The certificates that could not be
+// planned are returned as error.
+func (c cmd) dryRun(ctx context.Context, certs []managedCertificate, tnClient *truenas.Client, output string) error {
+	inUse, err := certificatesInUse(ctx, tnClient, certificateTargets(certs))
+	if err != nil {
+		return err
+	}
+
+	p := &plan{}
+	var errs []error
+	for _, cert := range certs {
+		certPlan := c.planCertificate(ctx, cert, tnClient, inUse)
+		if certPlan.Error != "" {
+			errs = append(errs, fmt.Errorf("certificate %s: %s", cert, certPlan.Error))
+		}
+		p.Certificates = append(p.Certificates, certPlan)
+	}
+
+	if output == outputJSON {
+		enc := json.NewEncoder(c.Out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(p); err != nil {
+			return fmt.Errorf("error writing plan: %w", err)
+		}
+	} else if err := p.write(c.Out); err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+// planCertificate works out the changes ensuring cert would make. TrueNAS is
+// only read from, inUse are the certificates in use.
+func (c cmd) planCertificate(ctx context.Context, cert managedCertificate, tnClient *truenas.Client, inUse map[int]bool) *certificatePlan {
+	p := &certificatePlan{Certificate: cert.String(), Domains: cert.Domains, Actions: []planAction{}}
+
+	stored, err := c.planRenewal(ctx, cert, p)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+
+	d := &deployment{
+		config:       cert.CertificateConfig,
+		cert:         stored,
+		client:       tnClient,
+		staging:      cert.acme.Staging,
+		forceStaging: cert.acme.ForceStaging,
+		plan:         p,
+	}
+	for _, target := range cert.Targets {
+		d.updated = false
+		if err := c.deploy(ctx, d, target); err != nil {
+			p.Error = fmt.Sprintf("error deploying to %s: %v", target.Type, err)
+			return p
+		}
+		if !d.updated {
+			continue
+		}
+
+		var hooks []string
+		for _, h := range cert.hooks {
+			if h.runsFor(target.Type) {
+				hooks = append(hooks, h.String())
+			}
+		}
+		if len(hooks) > 0 {
+			p.add(planAction{Action: actionHooks, Target: target.Type, Description: "run hooks " + strings.Join(hooks, ", ")})
+		}
+	}
+
+	if err := c.removeExpiredCerts(ctx, tnClient, cert.CertificateConfig, inUse, p); err != nil {
+		p.Error = err.Error()
+	}
+
+	return p
+}
+
+// planRenewal decides from storage alone whether cert would be renewed and
+// returns the stored certificate, if any.
+func (c cmd) planRenewal(ctx context.Context, cert managedCertificate, p *certificatePlan) (certmagic.Certificate, error) {
+	stored, issuer, err := loadCertificate(ctx, cert.acmeClient, cert.Domains)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		p.Renew, p.Reason = true, "no certificate in storage"
+		return stored, nil
+	case err != nil:
+		return stored, err
+	}
+
+	ri := storedRenewalInfo(ctx, cert.acmeClient, issuer, stored)
+	p.NotAfter, p.RenewAt = ri.NotAfter, ri.RenewAt
+	switch {
+	case cert.forceRenewal:
+		p.Renew, p.Reason = true, "renewal forced"
+	case !time.Now().Before(ri.RenewAt):
+		p.Renew, p.Reason = true, "due since "+formatTime(ri.RenewAt)
+	case keyTypeChanged(cert.acmeClient, stored):
+		p.Renew, p.Reason = true, "key type changed"
+	}
+
+	return stored, nil
+}
+
+// write prints the plan for humans.
+func (p *plan) write(w io.Writer) error {
+	var b strings.Builder
+	for _, cert := range p.Certificates {
+		switch {
+		case cert.Renew:
+			fmt.Fprintf(&b, "certificate %s: obtain a new certificate, %s\n", cert.Certificate, cert.Reason)
+		default:
+			fmt.Fprintf(&b, "certificate %s: up to date, expires %s, renewal at %s\n",
+				cert.Certificate, formatTime(cert.NotAfter), formatTime(cert.RenewAt))
+		}
+		for _, action := range cert.Actions {
+			fmt.Fprintf(&b, "  %s\n", action.Description)
+		}
+		if len(cert.Actions) == 0 && cert.Error == "" {
+			fmt.Fprintln(&b, "  no changes")
+		}
+		if cert.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", cert.Error)
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("error writing plan: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thde/truenas-scale-acme/internal/hook"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func Test_planCertificate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// obtained stores a certificate before planning.
+		obtained bool
+		// deployed ensures the certificate before planning.
+		deployed  bool
+		force     bool
+		wantRenew bool
+		want      []string
+	}{
+		{
+			name:      "not obtained",
+			wantRenew: true,
+			want:      []string{actionImport, actionUI, actionHooks, actionService, actionFiles, actionDelete},
+		},
+		{
+			name:     "obtained",
+			obtained: true,
+			want:     []string{actionImport, actionUI, actionHooks, actionService, actionFiles, actionDelete},
+		},
+		{
+			name:     "deployed",
+			obtained: true,
+			deployed: true,
+			want:     nil,
+		},
+		{
+			name:      "forced",
+			obtained:  true,
+			deployed:  true,
+			force:     true,
+			wantRenew: true,
+			want:      []string{actionImport, actionUI, actionHooks, actionService, actionFiles},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := truenastest.NewServer()
+			t.Cleanup(srv.Close)
+			client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			t.Cleanup(client.Close)
+			srv.SetServiceState("s3", truenastest.ServiceRunning)
+			addTestCertificate(t, srv, testCertificate{name: "expired", expired: true})
+
+			hookOut := filepath.Join(t.TempDir(), "hook")
+			cert := managedCertificate{
+				CertificateConfig: &CertificateConfig{
+					Domains: []string{"nas.example.com"},
+					Targets: []TargetConfig{
+						{Type: targetUI},
+						{Type: targetS3},
+						{Type: targetFile, Directory: t.TempDir()},
+					},
+				},
+				acmeClient:   newTestMagic(t, newTestIssuer(t)),
+				renewal:      &renewalInfo{},
+				hooks:        []HookConfig{{Hook: hook.Hook{Command: []string{"/bin/sh", "-c", `touch "$0"`, hookOut}}, Targets: []string{targetUI}}},
+				forceRenewal: tt.force,
+			}
+			if tt.obtained {
+				if _, err := newTestCmd().obtainCertificate(t.Context(), cert.acmeClient, cert.Domains); err != nil {
+					t.Fatalf("obtainCertificate() error = %v", err)
+				}
+			}
+			if tt.deployed {
+				deployed := cert
+				deployed.hooks, deployed.forceRenewal = nil, false
+				if err := newTestCmd().ensureCertificates(t.Context(), []managedCertificate{deployed}, client); err != nil {
+					t.Fatalf("ensureCertificates() error = %v", err)
+				}
+			}
+			calls := map[string]int{}
+			for _, method := range []string{"certificate.create", "certificate.delete", "system.general.update", "s3.update"} {
+				calls[method] = srv.Calls(method)
+			}
+
+			inUse, err := certificatesInUse(t.Context(), client, cert.Targets)
+			if err != nil {
+				t.Fatalf("certificatesInUse() error = %v", err)
+			}
+			p := newTestCmd().planCertificate(t.Context(), cert, client, inUse)
+			if p.Error != "" {
+				t.Fatalf("planCertificate() error = %s", p.Error)
+			}
+			if p.Renew != tt.wantRenew {
+				t.Errorf("planCertificate() renew = %t (%s), want %t", p.Renew, p.Reason, tt.wantRenew)
+			}
+			var got []string
+			for _, action := range p.Actions {
+				got = append(got, action.Action)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planCertificate() actions = %v, want %v", got, tt.want)
+			}
+
+			for method, before := range calls {
+				if got := srv.Calls(method); got != before {
+					t.Errorf("%s called %d times during the dry run", method, got-before)
+				}
+			}
+			if _, err := os.Stat(hookOut); err == nil {
+				t.Error("hook ran during the dry run")
+			}
+		})
+	}
+}
+
+func Test_dryRun_output(t *testing.T) {
+	t.Parallel()
+
+	d, _ := newTestDeployment(t, "nas.example.com")
+	cert := managedCertificate{
+		CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com"}, Targets: []TargetConfig{{Type: targetUI}}},
+		acmeClient:        newTestMagic(t, newTestIssuer(t)),
+		renewal:           &renewalInfo{},
+	}
+
+	var text strings.Builder
+	c := newTestCmd()
+	c.Out = &text
+	if err := c.dryRun(t.Context(), []managedCertificate{cert}, d.client, outputText); err != nil {
+		t.Fatalf("dryRun() error = %v", err)
+	}
+	for _, want := range []string{"certificate nas.example.com: obtain a new certificate", "set ui certificate to"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("dryRun() text = %q, want it to contain %q", text.String(), want)
+		}
+	}
+
+	var out strings.Builder
+	c.Out = &out
+	if err := c.dryRun(t.Context(), []managedCertificate{cert}, d.client, outputJSON); err != nil {
+		t.Fatalf("dryRun() error = %v", err)
+	}
+	var p plan
+	if err := json.Unmarshal([]byte(out.String()), &p); err != nil {
+		t.Fatalf("decoding plan %q: %v", out.String(), err)
+	}
+	if len(p.Certificates) != 1 || !p.Certificates[0].Renew || len(p.Certificates[0].Actions) != 2 {
+		t.Errorf("dryRun() json = %s, want a renewal, an import and a ui switch", out.String())
+	}
+}
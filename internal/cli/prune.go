@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/thde/truenas-scale-acme/internal/notify"
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+)
+
+// prune removes the expired certificates that are not in use from TrueNAS,
+// after listing them. With --dry-run, they are only listed.
+func (c cmd) prune(ctx context.Context, opts *options) error {
+	config, err := c.config(opts)
+	if err != nil {
+		return err
+	}
+	tnClient, err := c.dial(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	certs, err := tnClient.Certificates(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing certificates: %w", err)
+	}
+	inUse, err := certificatesInUse(ctx, tnClient, config.targets())
+	if err != nil {
+		return err
+	}
+
+	prunable := prunableCertificates(certs, inUse, config.Certificates)
+	if len(prunable) == 0 {
+		fmt.Fprintln(c.Out, "no expired certificates to remove")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tNAMES\tEXPIRED")
+	for _, cert := range prunable {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", cert.ID, cert.Name, certificateNames(cert), formatTime(cert.Until.Time))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing prunable certificates: %w", err)
+	}
+	if opts.dryRun {
+		fmt.Fprintf(c.Out, "\n%d certificates would be removed, run without --dry-run to remove them\n", len(prunable))
+		return nil
+	}
+
+	var errs []error
+	var events []notify.Event
+	for _, cert := range prunable {
+		c.ScaleLogger.Info("removing expired certificate", zap.Int("id", cert.ID), zap.String("name", cert.Name), zap.Time("expired", cert.Until.Time))
+		if err := tnClient.CertificateDelete(ctx, cert.ID); err != nil {
+			errs = append(errs, fmt.Errorf("error removing certificate %d %q: %w", cert.ID, cert.Name, err))
+			continue
+		}
+		event := notify.NewEvent(notify.EventPruned, fmt.Sprintf("removed expired certificate %q (ID %d)", cert.Name, cert.ID))
+		event.Domains = cert.DNSNames()
+		events = append(events, event)
+	}
+	fmt.Fprintf(c.Out, "\n%d certificates removed\n", len(events))
+	c.notifier = c.newNotifier(config)
+	c.sendEvents(ctx, events...)
+
+	return errors.Join(errs...)
+}
+
+// prunableCertificates returns the expired certificates that are not in use
+// and were either imported by this tool or cover the names of one of configs.
+func prunableCertificates(certs []truenas.Certificate, inUse map[int]bool, configs []CertificateConfig) []truenas.Certificate {
+	var prunable []truenas.Certificate
+	for _, cert := range certs {
+		if !cert.Expired || inUse[cert.ID] {
+			continue
+		}
+		managed := slices.ContainsFunc(configs, func(config CertificateConfig) bool {
+			return sameNames(cert.DNSNames(), config.Domains)
+		})
+		if !managed && !isACMECertificate(cert.Name) {
+			continue
+		}
+		prunable = append(prunable, cert)
+	}
+
+	return prunable
+}
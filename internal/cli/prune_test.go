@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+)
+
+func Test_prunableCertificates(t *testing.T) {
+	t.Parallel()
+
+	certs := []truenas.Certificate{
+		{ID: 1, Name: "acme-20250101-000000", Expired: true, SAN: []string{"DNS:old.example.com"}},
+		{ID: 2, Name: "custom", Expired: true, SAN: []string{"DNS:nas.example.com"}},
+		{ID: 3, Name: "custom-other", Expired: true, SAN: []string{"DNS:other.example.com"}},
+		{ID: 4, Name: "acme-20250201-000000", Expired: true, SAN: []string{"DNS:nas.example.com"}},
+		{ID: 5, Name: "acme-20260101-000000", SAN: []string{"DNS:nas.example.com"}},
+	}
+	inUse := map[int]bool{4: true}
+	configs := []CertificateConfig{{Domains: []string{"nas.example.com"}}}
+
+	var got []int
+	for _, cert := range prunableCertificates(certs, inUse, configs) {
+		got = append(got, cert.ID)
+	}
+	if want := []int{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("prunableCertificates() = %v, want %v", got, want)
+	}
+}
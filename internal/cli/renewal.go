@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/v3/acme"
+	"go.uber.org/zap"
+)
+
+// minRenewalCheckInterval is the shortest time the daemon waits before checking
+// a certificate again, so a failing renewal does not hammer the CA.
+const minRenewalCheckInterval = 10 * time.Minute
+
+// renewalInfo describes when a certificate is due for renewal.
+type renewalInfo struct {
+	NotAfter time.Time `json:"not_after"`
+	// RenewAt is the time the certificate is renewed at the latest, the
+	// earlier of the time selected in the ARI window and the start of
+	// certmagic's renewal window.
+	RenewAt time.Time `json:"renew_at"`
+	// ARI is the renewal information suggested by the CA, nil if the CA does
+	// not support ACME Renewal Information.
+	ARI *acme.RenewalInfo `json:"ari,omitempty"`
+}
+
+// nextCheck returns when the certificate should be checked again: when it is
+// due for renewal or when the CA wants to be asked for a new renewal window,
+// whichever is earlier.
+func (ri renewalInfo) nextCheck() time.Time {
+	next := ri.RenewAt
+	if ri.ARI != nil && ri.ARI.RetryAfter != nil && ri.ARI.RetryAfter.Before(next) {
+		next = *ri.ARI.RetryAfter
+	}
+
+	return next
+}
+
+// renewalWindowStart returns when leaf enters certmagic's renewal window.
+func renewalWindowStart(magic *certmagic.Config, leaf *x509.Certificate) time.Time {
+	ratio := magic.RenewalWindowRatio
+	if ratio == 0 {
+		ratio = certmagic.DefaultRenewalWindowRatio
+	}
+
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotAfter.Add(-time.Duration(float64(lifetime) * ratio))
+}
+
+// renewalInfo returns when cert, obtained from issuer, is due for renewal. The
+// ACME Renewal Information kept in storage is refreshed from the CA once it
+// asks for it.
+func (c cmd) renewalInfo(ctx context.Context, magic *certmagic.Config, issuer certmagic.Issuer, cert certmagic.Certificate) renewalInfo {
+	ri := renewalInfo{NotAfter: cert.Leaf.NotAfter, RenewAt: renewalWindowStart(magic, cert.Leaf)}
+	if magic.DisableARI {
+		return ri
+	}
+	getter, ok := issuer.(certmagic.RenewalInfoGetter)
+	if !ok {
+		return ri
+	}
+	logger := c.CertLogger.With(zap.Strings("names", cert.Names), zap.String("issuer", issuer.IssuerKey()))
+
+	metaKey := certmagic.StorageKeys.SiteMeta(issuer.IssuerKey(), namesKey(cert.Names))
+	res, acmeCert, metaErr := loadACMEMeta(ctx, magic, metaKey)
+	if metaErr != nil {
+		logger.Warn("reading renewal information", zap.Error(metaErr))
+	}
+
+	ari := acmeCert.RenewalInfo
+	if ari == nil || !ari.HasWindow() || ari.NeedsRefresh() {
+		fetched, err := getter.GetRenewalInfo(ctx, cert)
+		switch {
+		case errors.Is(err, acme.ErrUnsupported):
+			logger.Debug("CA does not support renewal information")
+		case err != nil:
+			logger.Warn("fetching renewal information", zap.Error(err))
+		default:
+			if ari == nil || !fetched.SameWindow(*ari) {
+				logger.Info("renewal window updated",
+					zap.Time("window_start", fetched.SuggestedWindow.Start),
+					zap.Time("window_end", fetched.SuggestedWindow.End),
+					zap.Time("selected_time", fetched.SelectedTime),
+					zap.String("explanation_url", fetched.ExplanationURL),
+				)
+			}
+			ari = &fetched
+			acmeCert.RenewalInfo = ari
+			if metaErr != nil {
+				break // do not replace metadata we could not read
+			}
+			if err := storeACMEMeta(ctx, magic, metaKey, res, acmeCert); err != nil {
+				logger.Warn("storing renewal information", zap.Error(err))
+			}
+		}
+	}
+
+	ri.setARI(ari)
+
+	return ri
+}
+
+// setARI moves the renewal time to the time selected in the window suggested
+// by the CA, if it is earlier.
+func (ri *renewalInfo) setARI(ari *acme.RenewalInfo) {
+	if ari == nil || !ari.HasWindow() {
+		return
+	}
+
+	ri.ARI = ari
+	if ari.SelectedTime.Before(ri.RenewAt) {
+		ri.RenewAt = ari.SelectedTime
+	}
+}
+
+// storedRenewalInfo returns when cert, obtained from issuer, is due for
+// renewal by the renewal information kept in storage, without asking the CA.
+func storedRenewalInfo(ctx context.Context, magic *certmagic.Config, issuer certmagic.Issuer, cert certmagic.Certificate) renewalInfo {
+	ri := renewalInfo{NotAfter: cert.Leaf.NotAfter, RenewAt: renewalWindowStart(magic, cert.Leaf)}
+	if magic.DisableARI {
+		return ri
+	}
+
+	metaKey := certmagic.StorageKeys.SiteMeta(issuer.IssuerKey(), namesKey(cert.Names))
+	if _, acmeCert, err := loadACMEMeta(ctx, magic, metaKey); err == nil {
+		ri.setARI(acmeCert.RenewalInfo)
+	}
+
+	return ri
+}
+
+// certificateRenewal returns when the stored certificate for names is due for
+// renewal. The renewal information is not fetched again, the renewal decision
+// of the run already refreshed it in storage.
+func certificateRenewal(ctx context.Context, magic *certmagic.Config, names []string) (renewalInfo, error) {
+	cert, issuer, err := loadCertificate(ctx, magic, names)
+	if err != nil {
+		return renewalInfo{}, err
+	}
+
+	return storedRenewalInfo(ctx, magic, issuer, cert), nil
+}
+
+// loadACMEMeta reads the certificate metadata stored at key and the ACME
+// details certmagic keeps in it.
+func loadACMEMeta(ctx context.Context, magic *certmagic.Config, key string) (certmagic.CertificateResource, acme.Certificate, error) {
+	var (
+		res      certmagic.CertificateResource
+		acmeCert acme.Certificate
+	)
+
+	meta, err := magic.Storage.Load(ctx, key)
+	if err != nil {
+		return res, acmeCert, fmt.Errorf("loading %s: %w", key, err)
+	}
+	if err := json.Unmarshal(meta, &res); err != nil {
+		return res, acmeCert, fmt.Errorf("parsing %s: %w", key, err)
+	}
+	if len(res.IssuerData) > 0 {
+		if err := json.Unmarshal(res.IssuerData, &acmeCert); err != nil {
+			return res, acmeCert, fmt.Errorf("parsing issuer data of %s: %w", key, err)
+		}
+	}
+
+	return res, acmeCert, nil
+}
+
+// storeACMEMeta writes res with acmeCert as its issuer data to key.
+func storeACMEMeta(ctx context.Context, magic *certmagic.Config, key string, res certmagic.CertificateResource, acmeCert acme.Certificate) error {
+	issuerData, err := json.Marshal(acmeCert)
+	if err != nil {
+		return fmt.Errorf("encoding issuer data: %w", err)
+	}
+	res.IssuerData = issuerData
+
+	meta, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding certificate resource: %w", err)
+	}
+	if err := magic.Storage.Store(ctx, key, meta); err != nil {
+		return fmt.Errorf("storing %s: %w", key, err)
+	}
+
+	return nil
+}
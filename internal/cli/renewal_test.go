@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/v3/acme"
+)
+
+// ariIssuer is a [testIssuer] suggesting a fixed renewal window.
+type ariIssuer struct {
+	*testIssuer
+	window  [2]time.Time
+	fetched int
+}
+
+func (ai *ariIssuer) GetRenewalInfo(context.Context, certmagic.Certificate) (acme.RenewalInfo, error) {
+	ai.fetched++
+
+	var ri acme.RenewalInfo
+	ri.SuggestedWindow.Start, ri.SuggestedWindow.End = ai.window[0], ai.window[1]
+	ri.SelectedTime = ai.window[0]
+	retryAfter := time.Now().Add(6 * time.Hour)
+	ri.RetryAfter = &retryAfter
+
+	return ri, nil
+}
+
+func Test_renewalInfo(t *testing.T) {
+	t.Parallel()
+
+	issuer := &ariIssuer{testIssuer: newTestIssuer(t)}
+	issuer.window = [2]time.Time{time.Now().Add(24 * time.Hour), time.Now().Add(48 * time.Hour)}
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com"}
+
+	cert, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+
+	ri := newTestCmd().renewalInfo(t.Context(), magic, issuer, cert)
+	if ri.ARI == nil || !ri.RenewAt.Equal(issuer.window[0]) {
+		t.Errorf("renewalInfo() RenewAt = %v, want ARI selected time %v", ri.RenewAt, issuer.window[0])
+	}
+	if !ri.NotAfter.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("renewalInfo() NotAfter = %v, want %v", ri.NotAfter, cert.Leaf.NotAfter)
+	}
+	if got, want := ri.nextCheck(), *ri.ARI.RetryAfter; !got.Equal(want) {
+		t.Errorf("nextCheck() = %v, want retry after %v", got, want)
+	}
+
+	// the renewal information is kept in storage until the CA asks for a refresh
+	fetched := issuer.fetched
+	if ri := newTestCmd().renewalInfo(t.Context(), magic, issuer, cert); ri.ARI == nil {
+		t.Error("renewalInfo() ARI = nil, want the stored renewal information")
+	}
+	stored, err := certificateRenewal(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("certificateRenewal() error = %v", err)
+	}
+	if !stored.RenewAt.Equal(ri.RenewAt) {
+		t.Errorf("certificateRenewal() RenewAt = %v, want %v", stored.RenewAt, ri.RenewAt)
+	}
+	if issuer.fetched != fetched {
+		t.Errorf("renewal information fetched %d times, want %d", issuer.fetched, fetched)
+	}
+}
+
+func Test_obtainCertificate_ariOnce(t *testing.T) {
+	t.Parallel()
+
+	issuer := &ariIssuer{testIssuer: newTestIssuer(t)}
+	issuer.window = [2]time.Time{time.Now().Add(24 * time.Hour), time.Now().Add(48 * time.Hour)}
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com"}
+
+	if _, err := newTestCmd().obtainCertificate(t.Context(), magic, names); err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	// a run decides on the renewal, then reports when the certificate is due
+	if _, err := newTestCmd().obtainCertificate(t.Context(), magic, names); err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	ri, err := certificateRenewal(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("certificateRenewal() error = %v", err)
+	}
+	if ri.ARI == nil {
+		t.Error("certificateRenewal() ARI = nil, want the renewal information of the renewal decision")
+	}
+	if issuer.fetched != 1 {
+		t.Errorf("renewal information fetched %d times in a run, want 1", issuer.fetched)
+	}
+}
+
+func Test_obtainCertificate_ari(t *testing.T) {
+	t.Parallel()
+
+	issuer := &ariIssuer{testIssuer: newTestIssuer(t)}
+	issuer.window = [2]time.Time{time.Now().Add(24 * time.Hour), time.Now().Add(48 * time.Hour)}
+	magic := newTestMagic(t, issuer)
+	names := []string{"nas.example.com"}
+
+	first, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	newTestCmd().renewalInfo(t.Context(), magic, issuer, first)
+
+	// e.g. after a revocation, the CA moves the window into the past
+	issuer.window = [2]time.Time{time.Now().Add(-time.Hour), time.Now().Add(-time.Minute)}
+	key := certmagic.StorageKeys.SiteMeta(issuer.IssuerKey(), namesKey(names))
+	res, acmeCert, err := loadACMEMeta(t.Context(), magic, key)
+	if err != nil {
+		t.Fatalf("loadACMEMeta() error = %v", err)
+	}
+	acmeCert.RenewalInfo.RetryAfter = nil // ask the CA again
+	if err := storeACMEMeta(t.Context(), magic, key, res, acmeCert); err != nil {
+		t.Fatalf("storeACMEMeta() error = %v", err)
+	}
+
+	second, err := newTestCmd().obtainCertificate(t.Context(), magic, names)
+	if err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	if second.Leaf.Equal(first.Leaf) {
+		t.Error("obtainCertificate() did not renew a certificate the CA suggested to renew")
+	}
+}
+
+func Test_nextRenewalCheck(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	certs := []managedCertificate{
+		{renewal: &renewalInfo{}},
+		{renewal: &renewalInfo{RenewAt: now.Add(2 * time.Hour)}},
+		{renewal: &renewalInfo{RenewAt: now.Add(time.Hour)}},
+	}
+	if got := nextRenewalCheck(certs); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("nextRenewalCheck() = %v, want %v", got, now.Add(time.Hour))
+	}
+	if got := nextRenewalCheck(certs[:1]); !got.IsZero() {
+		t.Errorf("nextRenewalCheck() = %v, want zero", got)
+	}
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Kinds of failures of a daemon run, each retried with its own policy.
+const (
+	failureACME    = "acme"
+	failureConnect = "connect"
+	failureDeploy  = "deploy"
+)
+
+// failureKinds are the kinds of failures in the order they are reported.
+var failureKinds = []string{failureACME, failureConnect, failureDeploy}
+
+// Errors classifying the failures of a run.
+var (
+	// errACMEFailure wraps a failure obtaining a certificate from the CA.
+	errACMEFailure = errors.New("acme failure")
+	// errConnectFailure wraps a failure reaching the TrueNAS API.
+	errConnectFailure = errors.New("truenas connection failure")
+	// errDeployFailure wraps a failure deploying a certificate.
+	errDeployFailure = errors.New("deploy failure")
+	// errTooManyFailures is returned by the daemon once it gives up.
+	errTooManyFailures = errors.New("too many consecutive failures")
+)
+
+// failureErrors maps the kinds of failures to the errors wrapping them.
+var failureErrors = map[string]error{
+	failureACME:    errACMEFailure,
+	failureConnect: errConnectFailure,
+	failureDeploy:  errDeployFailure,
+}
+
+// defaultRetryPolicies are the retry policies used unless configured
+// otherwise. CAs rate limit failed orders, so ACME failures back off the most.
+var defaultRetryPolicies = map[string]RetryPolicy{
+	failureACME:    {InitialDelay: 300, MaxDelay: 6 * 3600, MaxFailures: 10},
+	failureConnect: {InitialDelay: 10, MaxDelay: 600, MaxFailures: 20},
+	failureDeploy:  {InitialDelay: 60, MaxDelay: 3600, MaxFailures: 10},
+}
+
+// configured returns the configured policy of kind, nil if there is none.
+func (rc *RetryConfig) configured(kind string) *RetryPolicy {
+	switch kind {
+	case failureACME:
+		return rc.ACME
+	case failureConnect:
+		return rc.Connect
+	case failureDeploy:
+		return rc.Deploy
+	}
+
+	return nil
+}
+
+// policy returns the policy of kind, with the values that are not configured
+// taken from the defaults.
+func (rc *RetryConfig) policy(kind string) RetryPolicy {
+	p := defaultRetryPolicies[kind]
+	if rc == nil {
+		return p
+	}
+	if configured := rc.configured(kind); configured != nil {
+		if configured.InitialDelay > 0 {
+			p.InitialDelay = configured.InitialDelay
+		}
+		if configured.MaxDelay > 0 {
+			p.MaxDelay = configured.MaxDelay
+		}
+		if configured.MaxFailures > 0 {
+			p.MaxFailures = configured.MaxFailures
+		}
+	}
+	p.MaxDelay = max(p.MaxDelay, p.InitialDelay)
+
+	return p
+}
+
+// delay returns the time to wait after the given number of consecutive
+// failures. It doubles from the initial delay up to the maximum, half of it
+// is random so retries of several instances spread out.
+func (p RetryPolicy) delay(failures int) time.Duration {
+	d := time.Duration(p.InitialDelay) * time.Second
+	maxDelay := time.Duration(p.MaxDelay) * time.Second
+	for i := 1; i < failures && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+
+	//nolint:gosec // jitter does not need a secure random number.
+	return d/2 + rand.N(d/2+1)
+}
+
+// failureKindsOf returns the kinds of failures err consists of. Errors that
+// are not classified count as deploy failures.
+func failureKindsOf(err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	var kinds []string
+	for _, kind := range failureKinds {
+		if errors.Is(err, failureErrors[kind]) {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		kinds = append(kinds, failureDeploy)
+	}
+
+	return kinds
+}
+
+// retryState counts the consecutive failures of the daemon runs per kind.
+type retryState struct {
+	config   *RetryConfig
+	failures map[string]int
+}
+
+func newRetryState(config *RetryConfig) *retryState {
+	return &retryState{config: config, failures: map[string]int{}}
+}
+
+// failed records a failed run and returns the time to wait before the next
+// attempt, the longest of the policies of the failing kinds. giveUp is set
+// once a kind failed as many times in a row as its policy allows.
+func (r *retryState) failed(err error) (delay time.Duration, giveUp bool) {
+	kinds := failureKindsOf(err)
+	for kind := range r.failures {
+		if !slices.Contains(kinds, kind) {
+			delete(r.failures, kind)
+		}
+	}
+
+	for _, kind := range kinds {
+		r.failures[kind]++
+		p := r.config.policy(kind)
+		if r.failures[kind] >= p.MaxFailures {
+			giveUp = true
+		}
+		delay = max(delay, p.delay(r.failures[kind]))
+	}
+
+	return delay, giveUp
+}
+
+// succeeded records a successful run, resetting the failure counts.
+func (r *retryState) succeeded() {
+	clear(r.failures)
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetryConfig_policy(t *testing.T) {
+	t.Parallel()
+
+	var unset *RetryConfig
+	if got := unset.policy(failureConnect); got != defaultRetryPolicies[failureConnect] {
+		t.Errorf("policy() without config = %+v, want the default", got)
+	}
+
+	rc := &RetryConfig{ACME: &RetryPolicy{MaxFailures: 3}, Deploy: &RetryPolicy{InitialDelay: 7200}}
+	if got, want := rc.policy(failureACME), (RetryPolicy{InitialDelay: 300, MaxDelay: 6 * 3600, MaxFailures: 3}); got != want {
+		t.Errorf("policy(acme) = %+v, want %+v", got, want)
+	}
+	if got, want := rc.policy(failureDeploy), (RetryPolicy{InitialDelay: 7200, MaxDelay: 7200, MaxFailures: 10}); got != want {
+		t.Errorf("policy(deploy) = %+v, want %+v", got, want)
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	t.Parallel()
+
+	p := RetryPolicy{InitialDelay: 10, MaxDelay: 60}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{100, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			t.Parallel()
+
+			for range 100 {
+				if got := p.delay(tt.failures); got < tt.want/2 || got > tt.want {
+					t.Fatalf("delay(%d) = %s, want between %s and %s", tt.failures, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_failureKindsOf(t *testing.T) {
+	t.Parallel()
+
+	acme := fmt.Errorf("certificate a: %w: rate limited", errACMEFailure)
+	connect := fmt.Errorf("certificate b: %w: connection refused", errConnectFailure)
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{"none", nil, nil},
+		{"acme", acme, []string{failureACME}},
+		{"joined", errors.Join(connect, acme), []string{failureACME, failureConnect}},
+		{"unclassified", errors.New("boom"), []string{failureDeploy}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := failureKindsOf(tt.err); !slices.Equal(got, tt.want) {
+				t.Errorf("failureKindsOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryState_failed(t *testing.T) {
+	t.Parallel()
+
+	r := newRetryState(&RetryConfig{
+		ACME:    &RetryPolicy{InitialDelay: 100, MaxFailures: 3},
+		Connect: &RetryPolicy{InitialDelay: 1, MaxFailures: 2},
+	})
+	acme := fmt.Errorf("%w: rate limited", errACMEFailure)
+	connect := fmt.Errorf("%w: connection refused", errConnectFailure)
+
+	if delay, giveUp := r.failed(acme); giveUp || delay < 50*time.Second {
+		t.Errorf("failed(acme) = %s, %t, want the acme delay", delay, giveUp)
+	}
+	if delay, giveUp := r.failed(connect); giveUp || delay > time.Second {
+		t.Errorf("failed(connect) = %s, %t, want the connect delay", delay, giveUp)
+	}
+	if r.failures[failureACME] != 0 {
+		t.Errorf("acme failures = %d after a connect failure, want them reset", r.failures[failureACME])
+	}
+	if _, giveUp := r.failed(connect); !giveUp {
+		t.Errorf("failed(connect) did not give up after %d failures", r.failures[failureConnect])
+	}
+
+	r.succeeded()
+	if len(r.failures) != 0 {
+		t.Errorf("failures = %v after success, want none", r.failures)
+	}
+}
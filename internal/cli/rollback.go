@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"go.uber.org/zap"
+)
+
+var (
+	// errNoRollbackCertificate is returned when no certificate to roll the UI
+	// back to is found.
+	errNoRollbackCertificate = errors.New("no certificate to roll back to")
+	// errInvalidRollbackCertificate is returned when the certificate to roll
+	// the UI back to cannot be served.
+	errInvalidRollbackCertificate = errors.New("refusing to roll back to certificate")
+)
+
+func (c cmd) runRollback(ctx context.Context, opts *options) error {
+	config, err := c.config(opts)
+	if err != nil {
+		return err
+	}
+	tnClient, err := c.dial(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	return c.rollback(ctx, tnClient, opts.id)
+}
+
+// rollback makes a previous certificate the UI certificate again. If id is 0,
+// the newest valid certificate imported before the current UI certificate is
+// used, otherwise the certificate with that ID.
+func (c cmd) rollback(ctx context.Context, client *truenas.Client, id int) error {
+	settings, err := client.SystemGeneralConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading system configuration: %w", err)
+	}
+	certs, err := client.Certificates(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing certificates: %w", err)
+	}
+
+	current := 0
+	if settings.UICertificate != nil {
+		current = settings.UICertificate.ID
+	}
+	cert, err := c.rollbackCertificate(certs, current, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if cert.ID == current {
+		c.ScaleLogger.Info("ui certificate already in use", zap.Int("id", cert.ID), zap.String("name", cert.Name))
+		return nil
+	}
+
+	c.ScaleLogger.Info("rolling back ui certificate", zap.Int("from", current), zap.Int("to", cert.ID), zap.String("name", cert.Name))
+	if err := client.SystemGeneralUpdate(ctx, truenas.SystemGeneralUpdateParams{UICertificate: &cert.ID}); err != nil {
+		return fmt.Errorf("error setting ui certificate to %q: %w", cert.Name, err)
+	}
+	c.ScaleLogger.Info("ui certificate rolled back", zap.Int("id", cert.ID), zap.String("name", cert.Name))
+
+	return nil
+}
+
+// rollbackCertificate picks the certificate to roll back to from certs. If id
+// is 0, it is the newest valid certificate imported by this tool before the
+// current UI certificate, leaving out staging certificates.
+func (c cmd) rollbackCertificate(certs []truenas.Certificate, current, id int, now time.Time) (*truenas.Certificate, error) {
+	if id != 0 {
+		i := slices.IndexFunc(certs, func(cert truenas.Certificate) bool { return cert.ID == id })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: certificate %d does not exist", errNoRollbackCertificate, id)
+		}
+		if err := validRollbackCertificate(&certs[i], now); err != nil {
+			return nil, err
+		}
+		return &certs[i], nil
+	}
+
+	// a previous certificate is only known if the current one was imported by us
+	var currentName string
+	for _, cert := range certs {
+		if cert.ID == current {
+			currentName = cert.Name
+		}
+	}
+	previousOnly := isACMECertificate(currentName)
+
+	candidates := slices.DeleteFunc(slices.Clone(certs), func(cert truenas.Certificate) bool {
+		return !isACMECertificate(cert.Name) || strings.HasPrefix(cert.Name, "acme-staging-") ||
+			cert.ID == current || (previousOnly && cert.ID > current)
+	})
+	// IDs are assigned in import order
+	slices.SortFunc(candidates, func(a, b truenas.Certificate) int { return cmp.Compare(b.ID, a.ID) })
+	for i := range candidates {
+		if err := validRollbackCertificate(&candidates[i], now); err != nil {
+			c.ScaleLogger.Info("skipping certificate", zap.Int("id", candidates[i].ID), zap.Error(err))
+			continue
+		}
+		return &candidates[i], nil
+	}
+
+	return nil, fmt.Errorf("%w: no valid certificate imported before %d", errNoRollbackCertificate, current)
+}
+
+// isACMECertificate reports whether name is the name of a certificate
+// imported by this tool.
+func isACMECertificate(name string) bool {
+	return strings.HasPrefix(name, "acme-")
+}
+
+// validRollbackCertificate checks that cert is valid at now and its private
+// key matches.
+func validRollbackCertificate(cert *truenas.Certificate, now time.Time) error {
+	tlsCert, err := cert.TLSCertificate()
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", errInvalidRollbackCertificate, cert.Name, err)
+	}
+	if now.After(tlsCert.Leaf.NotAfter) {
+		return fmt.Errorf("%w %q: expired at %s", errInvalidRollbackCertificate, cert.Name, tlsCert.Leaf.NotAfter)
+	}
+	if now.Before(tlsCert.Leaf.NotBefore) {
+		return fmt.Errorf("%w %q: not valid before %s", errInvalidRollbackCertificate, cert.Name, tlsCert.Leaf.NotBefore)
+	}
+
+	return nil
+}
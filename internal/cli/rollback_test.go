@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+// testCertificate describes a self-signed certificate added to TrueNAS.
+type testCertificate struct {
+	name    string
+	expired bool
+	// otherKey stores the certificate with a private key not matching it.
+	otherKey bool
+}
+
+// addTestCertificate adds a self-signed certificate for nas.example.com to
+// srv and returns its ID.
+func addTestCertificate(t *testing.T, srv *truenastest.Server, tc testCertificate) int {
+	t.Helper()
+
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		return key
+	}
+	key := newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nas.example.com"},
+		DNSNames:     []string{"nas.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if tc.expired {
+		tmpl.NotBefore = time.Now().Add(-48 * time.Hour)
+		tmpl.NotAfter = time.Now().Add(-24 * time.Hour)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if tc.otherKey {
+		key = newKey()
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	id, err := srv.AddCertificate(tc.name,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	)
+	if err != nil {
+		t.Fatalf("AddCertificate() error = %v", err)
+	}
+
+	return id
+}
+
+func Test_rollback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		certs []testCertificate
+		// current is the index of the UI certificate in certs.
+		current int
+		// id is the index of the certificate in certs passed as ID, -1 for none.
+		id int
+		// want is the index of the UI certificate after the rollback.
+		want    int
+		wantErr error
+	}{
+		{
+			name:    "previous",
+			certs:   []testCertificate{{name: "acme-1"}, {name: "acme-2"}, {name: "acme-3"}},
+			current: 2,
+			id:      -1,
+			want:    1,
+		},
+		{
+			name: "skips invalid",
+			certs: []testCertificate{
+				{name: "acme-1"},
+				{name: "acme-2", expired: true},
+				{name: "acme-3", otherKey: true},
+				{name: "acme-staging-4"},
+				{name: "custom"},
+				{name: "acme-6"},
+			},
+			current: 5,
+			id:      -1,
+			want:    0,
+		},
+		{
+			name:    "not before current",
+			certs:   []testCertificate{{name: "acme-1"}, {name: "acme-2"}},
+			current: 0,
+			id:      -1,
+			want:    0,
+			wantErr: errNoRollbackCertificate,
+		},
+		{
+			name:    "current not imported",
+			certs:   []testCertificate{{name: "freenas_default"}, {name: "acme-2"}},
+			current: 0,
+			id:      -1,
+			want:    1,
+		},
+		{
+			name:    "id",
+			certs:   []testCertificate{{name: "custom"}, {name: "acme-2"}, {name: "acme-3"}},
+			current: 2,
+			id:      0,
+			want:    0,
+		},
+		{
+			name:    "id expired",
+			certs:   []testCertificate{{name: "acme-1", expired: true}, {name: "acme-2"}},
+			current: 1,
+			id:      0,
+			want:    1,
+			wantErr: errInvalidRollbackCertificate,
+		},
+		{
+			name:    "id with mismatched key",
+			certs:   []testCertificate{{name: "acme-1", otherKey: true}, {name: "acme-2"}},
+			current: 1,
+			id:      0,
+			want:    1,
+			wantErr: errInvalidRollbackCertificate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := truenastest.NewServer()
+			t.Cleanup(srv.Close)
+			client, err := truenas.Dial(t.Context(), truenastest.DefaultAPIKey, truenas.WithURL(srv.URL))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			t.Cleanup(client.Close)
+
+			ids := make([]int, 0, len(tt.certs))
+			for _, tc := range tt.certs {
+				ids = append(ids, addTestCertificate(t, srv, tc))
+			}
+			srv.SetUICertificate(ids[tt.current])
+			id := 0
+			if tt.id >= 0 {
+				id = ids[tt.id]
+			}
+
+			err = newTestCmd().rollback(t.Context(), client, id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("rollback() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := srv.UICertificate(); got != ids[tt.want] {
+				t.Errorf("ui certificate = %d, want %d", got, ids[tt.want])
+			}
+			wantCheckins := 0
+			if tt.want != tt.current {
+				wantCheckins = 1
+			}
+			if got := srv.Checkins(); got != wantCheckins {
+				t.Errorf("checkins = %d, want %d", got, wantCheckins)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// daemonState is the state of the daemon shared with its HTTP server.
+type daemonState struct {
+	mu     sync.Mutex
+	status daemonStatus
+	// connected is set while the daemon holds an authenticated connection
+	// to TrueNAS.
+	connected bool
+}
+
+// set records the status after a run of the daemon.
+func (s *daemonState) set(status *daemonStatus, connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = *status
+	s.status.Failures = maps.Clone(status.Failures)
+	s.connected = connected
+}
+
+// get returns the status of the last run of the daemon.
+func (s *daemonState) get() (daemonStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status, s.connected
+}
+
+// ready reports whether the last run succeeded and TrueNAS is connected, the
+// reason why not otherwise.
+func (s *daemonState) ready() (bool, string) {
+	status, connected := s.get()
+	switch {
+	case status.LastRun.IsZero():
+		return false, "first run not finished"
+	case status.LastError != "":
+		return false, "last run failed: " + status.LastError
+	case !connected:
+		return false, "not connected to TrueNAS"
+	}
+
+	return true, "ready"
+}
+
+// certificateStatus is a managed certificate as reported by /status.
+type certificateStatus struct {
+	Certificate string   `json:"certificate"`
+	Domains     []string `json:"domains"`
+	// Serial and NotAfter describe the certificate in storage, if any.
+	Serial   string    `json:"serial,omitempty"`
+	NotAfter time.Time `json:"not_after,omitzero"`
+	RenewAt  time.Time `json:"renew_at,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// statusResponse is the body of /status.
+type statusResponse struct {
+	daemonStatus
+	Ready        bool                `json:"ready"`
+	Certificates []certificateStatus `json:"certificates"`
+}
+
+// serverReadHeaderTimeout bounds reading the request headers.
+const serverReadHeaderTimeout = 10 * time.Second
+
+// serve serves the health, readiness, status and metrics endpoints of the
+// daemon on addr. The returned server must be closed.
+func (c cmd) serve(ctx context.Context, addr string, state *daemonState, certs []managedCertificate) (*http.Server, error) {
+	ln, err := (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{
+		Handler:           c.handler(state, certs),
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			c.CLILogger.Error("error serving http", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+	c.CLILogger.Info("serving http", zap.Stringer("addr", ln.Addr()))
+
+	return srv, nil
+}
+
+// handler returns the handler of the endpoints of the daemon:
+//
+//   - /healthz responds as long as the daemon is alive.
+//   - /readyz responds with 503 unless the last run succeeded and the daemon
+//     is connected to TrueNAS.
+//   - /status responds with the state of the daemon and the certificates as
+//     JSON.
+//   - /metrics responds with the Prometheus metrics, if they are recorded.
+func (c cmd) handler(state *daemonState, certs []managedCertificate) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		ready, reason := state.ready()
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, _ := state.get()
+		ready, _ := state.ready()
+		resp := statusResponse{daemonStatus: status, Ready: ready, Certificates: []certificateStatus{}}
+		for _, cert := range certs {
+			resp.Certificates = append(resp.Certificates, certificateStatusOf(r.Context(), cert))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(resp); err != nil {
+			c.CLILogger.Warn("error writing status", zap.Error(err))
+		}
+	})
+
+	if c.metrics != nil {
+		mux.Handle("GET /metrics", c.metrics.handler())
+	}
+
+	return mux
+}
+
+// certificateStatusOf describes cert by the certificate in storage, without
+// contacting the CA.
+func certificateStatusOf(ctx context.Context, cert managedCertificate) certificateStatus {
+	cs := certificateStatus{Certificate: cert.String(), Domains: cert.Domains}
+
+	stored, issuer, err := loadCertificate(ctx, cert.acmeClient, cert.Domains)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return cs
+	case err != nil:
+		cs.Error = err.Error()
+		return cs
+	}
+
+	ri := storedRenewalInfo(ctx, cert.acmeClient, issuer, stored)
+	cs.Serial = stored.Leaf.SerialNumber.Text(16)
+	cs.NotAfter, cs.RenewAt = ri.NotAfter, ri.RenewAt
+
+	return cs
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_handler(t *testing.T) {
+	t.Parallel()
+
+	obtained := managedCertificate{
+		CertificateConfig: &CertificateConfig{Domains: []string{"nas.example.com"}},
+		acmeClient:        newTestMagic(t, newTestIssuer(t)),
+	}
+	if _, err := newTestCmd().obtainCertificate(t.Context(), obtained.acmeClient, obtained.Domains); err != nil {
+		t.Fatalf("obtainCertificate() error = %v", err)
+	}
+	missing := managedCertificate{
+		CertificateConfig: &CertificateConfig{Domains: []string{"s3.example.com"}},
+		acmeClient:        newTestMagic(t, newTestIssuer(t)),
+	}
+
+	state := &daemonState{}
+	srv := httptest.NewServer(newTestCmd().handler(state, []managedCertificate{obtained, missing}))
+	t.Cleanup(srv.Close)
+
+	get := func(t *testing.T, path string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+path, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		status     daemonStatus
+		connected  bool
+		wantHealth int
+		wantReady  int
+	}{
+		{"starting", daemonStatus{}, false, http.StatusOK, http.StatusServiceUnavailable},
+		{"failed", daemonStatus{LastRun: now, LastError: "boom"}, true, http.StatusOK, http.StatusServiceUnavailable},
+		{"disconnected", daemonStatus{LastRun: now, LastSuccess: now}, false, http.StatusOK, http.StatusServiceUnavailable},
+		{"ready", daemonStatus{LastRun: now, LastSuccess: now, UICertificateID: 3}, true, http.StatusOK, http.StatusOK},
+	}
+	// the cases share the daemon state, so they run in order
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state.set(&tt.status, tt.connected)
+
+			if got := get(t, "/healthz").StatusCode; got != tt.wantHealth {
+				t.Errorf("/healthz status = %d, want %d", got, tt.wantHealth)
+			}
+			if got := get(t, "/readyz").StatusCode; got != tt.wantReady {
+				t.Errorf("/readyz status = %d, want %d", got, tt.wantReady)
+			}
+		})
+	}
+
+	var status statusResponse
+	if err := json.NewDecoder(get(t, "/status").Body).Decode(&status); err != nil {
+		t.Fatalf("decoding /status: %v", err)
+	}
+	if !status.Ready || status.UICertificateID != 3 || len(status.Certificates) != 2 {
+		t.Fatalf("/status = %+v, want ready with UI certificate 3 and 2 certificates", status)
+	}
+	if got := status.Certificates[0]; got.Certificate != "nas.example.com" || got.Serial == "" || got.NotAfter.IsZero() || got.RenewAt.IsZero() {
+		t.Errorf("/status certificate = %+v, want the stored certificate", got)
+	}
+	if got := status.Certificates[1]; got.Serial != "" || got.Error != "" {
+		t.Errorf("/status certificate = %+v, want it not obtained", got)
+	}
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas"
+)
+
+// status prints the UI certificate and, for every configured certificate, the
+// certificate in ACME storage and when it is renewed. The outcome of the last
+// daemon run is shown if the daemon ran. The CA is not contacted.
+func (c cmd) status(ctx context.Context, opts *options) error {
+	config, tnClient, certs, err := c.setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	settings, err := tnClient.SystemGeneralConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading system configuration: %w", err)
+	}
+
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	if ui := settings.UICertificate; ui == nil {
+		fmt.Fprintln(w, "UI certificate:\tnone")
+	} else if leaf, err := ui.Leaf(); err != nil {
+		fmt.Fprintf(w, "UI certificate:\t%s (ID %d), %v\n", ui.Name, ui.ID, err)
+	} else {
+		fmt.Fprintf(w, "UI certificate:\t%s (ID %d), serial %s, expires %s\n",
+			ui.Name, ui.ID, leaf.SerialNumber.Text(16), formatTime(leaf.NotAfter))
+	}
+	if err := c.printDaemonStatus(w, config); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "CERTIFICATE\tSERIAL\tEXPIRES\tRENEW AT\tTARGETS")
+	for _, cert := range certs {
+		var targets []string
+		for _, target := range cert.Targets {
+			targets = append(targets, target.consumers()...)
+		}
+
+		stored, issuer, err := loadCertificate(ctx, cert.acmeClient, cert.Domains)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			fmt.Fprintf(w, "%s\t-\tnot obtained\t-\t%s\n", cert, strings.Join(targets, ", "))
+		case err != nil:
+			return err
+		default:
+			ri := storedRenewalInfo(ctx, cert.acmeClient, issuer, stored)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", cert, stored.Leaf.SerialNumber.Text(16),
+				formatTime(ri.NotAfter), formatTime(ri.RenewAt), strings.Join(targets, ", "))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing status: %w", err)
+	}
+
+	return nil
+}
+
+// list prints the certificates in TrueNAS and whether they are in use.
+func (c cmd) list(ctx context.Context, opts *options) error {
+	config, err := c.config(opts)
+	if err != nil {
+		return err
+	}
+	tnClient, err := c.dial(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer tnClient.Close()
+
+	settings, err := tnClient.SystemGeneralConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading system configuration: %w", err)
+	}
+	certs, err := tnClient.Certificates(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing certificates: %w", err)
+	}
+	inUse, err := certificatesInUse(ctx, tnClient, config.targets())
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(certs, func(a, b truenas.Certificate) int { return a.ID - b.ID })
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tNAMES\tEXPIRES\tSTATUS")
+	for _, cert := range certs {
+		var status []string
+		switch {
+		case settings.UICertificate != nil && settings.UICertificate.ID == cert.ID:
+			status = append(status, "ui")
+		case inUse[cert.ID]:
+			status = append(status, "in use")
+		}
+		if cert.Expired {
+			status = append(status, "expired")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", cert.ID, cert.Name, certificateNames(cert),
+			formatTime(cert.Until.Time), strings.Join(status, ", "))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing certificate list: %w", err)
+	}
+
+	return nil
+}
+
+// certificateNames returns the DNS names of cert for display, its common name
+// if it has none.
+func certificateNames(cert truenas.Certificate) string {
+	if names := cert.DNSNames(); len(names) > 0 {
+		return strings.Join(names, ",")
+	}
+
+	return cert.Common
+}
+
+// formatTime formats t for display, "-" if it is not set.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+)
+
+// validate checks the configuration, the credentials of the DNS providers and
+// the TrueNAS API key, reporting each check.
+func (c cmd) validate(ctx context.Context, opts *options) error {
+	config, err := c.config(opts)
+	if err != nil {
+		return err
+	}
+	certs, err := c.managedCertificates(config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "config %s: ok, %d certificates\n", opts.configPath, len(certs))
+
+	var errs []error
+	for _, cert := range certs {
+		provider, err := cert.acme.DNSProvider()
+		if err != nil {
+			return fmt.Errorf("dns provider could not be loaded: %w", err)
+		}
+		zones, err := c.checkDNSProvider(ctx, provider, cert.acme.Resolvers, cert.Domains)
+		switch {
+		case err != nil:
+			fmt.Fprintf(c.Out, "certificate %s: dns provider: %v\n", cert, err)
+			errs = append(errs, fmt.Errorf("certificate %s: %w", cert, err))
+		case len(zones) == 0:
+			fmt.Fprintf(c.Out, "certificate %s: dns provider: not checked, it cannot list records\n", cert)
+		default:
+			fmt.Fprintf(c.Out, "certificate %s: dns provider: ok, zones %s\n", cert, strings.Join(zones, ", "))
+		}
+	}
+
+	tnClient, err := c.dial(ctx, config)
+	if err != nil {
+		fmt.Fprintf(c.Out, "truenas %s: %v\n", config.API.URL, err)
+		errs = append(errs, err)
+	} else {
+		tnClient.Close()
+		fmt.Fprintf(c.Out, "truenas %s: ok\n", config.API.URL)
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkDNSProvider checks that provider can read the zones of the challenge
+// records of domains, which proves its credentials without changing a record.
+// It returns the zones checked, none if the provider cannot list records.
+func (c cmd) checkDNSProvider(ctx context.Context, provider certmagic.DNSProvider, resolvers, domains []string) ([]string, error) {
+	getter, ok := provider.(libdns.RecordGetter)
+	if !ok {
+		return nil, nil
+	}
+
+	var zones []string
+	for _, domain := range domains {
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+		zone, err := certmagic.FindZoneByFQDN(ctx, c.CertLogger, fqdn, certmagic.RecursiveNameservers(resolvers))
+		if err != nil {
+			return zones, fmt.Errorf("finding zone of %s: %w", fqdn, err)
+		}
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		if _, err := getter.GetRecords(ctx, zone); err != nil {
+			return zones, fmt.Errorf("listing records of zone %s: %w", zone, err)
+		}
+		zones = append(zones, zone)
+	}
+
+	return zones, nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/thde/truenas-scale-acme/internal/acmetest"
+	"github.com/thde/truenas-scale-acme/internal/execdns"
+)
+
+// recordGetter is a DNS provider that can list records, failing with err.
+type recordGetter struct {
+	execdns.Provider
+	err   error
+	zones []string
+}
+
+func (p *recordGetter) GetRecords(_ context.Context, zone string) ([]libdns.Record, error) {
+	p.zones = append(p.zones, zone)
+	return nil, p.err
+}
+
+// Test_checkDNSProvider is not parallel, as acmetest sets the environment.
+func Test_checkDNSProvider(t *testing.T) {
+	srv := acmetest.NewServer(t, "example.com")
+	domains := []string{"nas.example.com", "*.nas.example.com"}
+	errDenied := errors.New("access denied")
+
+	t.Run("ok", func(t *testing.T) {
+		provider := &recordGetter{}
+		zones, err := newTestCmd().checkDNSProvider(t.Context(), provider, []string{srv.DNSAddr}, domains)
+		if err != nil {
+			t.Fatalf("checkDNSProvider() error = %v", err)
+		}
+		if want := []string{"example.com."}; !slices.Equal(zones, want) || !slices.Equal(provider.zones, want) {
+			t.Errorf("checkDNSProvider() zones = %v, listed %v, want %v", zones, provider.zones, want)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		provider := &recordGetter{err: errDenied}
+		_, err := newTestCmd().checkDNSProvider(t.Context(), provider, []string{srv.DNSAddr}, domains)
+		if !errors.Is(err, errDenied) {
+			t.Errorf("checkDNSProvider() error = %v, want %v", err, errDenied)
+		}
+	})
+
+	t.Run("cannot list records", func(t *testing.T) {
+		zones, err := newTestCmd().checkDNSProvider(t.Context(), &execdns.Provider{}, []string{srv.DNSAddr}, domains)
+		if err != nil || len(zones) != 0 {
+			t.Errorf("checkDNSProvider() = %v, %v, want nothing checked", zones, err)
+		}
+	})
+}
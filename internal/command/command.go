@@ -0,0 +1,73 @@
+// Package command runs the external commands of the hooks and the exec DNS
+// provider and logs their output.
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// waitDelay is how long output is still read after the command was killed.
+const waitDelay = 5 * time.Second
+
+// Run runs the program args[0] with the remaining args until it exits or ctx
+// is done. stdin is optional, env is added to the environment of the process.
+// If logger is set, stdout is logged at debug level and stderr with the
+// outcome. The error of a non-zero exit holds the exit code and stderr, that
+// of a command stopped by ctx is the error of ctx.
+func Run(ctx context.Context, args []string, stdin io.Reader, env []string, logger *zap.Logger) error {
+	//nolint:gosec // running the configured command is the purpose of the callers.
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.WaitDelay = waitDelay
+	cmd.Stdin = stdin
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	log(logger, time.Since(start), &stdout, &stderr, err)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("exit code %d: %s", exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func log(logger *zap.Logger, took time.Duration, stdout, stderr *bytes.Buffer, err error) {
+	if logger == nil {
+		return
+	}
+
+	logger = logger.With(zap.Duration("took", took))
+	if out := strings.TrimSpace(stdout.String()); out != "" {
+		logger.Debug("command output", zap.String("stdout", out))
+	}
+	if err != nil {
+		logger.Warn("command failed", zap.String("stderr", strings.TrimSpace(stderr.String())), zap.Error(err))
+		return
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		logger.Info("command succeeded", zap.String("stderr", msg))
+	}
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "command.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zap.DebugLevel)
+	script := writeScript(t, `read -r line; echo "$line $1 $EXTRA"; echo warning >&2`)
+	err := Run(t.Context(), []string{script, "arg"}, strings.NewReader("stdin\n"), []string{"EXTRA=env"}, zap.New(core))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	output := logs.FilterMessage("command output").All()
+	if len(output) != 1 || output[0].ContextMap()["stdout"] != "stdin arg env" {
+		t.Errorf("output logs = %+v, want stdout of the command", output)
+	}
+	succeeded := logs.FilterMessage("command succeeded").All()
+	if len(succeeded) != 1 || succeeded[0].ContextMap()["stderr"] != "warning" {
+		t.Errorf("success logs = %+v, want stderr of the command", succeeded)
+	}
+}
+
+func TestRun_failure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{"exit code", []string{writeScript(t, "echo 'reload failed' >&2\nexit 3")}, "exit code 3: reload failed", nil},
+		{"timeout", []string{writeScript(t, "exec sleep 10")}, "deadline exceeded", context.DeadlineExceeded},
+		{"not found", []string{filepath.Join(t.TempDir(), "missing")}, "no such file", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+			defer cancel()
+			start := time.Now()
+			err := Run(ctx, tt.args, nil, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Run() error = %v, want it to contain %q", err, tt.want)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Errorf("Run() took %s, want the timeout to stop it", took)
+			}
+		})
+	}
+}
@@ -74,10 +74,9 @@ func NewTickerWithLocation(schedule string, loc *time.Location) (Ticker, error)
 // newTicker prepares the channels, parses the schedule, and kicks off
 // the goroutine that handles scheduling of each 'tick'.
 func newTicker(schedule string, loc *time.Location, c chan time.Time, k <-chan bool) error {
-	scheduleWithTZ := fmt.Sprintf("TZ=%s %s", loc.String(), schedule)
-	cronSchedule, err := scheduleParser.Parse(scheduleWithTZ)
+	cronSchedule, err := parseSchedule(schedule, loc)
 	if err != nil {
-		return fmt.Errorf("parsing cron schedule %q: %w", scheduleWithTZ, err)
+		return err
 	}
 
 	go cronRunner(cronSchedule, loc, c, k)
@@ -85,6 +84,28 @@ func newTicker(schedule string, loc *time.Location, c chan time.Time, k <-chan b
 	return nil
 }
 
+// Next returns the first time after t the schedule fires in the time zone
+// loc, the time the next 'tick' of a Ticker with the same schedule is sent.
+func Next(schedule string, loc *time.Location, t time.Time) (time.Time, error) {
+	cronSchedule, err := parseSchedule(schedule, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cronSchedule.Next(t.In(loc)), nil
+}
+
+// parseSchedule parses schedule in the time zone loc.
+func parseSchedule(schedule string, loc *time.Location) (cron.Schedule, error) {
+	scheduleWithTZ := fmt.Sprintf("TZ=%s %s", loc.String(), schedule)
+	cronSchedule, err := scheduleParser.Parse(scheduleWithTZ)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cron schedule %q: %w", scheduleWithTZ, err)
+	}
+
+	return cronSchedule, nil
+}
+
 // cronRunner handles calculating the next 'tick'. It communicates to
 // the Ticker via a channel and will stop/return whenever it receives
 // a bool on the `k` channel.
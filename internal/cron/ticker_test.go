@@ -56,6 +56,23 @@ func TestNewTicker_Error(t *testing.T) {
 	}
 }
 
+func TestNext(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2025, 10, 16, 10, 10, 10, 0, time.UTC)
+	next, err := Next("0 3 * * *", time.UTC, from)
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if want := time.Date(2025, 10, 17, 3, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next() = %s, want %s", next, want)
+	}
+
+	if _, err := Next("NOT_VALID_SCHEDULE", time.UTC, from); err == nil {
+		t.Error("Next() of an invalid schedule succeeded")
+	}
+}
+
 func TestCronRunner_MultipleTicks(t *testing.T) {
 	t.Parallel()
 
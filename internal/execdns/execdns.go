@@ -0,0 +1,128 @@
+// Package execdns implements a libdns provider that hands every record change
+// to an external command, so existing scripts (e.g. acme.sh dns_* hooks
+// behind a small wrapper) can solve DNS-01 challenges.
+//
+// The command is run as
+//
+//	<command...> present|cleanup <fqdn> <value>
+//
+// which is the calling convention of lego's exec provider. The same values,
+// plus the zone, are also passed as ACME_ACTION, ACME_FQDN, ACME_VALUE,
+// ACME_ZONE, ACME_NAME (relative to the zone) and ACME_TTL environment
+// variables. A non-zero exit code fails the change.
+package execdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/thde/truenas-scale-acme/internal/command"
+	"go.uber.org/zap"
+)
+
+// Actions passed to the command.
+const (
+	ActionPresent = "present"
+	ActionCleanup = "cleanup"
+)
+
+// DefaultTimeout bounds a run of the command if no timeout is configured.
+const DefaultTimeout = 2 * time.Minute
+
+var (
+	// ErrNoCommand is returned when the provider has no command configured.
+	ErrNoCommand = errors.New("no command configured")
+	// ErrUnsupportedRecord is returned for records other than TXT.
+	ErrUnsupportedRecord = errors.New("only TXT records are supported")
+	// ErrCommandFailed is returned when the command exits unsuccessfully.
+	ErrCommandFailed = errors.New("command failed")
+)
+
+// Provider runs Command for every record that is added or removed.
+type Provider struct {
+	// Command is the program followed by its leading arguments.
+	Command []string `json:"command"`
+	// Timeout is the maximum run time of the command in seconds. It defaults
+	// to [DefaultTimeout].
+	Timeout int `json:"timeout,omitempty"`
+	// Env holds additional environment variables for the command.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Logger receives the output of the command. It is optional.
+	Logger *zap.Logger `json:"-"`
+}
+
+// AppendRecords runs the command with [ActionPresent] for every record.
+func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return p.runAll(ctx, ActionPresent, zone, recs)
+}
+
+// DeleteRecords runs the command with [ActionCleanup] for every record.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return p.runAll(ctx, ActionCleanup, zone, recs)
+}
+
+func (p *Provider) runAll(ctx context.Context, action, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	done := make([]libdns.Record, 0, len(recs))
+	for _, rec := range recs {
+		if err := p.run(ctx, action, zone, rec.RR()); err != nil {
+			return done, err
+		}
+		done = append(done, rec)
+	}
+
+	return done, nil
+}
+
+// run executes the command for a single record.
+func (p *Provider) run(ctx context.Context, action, zone string, rr libdns.RR) error {
+	if len(p.Command) == 0 {
+		return ErrNoCommand
+	}
+	if rr.Type != "TXT" {
+		return fmt.Errorf("%w: %s %s", ErrUnsupportedRecord, rr.Type, rr.Name)
+	}
+
+	timeout := DefaultTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fqdn := libdns.AbsoluteName(rr.Name, zone)
+	args := append(slices.Clone(p.Command), action, fqdn, rr.Data)
+
+	env := []string{
+		"ACME_ACTION=" + action,
+		"ACME_ZONE=" + zone,
+		"ACME_FQDN=" + fqdn,
+		"ACME_NAME=" + rr.Name,
+		"ACME_VALUE=" + rr.Data,
+		"ACME_TTL=" + strconv.Itoa(int(rr.TTL.Seconds())),
+	}
+	for k, v := range p.Env {
+		env = append(env, k+"="+v)
+	}
+
+	var logger *zap.Logger
+	if p.Logger != nil {
+		logger = p.Logger.With(zap.String("action", action), zap.String("fqdn", fqdn))
+	}
+	if err := command.Run(ctx, args, nil, env, logger); err != nil {
+		return fmt.Errorf("%w: %s %s: %w", ErrCommandFailed, action, fqdn, err)
+	}
+
+	return nil
+}
+
+// Interface guards.
+var (
+	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordDeleter  = (*Provider)(nil)
+)
@@ -0,0 +1,92 @@
+package execdns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	return path
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "calls")
+	script := writeScript(t, `echo "$1 $2 $3 $4 $ACME_ACTION $ACME_ZONE $ACME_NAME $ACME_TTL $EXTRA" >> "$OUT"`)
+	p := &Provider{Command: []string{script, "lead"}, Env: map[string]string{"OUT": out, "EXTRA": "x"}}
+	recs := []libdns.Record{libdns.TXT{Name: "_acme-challenge.nas", TTL: time.Minute, Text: "token"}}
+
+	if _, err := p.AppendRecords(t.Context(), "example.com.", recs); err != nil {
+		t.Fatalf("AppendRecords: %v", err)
+	}
+	if _, err := p.DeleteRecords(t.Context(), "example.com.", recs); err != nil {
+		t.Fatalf("DeleteRecords: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read calls: %v", err)
+	}
+	want := "lead present _acme-challenge.nas.example.com. token present example.com. _acme-challenge.nas 60 x\n" +
+		"lead cleanup _acme-challenge.nas.example.com. token cleanup example.com. _acme-challenge.nas 60 x\n"
+	if string(got) != want {
+		t.Errorf("calls =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProvider_exitCode(t *testing.T) {
+	t.Parallel()
+
+	p := &Provider{Command: []string{writeScript(t, "echo 'zone not found' >&2\nexit 3")}}
+	_, err := p.AppendRecords(t.Context(), "example.com.", []libdns.Record{libdns.TXT{Name: "x", Text: "y"}})
+	if !errors.Is(err, ErrCommandFailed) {
+		t.Fatalf("AppendRecords error = %v, want %v", err, ErrCommandFailed)
+	}
+	for _, want := range []string{"exit code 3", "zone not found"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("AppendRecords error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestProvider_timeout(t *testing.T) {
+	t.Parallel()
+
+	p := &Provider{Command: []string{writeScript(t, "exec sleep 10")}, Timeout: 1}
+	start := time.Now()
+	_, err := p.AppendRecords(t.Context(), "example.com.", []libdns.Record{libdns.TXT{Name: "x", Text: "y"}})
+	if !errors.Is(err, ErrCommandFailed) {
+		t.Fatalf("AppendRecords error = %v, want %v", err, ErrCommandFailed)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("AppendRecords took %s, want the timeout to stop it", took)
+	}
+}
+
+func TestProvider_unsupported(t *testing.T) {
+	t.Parallel()
+
+	p := &Provider{Command: []string{"true"}}
+	_, err := p.AppendRecords(t.Context(), "example.com.", []libdns.Record{libdns.Address{Name: "x"}})
+	if !errors.Is(err, ErrUnsupportedRecord) {
+		t.Errorf("AppendRecords error = %v, want %v", err, ErrUnsupportedRecord)
+	}
+
+	_, err = (&Provider{}).AppendRecords(t.Context(), "example.com.", []libdns.Record{libdns.TXT{Name: "x"}})
+	if !errors.Is(err, ErrNoCommand) {
+		t.Errorf("AppendRecords error = %v, want %v", err, ErrNoCommand)
+	}
+}
@@ -0,0 +1,158 @@
+// Package hook runs commands and webhooks after a certificate was deployed,
+// e.g. to reload a reverse proxy using the certificate files.
+//
+// A command gets the [Event] as JSON on stdin, a webhook as the body of a POST
+// request. A non-zero exit code or a response status other than 2xx fails the
+// hook.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/command"
+	"go.uber.org/zap"
+)
+
+// DefaultTimeout bounds a run of the hook if no timeout is configured.
+const DefaultTimeout = 30 * time.Second
+
+var (
+	// ErrInvalidHook is returned when a hook has none or both of a command
+	// and a URL.
+	ErrInvalidHook = errors.New("exactly one of command and url is required")
+	// ErrHookFailed is returned when the command or webhook fails.
+	ErrHookFailed = errors.New("hook failed")
+)
+
+// Event describes a certificate deployed to a target.
+type Event struct {
+	// Certificate is the name of the certificate definition.
+	Certificate string `json:"certificate"`
+	// Domain is the first of the Domains.
+	Domain  string   `json:"domain"`
+	Domains []string `json:"domains"`
+	// Target is the type of the target the certificate was deployed to.
+	Target string `json:"target"`
+	// TrueNASCertificateID and TrueNASCertificateName identify the
+	// certificate entry in TrueNAS. They are unset for targets outside of
+	// TrueNAS.
+	TrueNASCertificateID   int    `json:"truenas_certificate_id,omitempty"`
+	TrueNASCertificateName string `json:"truenas_certificate_name,omitempty"`
+	// Serial is the hex encoded serial number of the certificate.
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// Hook is a command or a webhook receiving an [Event].
+type Hook struct {
+	// Name identifies the hook in logs. It defaults to the command or URL.
+	Name string `json:"name,omitempty"`
+	// Command is the program followed by its arguments.
+	Command []string `json:"command,omitempty"`
+	// URL is the URL of the webhook.
+	URL string `json:"url,omitempty"`
+	// Headers are additional headers of the webhook request.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout is the maximum run time of the hook in seconds. It defaults to
+	// [DefaultTimeout].
+	Timeout int `json:"timeout,omitempty"`
+	// Logger receives the output of the command. It is optional.
+	Logger *zap.Logger `json:"-"`
+	// Client sends the webhook request. It defaults to [http.DefaultClient].
+	Client *http.Client `json:"-"`
+}
+
+// String returns the name of the hook.
+func (h *Hook) String() string {
+	switch {
+	case h.Name != "":
+		return h.Name
+	case len(h.Command) > 0:
+		return h.Command[0]
+	default:
+		return h.URL
+	}
+}
+
+// Valid checks the hook has either a command or a valid URL.
+func (h *Hook) Valid() error {
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return ErrInvalidHook
+	}
+	if h.URL != "" && !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return fmt.Errorf("%w: url '%s' is not an http(s) URL", ErrInvalidHook, h.URL)
+	}
+
+	return nil
+}
+
+// Run runs the hook with event, bounded by its timeout.
+func (h *Hook) Run(ctx context.Context, event Event) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	timeout := DefaultTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(h.Command) > 0 {
+		return h.runCommand(ctx, payload)
+	}
+	return h.post(ctx, payload)
+}
+
+func (h *Hook) runCommand(ctx context.Context, payload []byte) error {
+	var logger *zap.Logger
+	if h.Logger != nil {
+		logger = h.Logger.With(zap.Stringer("hook", h))
+	}
+	if err := command.Run(ctx, h.Command, bytes.NewReader(payload), nil, logger); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrHookFailed, h, err)
+	}
+
+	return nil
+}
+
+func (h *Hook) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrHookFailed, h, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrHookFailed, h, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: status %s: %s", ErrHookFailed, h, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = Event{
+	Certificate:            "nas",
+	Domain:                 "nas.example.com",
+	Domains:                []string{"nas.example.com", "*.nas.example.com"},
+	Target:                 "ui",
+	TrueNASCertificateID:   3,
+	TrueNASCertificateName: "acme-20261016-120000",
+	Serial:                 "0a1b2c",
+	NotAfter:               time.Date(2027, 1, 14, 12, 0, 0, 0, time.UTC),
+}
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	return path
+}
+
+func TestHook_command(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "event.json")
+	h := &Hook{Command: []string{writeScript(t, `cat > "$1"`), out}}
+	if err := h.Run(t.Context(), testEvent); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	var got Event
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("decoding %s: %v", b, err)
+	}
+	if got.TrueNASCertificateID != testEvent.TrueNASCertificateID || got.Serial != testEvent.Serial || !got.NotAfter.Equal(testEvent.NotAfter) {
+		t.Errorf("event = %+v, want %+v", got, testEvent)
+	}
+}
+
+func TestHook_commandFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		hook *Hook
+		want string
+	}{
+		{"exit code", &Hook{Command: []string{writeScript(t, "echo 'reload failed' >&2\nexit 3")}}, "exit code 3: reload failed"},
+		{"timeout", &Hook{Command: []string{writeScript(t, "exec sleep 10")}, Timeout: 1}, "deadline exceeded"},
+		{"not found", &Hook{Command: []string{filepath.Join(t.TempDir(), "missing")}}, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+			err := tt.hook.Run(t.Context(), testEvent)
+			if !errors.Is(err, ErrHookFailed) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Run() error = %v, want %v containing %q", err, ErrHookFailed, tt.want)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Errorf("Run() took %s, want the timeout to stop it", took)
+			}
+		})
+	}
+}
+
+func TestHook_webhook(t *testing.T) {
+	t.Parallel()
+
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	h := &Hook{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := h.Run(t.Context(), testEvent); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got.Certificate != testEvent.Certificate || got.Target != testEvent.Target {
+		t.Errorf("event = %+v, want %+v", got, testEvent)
+	}
+
+	h.Headers = nil
+	err := h.Run(t.Context(), testEvent)
+	if !errors.Is(err, ErrHookFailed) || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Run() error = %v, want %v with status 400", err, ErrHookFailed)
+	}
+}
+
+func TestHook_Valid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{"command", Hook{Command: []string{"/bin/true"}}, false},
+		{"url", Hook{URL: "https://monitoring.example.com/hook"}, false},
+		{"none", Hook{}, true},
+		{"both", Hook{Command: []string{"/bin/true"}, URL: "https://monitoring.example.com/hook"}, true},
+		{"scheme", Hook{URL: "ftp://monitoring.example.com/hook"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.hook.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
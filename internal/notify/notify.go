@@ -0,0 +1,223 @@
+// Package notify sends events about certificates to notification services,
+// ntfy, Gotify and Slack, or as JSON to any webhook.
+//
+// Every [Sink] renders the title and message of an event from its own
+// templates, only sends events of at least its severity and can be rate
+// limited.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Event types.
+const (
+	// EventIssued is sent when a certificate was issued for the first time.
+	EventIssued = "issued"
+	// EventRenewed is sent when a certificate was renewed.
+	EventRenewed = "renewed"
+	// EventDeployed is sent when a certificate was deployed to a target.
+	EventDeployed = "deployed"
+	// EventUISwitched is sent when the TrueNAS UI was switched to a
+	// certificate.
+	EventUISwitched = "ui_switched"
+	// EventPruned is sent when an expired certificate was removed.
+	EventPruned = "pruned"
+	// EventFailed is sent when a certificate could not be ensured.
+	EventFailed = "failed"
+	// EventExpiringSoon is sent when a certificate could not be renewed and
+	// expires soon.
+	EventExpiringSoon = "expiring_soon"
+)
+
+// EventTypes are all event types.
+var EventTypes = []string{EventIssued, EventRenewed, EventDeployed, EventUISwitched, EventPruned, EventFailed, EventExpiringSoon}
+
+// Severity is the importance of an event.
+type Severity int
+
+// Severities in ascending order.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// severities are the names of the severities.
+var severities = []string{"info", "warning", "error"}
+
+// ErrInvalidSeverity is returned for an unknown severity name.
+var ErrInvalidSeverity = errors.New("invalid severity")
+
+// ParseSeverity returns the severity named s.
+func ParseSeverity(s string) (Severity, error) {
+	for i, name := range severities {
+		if s == name {
+			return Severity(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w '%s' (supported: %s)", ErrInvalidSeverity, s, strings.Join(severities, ", "))
+}
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severities) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+
+	return severities[s]
+}
+
+// MarshalText encodes the severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the name of a severity.
+func (s *Severity) UnmarshalText(b []byte) error {
+	severity, err := ParseSeverity(string(b))
+	if err != nil {
+		return err
+	}
+	*s = severity
+
+	return nil
+}
+
+// severityOf is the severity of the event types.
+var severityOf = map[string]Severity{
+	EventFailed:       SeverityError,
+	EventExpiringSoon: SeverityWarning,
+}
+
+// Event is something that happened to a certificate.
+type Event struct {
+	Type     string    `json:"type"`
+	Severity Severity  `json:"severity"`
+	Time     time.Time `json:"time"`
+	// Certificate is the name of the certificate definition.
+	Certificate string   `json:"certificate,omitempty"`
+	Domains     []string `json:"domains,omitempty"`
+	// Target is the type of the target of a deployed event.
+	Target string `json:"target,omitempty"`
+	// Serial is the hex encoded serial number of the certificate.
+	Serial   string    `json:"serial,omitempty"`
+	NotAfter time.Time `json:"not_after,omitzero"`
+	// Summary describes the event in a sentence.
+	Summary string `json:"summary"`
+	// Error is the error of a failed event.
+	Error string `json:"error,omitempty"`
+}
+
+// NewEvent returns an event of typ happening now, with the severity of typ.
+func NewEvent(typ, summary string) Event {
+	return Event{Type: typ, Severity: severityOf[typ], Time: time.Now(), Summary: summary}
+}
+
+// Notifier sends events to sinks. It keeps the state of their rate limits, so
+// it is reused as long as the sinks are. A nil *Notifier sends nothing.
+type Notifier struct {
+	Logger *zap.Logger
+
+	mu    sync.Mutex
+	sinks []*sinkState
+	now   func() time.Time
+}
+
+// sinkState is a sink with its parsed templates and the times of the recent
+// notifications counted by its rate limit.
+type sinkState struct {
+	*Sink
+	tmpl *templates
+	sent []time.Time
+}
+
+// New returns a notifier sending to sinks, which must be valid.
+func New(sinks []Sink, logger *zap.Logger) (*Notifier, error) {
+	n := &Notifier{Logger: logger, now: time.Now}
+	for i := range sinks {
+		s := &sinks[i]
+		if err := s.Valid(); err != nil {
+			return nil, err
+		}
+		t, err := s.parseTemplates()
+		if err != nil {
+			return nil, err
+		}
+		n.sinks = append(n.sinks, &sinkState{Sink: s, tmpl: t})
+	}
+
+	return n, nil
+}
+
+// Notify sends events to every sink accepting them. A failing sink does not
+// keep the others from being sent to, the errors are returned once all are
+// done.
+func (n *Notifier) Notify(ctx context.Context, events ...Event) error {
+	if n == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, s := range n.sinks {
+		for _, event := range events {
+			if !s.accepts(event) {
+				continue
+			}
+			if !n.allow(s) {
+				n.log().Info("notification rate limited", zap.Stringer("sink", s.Sink), zap.String("event", event.Type))
+				continue
+			}
+			if err := s.send(ctx, s.tmpl, event); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			n.log().Info("notification sent", zap.Stringer("sink", s.Sink), zap.String("event", event.Type))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// allow reports whether the rate limit of s permits another notification,
+// and counts it if so.
+func (n *Notifier) allow(s *sinkState) bool {
+	if s.RateLimit == nil || s.RateLimit.Events == 0 {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	window := s.RateLimit.interval()
+	recent := s.sent[:0]
+	for _, t := range s.sent {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	s.sent = recent
+	if len(s.sent) >= s.RateLimit.Events {
+		return false
+	}
+	s.sent = append(s.sent, now)
+
+	return true
+}
+
+func (n *Notifier) log() *zap.Logger {
+	if n.Logger == nil {
+		return zap.NewNop()
+	}
+
+	return n.Logger
+}
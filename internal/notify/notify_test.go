@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNotifier_Notify_filter(t *testing.T) {
+	t.Parallel()
+
+	events := []Event{
+		NewEvent(EventIssued, "issued"),
+		NewEvent(EventDeployed, "deployed"),
+		NewEvent(EventExpiringSoon, "expiring soon"),
+		NewEvent(EventFailed, "failed"),
+	}
+	tests := []struct {
+		name     string
+		severity string
+		events   []string
+		want     []string
+	}{
+		{"all", "", nil, []string{EventIssued, EventDeployed, EventExpiringSoon, EventFailed}},
+		{"warning", "warning", nil, []string{EventExpiringSoon, EventFailed}},
+		{"error", "error", nil, []string{EventFailed}},
+		{"events", "", []string{EventDeployed, EventFailed}, []string{EventDeployed, EventFailed}},
+		{"events and severity", "error", []string{EventDeployed, EventFailed}, []string{EventFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, requests := newTestServer(t, http.StatusOK)
+			n, err := New([]Sink{{Type: TypeWebhook, URL: srv.URL, Severity: tt.severity, Events: tt.events}}, nil)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := n.Notify(t.Context(), events...); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			var got []string
+			for range len(requests) {
+				var payload webhookPayload
+				if err := json.Unmarshal((<-requests).body, &payload); err != nil {
+					t.Fatalf("Unmarshal() error = %v", err)
+				}
+				got = append(got, payload.Type)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("sent = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("sent = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifier_Notify_rateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv, requests := newTestServer(t, http.StatusOK)
+	n, err := New([]Sink{{Type: TypeNtfy, URL: srv.URL, RateLimit: &RateLimit{Events: 2, Interval: 60}}}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	notify := func(want int) {
+		t.Helper()
+		if err := n.Notify(t.Context(), testEvent, testEvent, testEvent); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+		if got := len(requests); got != want {
+			t.Errorf("sent %d notifications, want %d", got, want)
+		}
+		for range len(requests) {
+			<-requests
+		}
+	}
+
+	notify(2)
+	now = now.Add(30 * time.Second)
+	notify(0)
+	now = now.Add(30 * time.Second)
+	notify(2)
+}
+
+func TestParseSeverity(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		got, err := ParseSeverity(s.String())
+		if err != nil || got != s {
+			t.Errorf("ParseSeverity(%q) = %v, %v, want %v", s, got, err, s)
+		}
+	}
+	if _, err := ParseSeverity("critical"); err == nil {
+		t.Error("ParseSeverity(critical) error = nil")
+	}
+}
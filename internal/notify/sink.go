@@ -0,0 +1,294 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Sink types.
+const (
+	// TypeNtfy publishes to an ntfy topic, the URL is the topic URL.
+	TypeNtfy = "ntfy"
+	// TypeGotify pushes a Gotify message, the URL is the Gotify server.
+	TypeGotify = "gotify"
+	// TypeSlack posts to a Slack incoming webhook.
+	TypeSlack = "slack"
+	// TypeWebhook posts the event with its title and message as JSON.
+	TypeWebhook = "webhook"
+)
+
+// Types are all sink types.
+var Types = []string{TypeNtfy, TypeGotify, TypeSlack, TypeWebhook}
+
+// DefaultTimeout bounds sending a notification if no timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
+// Default templates of the title and the message.
+const (
+	DefaultTitle   = "truenas-scale-acme: {{.Type}}{{with .Certificate}} {{.}}{{end}}"
+	DefaultMessage = "{{.Summary}}{{with .Error}}: {{.}}{{end}}"
+)
+
+// defaultRateLimitInterval is the interval of a rate limit without one.
+const defaultRateLimitInterval = time.Hour
+
+var (
+	// ErrInvalidSink is returned for a sink that cannot be sent to.
+	ErrInvalidSink = errors.New("invalid sink")
+	// ErrNotifyFailed is returned when a notification was not accepted.
+	ErrNotifyFailed = errors.New("notification failed")
+)
+
+// Sink is a notification service events are sent to.
+type Sink struct {
+	// Name identifies the sink in logs. It defaults to the type.
+	Name string `json:"name,omitempty"`
+	// Type is one of [Types].
+	Type string `json:"type"`
+	// URL is the ntfy topic URL, the Gotify server or the webhook URL.
+	URL string `json:"url"`
+	// Token is the ntfy access token or the Gotify application token.
+	Token string `json:"token,omitempty"`
+	// Headers are additional headers of the requests.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Severity is the minimum severity of the events sent, "info",
+	// "warning" or "error". It defaults to "info".
+	Severity string `json:"severity,omitempty"`
+	// Events are the event types sent. All are sent if unset.
+	Events []string `json:"events,omitempty"`
+	// Title and Message are text/template templates rendered with the
+	// [Event]. They default to [DefaultTitle] and [DefaultMessage].
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message,omitempty"`
+	// RateLimit bounds the notifications sent. It is unlimited if unset.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// Timeout is the maximum time to send a notification in seconds. It
+	// defaults to [DefaultTimeout].
+	Timeout int `json:"timeout,omitempty"`
+	// Client sends the requests. It defaults to [http.DefaultClient].
+	Client *http.Client `json:"-"`
+}
+
+// RateLimit bounds the notifications of a sink, those exceeding it are
+// dropped.
+type RateLimit struct {
+	// Events is the maximum number of notifications per interval.
+	Events int `json:"events"`
+	// Interval is the interval in seconds. It defaults to an hour.
+	Interval int `json:"interval,omitempty"`
+}
+
+func (r *RateLimit) interval() time.Duration {
+	if r.Interval > 0 {
+		return time.Duration(r.Interval) * time.Second
+	}
+
+	return defaultRateLimitInterval
+}
+
+// String returns the name of the sink.
+func (s *Sink) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return s.Type
+}
+
+// Valid checks the sink has a known type, an http(s) URL and valid filters,
+// templates and rate limit.
+func (s *Sink) Valid() error {
+	var errs []error
+	if !slices.Contains(Types, s.Type) {
+		errs = append(errs, fmt.Errorf("%w '%s': unknown type '%s' (supported: %s)", ErrInvalidSink, s, s.Type, strings.Join(Types, ", ")))
+	}
+	if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+		errs = append(errs, fmt.Errorf("%w '%s': url '%s' is not an http(s) URL", ErrInvalidSink, s, s.URL))
+	}
+	if s.Type == TypeGotify && s.Token == "" {
+		errs = append(errs, fmt.Errorf("%w '%s': gotify requires a token", ErrInvalidSink, s))
+	}
+	if s.Severity != "" {
+		if _, err := ParseSeverity(s.Severity); err != nil {
+			errs = append(errs, fmt.Errorf("%w '%s': %w", ErrInvalidSink, s, err))
+		}
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(EventTypes, event) {
+			errs = append(errs, fmt.Errorf("%w '%s': unknown event '%s'", ErrInvalidSink, s, event))
+		}
+	}
+	if _, err := s.parseTemplates(); err != nil {
+		errs = append(errs, err)
+	}
+	if s.RateLimit != nil && (s.RateLimit.Events < 0 || s.RateLimit.Interval < 0) {
+		errs = append(errs, fmt.Errorf("%w '%s': negative rate limit", ErrInvalidSink, s))
+	}
+
+	return errors.Join(errs...)
+}
+
+// accepts reports whether event passes the severity threshold and the event
+// filter of the sink.
+func (s *Sink) accepts(event Event) bool {
+	// an unset severity parses to SeverityInfo
+	threshold, _ := ParseSeverity(s.Severity)
+
+	return event.Severity >= threshold && (len(s.Events) == 0 || slices.Contains(s.Events, event.Type))
+}
+
+// templates are the parsed title and message templates of a sink.
+type templates struct {
+	title, message *template.Template
+}
+
+func (s *Sink) parseTemplates() (*templates, error) {
+	parse := func(name, text, fallback string) (*template.Template, error) {
+		if text == "" {
+			text = fallback
+		}
+		t, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w '%s': %s template: %w", ErrInvalidSink, s, name, err)
+		}
+		return t, nil
+	}
+
+	title, err := parse("title", s.Title, DefaultTitle)
+	if err != nil {
+		return nil, err
+	}
+	message, err := parse("message", s.Message, DefaultMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templates{title: title, message: message}, nil
+}
+
+// render returns the title and the message of event.
+func (t *templates) render(event Event) (string, string, error) {
+	var title, message strings.Builder
+	if err := t.title.Execute(&title, event); err != nil {
+		return "", "", fmt.Errorf("rendering title: %w", err)
+	}
+	if err := t.message.Execute(&message, event); err != nil {
+		return "", "", fmt.Errorf("rendering message: %w", err)
+	}
+
+	return title.String(), message.String(), nil
+}
+
+// ntfyPriorities and gotifyPriorities are the priorities of the severities.
+var (
+	ntfyPriorities   = map[Severity]int{SeverityInfo: 3, SeverityWarning: 4, SeverityError: 5}
+	gotifyPriorities = map[Severity]int{SeverityInfo: 2, SeverityWarning: 5, SeverityError: 8}
+)
+
+// webhookPayload is the body of a webhook request.
+type webhookPayload struct {
+	Event
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// send sends event rendered with t, bounded by the timeout of the sink.
+func (s *Sink) send(ctx context.Context, t *templates, event Event) error {
+	title, message, err := t.render(event)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrNotifyFailed, s, err)
+	}
+
+	timeout := DefaultTimeout
+	if s.Timeout > 0 {
+		timeout = time.Duration(s.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := s.request(ctx, title, message, event)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrNotifyFailed, s, err)
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrNotifyFailed, s, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: status %s: %s", ErrNotifyFailed, s, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// request returns the request delivering the title and message of event to
+// the service of the sink.
+func (s *Sink) request(ctx context.Context, title, message string, event Event) (*http.Request, error) {
+	switch s.Type {
+	case TypeNtfy:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(message))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", title)
+		req.Header.Set("Priority", strconv.Itoa(ntfyPriorities[event.Severity]))
+		req.Header.Set("Tags", event.Type)
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		}
+		return req, nil
+	case TypeGotify:
+		req, err := jsonRequest(ctx, strings.TrimSuffix(s.URL, "/")+"/message", map[string]any{
+			"title":    title,
+			"message":  message,
+			"priority": gotifyPriorities[event.Severity],
+		})
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Gotify-Key", s.Token)
+		return req, nil
+	case TypeSlack:
+		return jsonRequest(ctx, s.URL, map[string]string{"text": fmt.Sprintf("*%s*\n%s", title, message)})
+	case TypeWebhook:
+		return jsonRequest(ctx, s.URL, webhookPayload{Event: event, Title: title, Message: message})
+	default:
+		return nil, fmt.Errorf("%w: unknown type '%s'", ErrInvalidSink, s.Type)
+	}
+}
+
+// jsonRequest returns a POST request of body encoded as JSON.
+func jsonRequest(ctx context.Context, url string, body any) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testEvent = Event{
+	Type:        EventFailed,
+	Severity:    SeverityError,
+	Time:        time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+	Certificate: "nas",
+	Domains:     []string{"nas.example.com"},
+	Summary:     "error ensuring certificate for nas",
+	Error:       "acme: rate limited",
+}
+
+// request is a request received by a test server.
+type request struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// newTestServer returns a server recording the requests it receives and
+// responding with status.
+func newTestServer(t *testing.T, status int) (*httptest.Server, <-chan request) {
+	t.Helper()
+
+	requests := make(chan request, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{path: r.URL.Path, header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, requests
+}
+
+func TestSink_send(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		sink  Sink
+		check func(t *testing.T, r request)
+	}{
+		{
+			name: "ntfy",
+			sink: Sink{Type: TypeNtfy, Token: "tk_secret"},
+			check: func(t *testing.T, r request) {
+				t.Helper()
+				if got := r.header.Get("Title"); got != "truenas-scale-acme: failed nas" {
+					t.Errorf("Title = %q", got)
+				}
+				if r.header.Get("Priority") != "5" || r.header.Get("Tags") != EventFailed || r.header.Get("Authorization") != "Bearer tk_secret" {
+					t.Errorf("headers = %v", r.header)
+				}
+				if got := string(r.body); got != "error ensuring certificate for nas: acme: rate limited" {
+					t.Errorf("body = %q", got)
+				}
+			},
+		},
+		{
+			name: "gotify",
+			sink: Sink{Type: TypeGotify, Token: "app-token"},
+			check: func(t *testing.T, r request) {
+				t.Helper()
+				var got struct {
+					Title, Message string
+					Priority       int
+				}
+				if err := json.Unmarshal(r.body, &got); err != nil {
+					t.Fatalf("body = %s: %v", r.body, err)
+				}
+				if r.path != "/message" || r.header.Get("X-Gotify-Key") != "app-token" || got.Priority != 8 || got.Title == "" {
+					t.Errorf("request = %s %v %+v", r.path, r.header, got)
+				}
+			},
+		},
+		{
+			name: "slack",
+			sink: Sink{Type: TypeSlack, Title: "{{.Certificate}}", Message: "{{.Type}} at {{.Time.Format \"2006-01-02\"}}"},
+			check: func(t *testing.T, r request) {
+				t.Helper()
+				var got struct{ Text string }
+				if err := json.Unmarshal(r.body, &got); err != nil {
+					t.Fatalf("body = %s: %v", r.body, err)
+				}
+				if got.Text != "*nas*\nfailed at 2026-10-16" {
+					t.Errorf("text = %q", got.Text)
+				}
+			},
+		},
+		{
+			name: "webhook",
+			sink: Sink{Type: TypeWebhook, Headers: map[string]string{"Authorization": "Bearer token"}},
+			check: func(t *testing.T, r request) {
+				t.Helper()
+				var got webhookPayload
+				if err := json.Unmarshal(r.body, &got); err != nil {
+					t.Fatalf("body = %s: %v", r.body, err)
+				}
+				if got.Type != EventFailed || got.Severity != SeverityError || got.Error != testEvent.Error || got.Message == "" {
+					t.Errorf("payload = %+v", got)
+				}
+				if r.header.Get("Authorization") != "Bearer token" {
+					t.Errorf("headers = %v", r.header)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, requests := newTestServer(t, http.StatusOK)
+			tt.sink.URL = srv.URL
+			n, err := New([]Sink{tt.sink}, nil)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := n.Notify(t.Context(), testEvent); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+			tt.check(t, <-requests)
+		})
+	}
+}
+
+func TestSink_send_failure(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newTestServer(t, http.StatusForbidden)
+	n, err := New([]Sink{{Type: TypeWebhook, URL: srv.URL}}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := n.Notify(t.Context(), testEvent); !errors.Is(err, ErrNotifyFailed) {
+		t.Errorf("Notify() error = %v, want %v", err, ErrNotifyFailed)
+	}
+}
+
+func TestSink_Valid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		sink    Sink
+		wantErr bool
+	}{
+		{"ntfy", Sink{Type: TypeNtfy, URL: "https://ntfy.sh/topic"}, false},
+		{"full", Sink{Type: TypeWebhook, URL: "https://example.com", Severity: "warning", Events: []string{EventFailed}, Title: "{{.Type}}", RateLimit: &RateLimit{Events: 1}}, false},
+		{"unknown type", Sink{Type: "email", URL: "https://example.com"}, true},
+		{"no url", Sink{Type: TypeSlack}, true},
+		{"gotify without token", Sink{Type: TypeGotify, URL: "https://gotify.example.com"}, true},
+		{"unknown severity", Sink{Type: TypeNtfy, URL: "https://ntfy.sh/topic", Severity: "critical"}, true},
+		{"unknown event", Sink{Type: TypeNtfy, URL: "https://ntfy.sh/topic", Events: []string{"expired"}}, true},
+		{"invalid template", Sink{Type: TypeNtfy, URL: "https://ntfy.sh/topic", Message: "{{.Summary"}, true},
+		{"negative rate limit", Sink{Type: TypeNtfy, URL: "https://ntfy.sh/topic", RateLimit: &RateLimit{Events: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.sink.Valid()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Valid() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSink) {
+				t.Errorf("Valid() error = %v, want %v", err, ErrInvalidSink)
+			}
+		})
+	}
+}
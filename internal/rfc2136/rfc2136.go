@@ -0,0 +1,171 @@
+// Package rfc2136 implements a libdns provider that manages records with
+// dynamic DNS updates (RFC 2136) signed with a TSIG key (RFC 8945), as
+// supported by BIND, Knot, PowerDNS and others.
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// DefaultKeyAlgorithm is the TSIG algorithm used if none is configured.
+const DefaultKeyAlgorithm = "hmac-sha256"
+
+var (
+	// ErrUnsupportedAlgorithm is returned for a TSIG algorithm this package cannot sign with.
+	ErrUnsupportedAlgorithm = errors.New("unsupported TSIG algorithm")
+	// ErrUpdateRefused is returned when the server answers an update with an error code.
+	ErrUpdateRefused = errors.New("update refused")
+)
+
+// algorithms maps the accepted algorithm names to their TSIG identifiers.
+var algorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// Provider updates a zone on Server with TSIG signed dynamic updates.
+type Provider struct {
+	// Server is the address of the primary name server. The port defaults to 53.
+	Server string `json:"server"`
+	// KeyName is the name of the TSIG key.
+	KeyName string `json:"key_name"`
+	// KeyAlgorithm is the TSIG algorithm, e.g. "hmac-sha512". It defaults to
+	// [DefaultKeyAlgorithm].
+	KeyAlgorithm string `json:"key_alg,omitempty"`
+	// Key is the base64 encoded TSIG secret.
+	Key string `json:"key"`
+	// Network is the transport, "udp" (default) or "tcp".
+	Network string `json:"network,omitempty"`
+}
+
+// AppendRecords adds recs to zone.
+func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+	msg.Insert(rrs)
+	if err := p.exchange(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// DeleteRecords removes recs from zone.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+	msg.Remove(rrs)
+	if err := p.exchange(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// exchange signs msg, sends it to the server and checks the response.
+func (p *Provider) exchange(ctx context.Context, msg *dns.Msg) error {
+	alg := p.KeyAlgorithm
+	if alg == "" {
+		alg = DefaultKeyAlgorithm
+	}
+	tsigAlg, ok := algorithms[strings.TrimSuffix(strings.ToLower(alg), ".")]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	keyName := dns.Fqdn(p.KeyName)
+	msg.SetTsig(keyName, tsigAlg, 300, time.Now().Unix())
+
+	client := &dns.Client{
+		Net:        p.Network,
+		TsigSecret: map[string]string{keyName: p.Key},
+	}
+	resp, _, err := client.ExchangeContext(ctx, msg, serverAddr(p.Server))
+	if err != nil {
+		return fmt.Errorf("sending update to %s: %w", p.Server, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("%w by %s: %s", ErrUpdateRefused, p.Server, dns.RcodeToString[resp.Rcode])
+	}
+
+	return nil
+}
+
+// serverAddr adds the default DNS port to server if it has none.
+func serverAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// toRRs converts libdns records of zone to DNS resource records.
+func toRRs(zone string, recs []libdns.Record) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(recs))
+	for _, rec := range recs {
+		r := rec.RR()
+		hdr := dns.RR_Header{
+			Name:  dns.Fqdn(libdns.AbsoluteName(r.Name, zone)),
+			Class: dns.ClassINET,
+			Ttl:   uint32(r.TTL.Seconds()),
+		}
+
+		if txt, ok := rec.(libdns.TXT); ok || r.Type == "TXT" {
+			if !ok {
+				txt = libdns.TXT{Text: r.Data}
+			}
+			hdr.Rrtype = dns.TypeTXT
+			rrs = append(rrs, &dns.TXT{Hdr: hdr, Txt: splitTXT(txt.Text)})
+			continue
+		}
+
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", hdr.Name, hdr.Ttl, r.Type, r.Data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s record %q: %w", r.Type, r.Name, err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	return rrs, nil
+}
+
+// splitTXT splits text into the 255 byte strings a TXT record is made of.
+func splitTXT(text string) []string {
+	const maxLen = 255
+
+	var parts []string
+	for len(text) > maxLen {
+		parts = append(parts, text[:maxLen])
+		text = text[maxLen:]
+	}
+
+	return append(parts, text)
+}
+
+// Interface guards.
+var (
+	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordDeleter  = (*Provider)(nil)
+)
@@ -0,0 +1,184 @@
+package rfc2136
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+const (
+	testZone    = "example.com."
+	testKeyName = "acme-key."
+)
+
+var testSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+// testServer is an in-process name server accepting TSIG signed updates for
+// testZone.
+type testServer struct {
+	addr string
+
+	mu  sync.Mutex
+	txt map[string][]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	ts := &testServer{addr: pc.LocalAddr().String(), txt: map[string][]string{}}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{testKeyName: testSecret},
+		Handler:    dns.HandlerFunc(ts.serveDNS),
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction {
+			if int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+				return dns.MsgAccept
+			}
+			return dns.DefaultMsgAcceptFunc(dh)
+		},
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+
+	return ts
+}
+
+func (ts *testServer) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+
+	switch {
+	case req.IsTsig() == nil || w.TsigStatus() != nil:
+		resp.Rcode = dns.RcodeNotAuth
+	case req.Opcode != dns.OpcodeUpdate || req.Question[0].Name != testZone:
+		resp.Rcode = dns.RcodeRefused
+	default:
+		ts.apply(req.Ns)
+	}
+
+	if tsig := req.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+	_ = w.WriteMsg(resp)
+}
+
+func (ts *testServer) apply(rrs []dns.RR) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, rr := range rrs {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		value := slices.Concat(txt.Txt)
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			ts.txt[txt.Hdr.Name] = append(ts.txt[txt.Hdr.Name], value...)
+		case dns.ClassNONE:
+			ts.txt[txt.Hdr.Name] = slices.DeleteFunc(ts.txt[txt.Hdr.Name], func(v string) bool {
+				return slices.Contains(value, v)
+			})
+		}
+	}
+}
+
+func (ts *testServer) records(name string) []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return slices.Clone(ts.txt[name])
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t)
+
+	p := &Provider{Server: ts.addr, KeyName: "acme-key", Key: testSecret}
+	recs := []libdns.Record{libdns.TXT{Name: "_acme-challenge.nas", TTL: time.Minute, Text: "token"}}
+
+	if _, err := p.AppendRecords(t.Context(), testZone, recs); err != nil {
+		t.Fatalf("AppendRecords: %v", err)
+	}
+	if got := ts.records("_acme-challenge.nas.example.com."); !slices.Equal(got, []string{"token"}) {
+		t.Errorf("records after append = %v, want [token]", got)
+	}
+
+	if _, err := p.DeleteRecords(t.Context(), testZone, recs); err != nil {
+		t.Fatalf("DeleteRecords: %v", err)
+	}
+	if got := ts.records("_acme-challenge.nas.example.com."); len(got) != 0 {
+		t.Errorf("records after delete = %v, want none", got)
+	}
+}
+
+func TestProvider_badKey(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t)
+
+	p := &Provider{
+		Server:  ts.addr,
+		KeyName: "acme-key",
+		Key:     base64.StdEncoding.EncodeToString([]byte("wrong")),
+	}
+	recs := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "token"}}
+
+	if _, err := p.AppendRecords(t.Context(), testZone, recs); err == nil {
+		t.Fatal("AppendRecords with a wrong key succeeded")
+	}
+	if got := ts.records("_acme-challenge.example.com."); len(got) != 0 {
+		t.Errorf("records = %v, want none", got)
+	}
+}
+
+func TestProvider_unsupportedAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	p := &Provider{Server: "127.0.0.1", KeyName: "acme-key", KeyAlgorithm: "hmac-md4", Key: testSecret}
+	_, err := p.AppendRecords(t.Context(), testZone, []libdns.Record{libdns.TXT{Name: "x", Text: "y"}})
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("AppendRecords error = %v, want %v", err, ErrUnsupportedAlgorithm)
+	}
+}
+
+func Test_splitTXT(t *testing.T) {
+	t.Parallel()
+
+	long := string(make([]byte, 600))
+	parts := splitTXT(long)
+	if len(parts) != 3 || len(parts[0]) != 255 || len(parts[2]) != 90 {
+		t.Errorf("splitTXT(600 bytes) = %d parts", len(parts))
+	}
+	if got := splitTXT("token"); !slices.Equal(got, []string{"token"}) {
+		t.Errorf("splitTXT(token) = %v", got)
+	}
+}
+
+func Test_serverAddr(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]string{
+		"ns.example.com":      "ns.example.com:53",
+		"192.0.2.1:5353":      "192.0.2.1:5353",
+		"2001:db8::1":         "[2001:db8::1]:53",
+		"[2001:db8::1]:53530": "[2001:db8::1]:53530",
+	} {
+		if got := serverAddr(in); got != want {
+			t.Errorf("serverAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
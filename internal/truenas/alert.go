@@ -0,0 +1,46 @@
+package truenas
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Alert is an alert shown in the TrueNAS UI.
+type Alert struct {
+	UUID  string `json:"uuid"`
+	Klass string `json:"klass"`
+	// Args are the arguments the text of the alert class is formatted with.
+	Args      json.RawMessage `json:"args"`
+	Dismissed bool            `json:"dismissed"`
+}
+
+// AlertList returns the current alerts.
+func (c *Client) AlertList(ctx context.Context) ([]Alert, error) {
+	var alerts []Alert
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		alerts, err = c.a.AlertList(ctx)
+		return err
+	})
+
+	return alerts, err
+}
+
+// AlertOneshotCreate raises a one-shot alert of class, which shows up in the
+// TrueNAS alerts and is sent by the configured alert services. args are
+// formatted into the text of the alert class, most classes take a dict.
+// Raising an alert with the same key again replaces it.
+func (c *Client) AlertOneshotCreate(ctx context.Context, class string, args any) error {
+	return c.withReconnect(ctx, func() error {
+		return c.a.AlertOneshotCreate(ctx, class, args)
+	})
+}
+
+// AlertOneshotDelete clears the one-shot alerts of class matching query,
+// which the class compares with the keys of its alerts, e.g. the id of
+// ReplicationFailed.
+func (c *Client) AlertOneshotDelete(ctx context.Context, class string, query any) error {
+	return c.withReconnect(ctx, func() error {
+		return c.a.AlertOneshotDelete(ctx, class, query)
+	})
+}
@@ -0,0 +1,45 @@
+package truenas
+
+import "testing"
+
+func TestClient_AlertOneshot(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+
+	args := map[string]string{"id": "acme", "name": "acme", "message": "renewal failed"}
+	if err := client.AlertOneshotCreate(t.Context(), "ReplicationFailed", args); err != nil {
+		t.Fatalf("AlertOneshotCreate() error = %v", err)
+	}
+	if got, want := string(srv.Alerts()["ReplicationFailed"]), `{"id":"acme","message":"renewal failed","name":"acme"}`; got != want {
+		t.Errorf("alert args = %s, want %s", got, want)
+	}
+
+	alerts, err := client.AlertList(t.Context())
+	if err != nil {
+		t.Fatalf("AlertList() error = %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Klass != "ReplicationFailed" {
+		t.Errorf("AlertList() = %+v, want the raised alert", alerts)
+	}
+
+	if err := client.AlertOneshotDelete(t.Context(), "ReplicationFailed", "other"); err != nil {
+		t.Fatalf("AlertOneshotDelete() error = %v", err)
+	}
+	if alerts := srv.Alerts(); len(alerts) != 1 {
+		t.Errorf("alerts = %v after AlertOneshotDelete() of another id, want the alert kept", alerts)
+	}
+	if err := client.AlertOneshotDelete(t.Context(), "ReplicationFailed", "acme"); err != nil {
+		t.Fatalf("AlertOneshotDelete() error = %v", err)
+	}
+	if alerts := srv.Alerts(); len(alerts) != 0 {
+		t.Errorf("alerts = %v after AlertOneshotDelete(), want none", alerts)
+	}
+
+	if err := client.AlertOneshotCreate(t.Context(), "CertificateRenewalFailed", args); err == nil {
+		t.Error("AlertOneshotCreate() error = nil for an unknown class")
+	}
+	if err := client.AlertOneshotCreate(t.Context(), "ReplicationFailed", "renewal failed"); err == nil {
+		t.Error("AlertOneshotCreate() error = nil for args that are not a dict")
+	}
+}
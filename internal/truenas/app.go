@@ -0,0 +1,83 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// errAppNotFound is returned when app.query has no entry for an app.
+var errAppNotFound = errors.New("app not found")
+
+// App describes an installed app as returned by app.query.
+type App struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Config holds the values the app is configured with.
+	Config map[string]any `json:"config"`
+}
+
+// appQueryOptions are the query-options for app.query.
+type appQueryOptions struct {
+	Extra appQueryExtra `json:"extra"`
+	Limit int           `json:"limit,omitempty"`
+}
+
+// appQueryExtra are the extra options of app.query.
+type appQueryExtra struct {
+	// RetrieveConfig includes the values of the apps in the result.
+	RetrieveConfig bool `json:"retrieve_config"`
+}
+
+// AppUpdateParams holds parameters for updating an app.
+type AppUpdateParams struct {
+	// Values are merged into the values of the app.
+	Values map[string]any `json:"values"`
+}
+
+// Apps returns the installed apps with their values.
+func (c *Client) Apps(ctx context.Context) ([]App, error) {
+	var apps []App
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		apps, err = c.a.AppQuery(ctx, [][]any{}, appQueryOptions{Extra: appQueryExtra{RetrieveConfig: true}})
+		return err
+	})
+	return apps, err
+}
+
+// App returns the named app with its values.
+func (c *Client) App(ctx context.Context, name string) (*App, error) {
+	var apps []App
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		apps, err = c.a.AppQuery(ctx, [][]any{{"name", "=", name}}, appQueryOptions{Extra: appQueryExtra{RetrieveConfig: true}, Limit: 1})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("%w: %q", errAppNotFound, name)
+	}
+	return &apps[0], nil
+}
+
+// AppUpdate updates the values of the named app and waits until it is
+// redeployed.
+func (c *Client) AppUpdate(ctx context.Context, name string, params AppUpdateParams) error {
+	// app.update is a job: it returns a job ID and completes once the app is
+	// redeployed with the new values.
+	var jobID int
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		jobID, err = c.a.AppUpdate(ctx, name, params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, jobID)
+}
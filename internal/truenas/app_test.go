@@ -0,0 +1,37 @@
+package truenas
+
+import "testing"
+
+func TestClient_AppUpdate(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	imported, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t))
+	if err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+	srv.AddApp("nextcloud", map[string]any{"network": map[string]any{"web_port": 30027}})
+
+	values := map[string]any{"network": map[string]any{"certificate_id": imported.ID}}
+	if err := client.AppUpdate(t.Context(), "nextcloud", AppUpdateParams{Values: values}); err != nil {
+		t.Fatalf("AppUpdate() error = %v", err)
+	}
+
+	app, err := client.App(t.Context(), "nextcloud")
+	if err != nil {
+		t.Fatalf("App() error = %v", err)
+	}
+	network, _ := app.Config["network"].(map[string]any)
+	if network["certificate_id"] != float64(imported.ID) || network["web_port"] != float64(30027) {
+		t.Errorf("App() network = %v, want certificate_id %d and web_port kept", network, imported.ID)
+	}
+
+	// the certificate must exist
+	values = map[string]any{"network": map[string]any{"certificate_id": 42}}
+	if err := client.AppUpdate(t.Context(), "nextcloud", AppUpdateParams{Values: values}); err == nil {
+		t.Error("AppUpdate() with an unknown certificate succeeded")
+	}
+	if _, err := client.App(t.Context(), "missing"); err == nil {
+		t.Error("App() of a missing app succeeded")
+	}
+}
@@ -14,6 +14,10 @@ import (
 // the import job reported success.
 var errCertificateNotFound = errors.New("certificate not found after import")
 
+// errNoPEMCertificate is returned when a certificate entry holds no PEM
+// encoded certificate.
+var errNoPEMCertificate = errors.New("no PEM certificate found")
+
 // Certificate represents a TrueNAS certificate entry.
 type Certificate struct {
 	ID          int      `json:"id"`
@@ -43,6 +47,33 @@ func (c *Certificate) TLSCertificate() (tls.Certificate, error) {
 	return cert, nil
 }
 
+// Leaf parses the first certificate of the PEM encoded chain. Unlike
+// [Certificate.TLSCertificate] it does not need the private key.
+func (c *Certificate) Leaf() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(c.Certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errNoPEMCertificate
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing leaf certificate: %w", err)
+	}
+	return leaf, nil
+}
+
+// DNSNames returns the DNS names from the certificate's subject alternative
+// names. TrueNAS reports them prefixed with their type, e.g. "DNS:example.com".
+func (c *Certificate) DNSNames() []string {
+	names := make([]string, 0, len(c.SAN))
+	for _, san := range c.SAN {
+		if name, ok := strings.CutPrefix(san, "DNS:"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // CertificateCreateParams holds parameters for creating/importing a certificate.
 type CertificateCreateParams struct {
 	Name        string `json:"name"`
@@ -64,7 +95,7 @@ func (c *Client) Certificates(ctx context.Context) ([]Certificate, error) {
 
 // CertificateImport imports a TLS certificate into TrueNAS.
 func (c *Client) CertificateImport(ctx context.Context, name string, cert tls.Certificate) (*Certificate, error) {
-	pkPEM, err := encodePrivateKeyPEM(cert)
+	pkPEM, err := EncodePrivateKeyPEM(cert)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +103,7 @@ func (c *Client) CertificateImport(ctx context.Context, name string, cert tls.Ce
 	params := CertificateCreateParams{
 		Name:        name,
 		CreateType:  "CERTIFICATE_CREATE_IMPORTED",
-		Certificate: encodeChainPEM(cert),
+		Certificate: EncodeChainPEM(cert),
 		Privatekey:  pkPEM,
 	}
 
@@ -121,7 +152,8 @@ func (c *Client) CertificateDelete(ctx context.Context, id int) error {
 	return c.waitForJob(ctx, jobID)
 }
 
-func encodeChainPEM(cert tls.Certificate) string {
+// EncodeChainPEM encodes the certificate chain of cert as PEM, leaf first.
+func EncodeChainPEM(cert tls.Certificate) string {
 	chain := strings.Builder{}
 	for _, derBytes := range cert.Certificate {
 		block := pem.EncodeToMemory(&pem.Block{
@@ -133,7 +165,8 @@ func encodeChainPEM(cert tls.Certificate) string {
 	return chain.String()
 }
 
-func encodePrivateKeyPEM(cert tls.Certificate) (string, error) {
+// EncodePrivateKeyPEM encodes the private key of cert as PKCS #8 PEM.
+func EncodePrivateKeyPEM(cert tls.Certificate) (string, error) {
 	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
 	if err != nil {
 		return "", fmt.Errorf("marshaling private key: %w", err)
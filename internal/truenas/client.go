@@ -23,16 +23,30 @@ const DefaultURL = "ws://localhost/api/current"
 var errInvalidAPIKey = errors.New("auth: invalid API key")
 
 type api struct {
-	AuthLoginWithAPIKey  func(ctx context.Context, apiKey string) (bool, error)                                   `rpc_method:"auth.login_with_api_key"`
-	SystemInfoMethod     func(ctx context.Context) (*SystemInfo, error)                                           `rpc_method:"system.info"`
-	CoreGetMethods       func(ctx context.Context) (json.RawMessage, error)                                       `rpc_method:"core.get_methods"`
-	CertificateQuery     func(ctx context.Context) ([]Certificate, error)                                         `rpc_method:"certificate.query"`
-	CertificateCreate    func(ctx context.Context, params CertificateCreateParams) (int, error)                   `rpc_method:"certificate.create"`
-	CertificateDelete    func(ctx context.Context, id int, force bool) (int, error)                               `rpc_method:"certificate.delete"`
-	CoreGetJobs          func(ctx context.Context, filters [][]any, options jobQueryOptions) ([]Job, error)       `rpc_method:"core.get_jobs"`
-	SystemGeneralConfig  func(ctx context.Context) (*SystemGeneralEntry, error)                                   `rpc_method:"system.general.config"`
-	SystemGeneralUpdate  func(ctx context.Context, params SystemGeneralUpdateParams) (*SystemGeneralEntry, error) `rpc_method:"system.general.update"`
-	SystemGeneralCheckin func(ctx context.Context) error                                                          `rpc_method:"system.general.checkin"`
+	AuthLoginWithAPIKey  func(ctx context.Context, apiKey string) (bool, error)                                     `rpc_method:"auth.login_with_api_key"`
+	SystemInfoMethod     func(ctx context.Context) (*SystemInfo, error)                                             `rpc_method:"system.info"`
+	CoreGetMethods       func(ctx context.Context) (json.RawMessage, error)                                         `rpc_method:"core.get_methods"`
+	CertificateQuery     func(ctx context.Context) ([]Certificate, error)                                           `rpc_method:"certificate.query"`
+	CertificateCreate    func(ctx context.Context, params CertificateCreateParams) (int, error)                     `rpc_method:"certificate.create"`
+	CertificateDelete    func(ctx context.Context, id int, force bool) (int, error)                                 `rpc_method:"certificate.delete"`
+	CoreGetJobs          func(ctx context.Context, filters [][]any, options jobQueryOptions) ([]Job, error)         `rpc_method:"core.get_jobs"`
+	SystemGeneralConfig  func(ctx context.Context) (*SystemGeneralEntry, error)                                     `rpc_method:"system.general.config"`
+	SystemGeneralUpdate  func(ctx context.Context, params SystemGeneralUpdateParams) (*SystemGeneralEntry, error)   `rpc_method:"system.general.update"`
+	SystemGeneralCheckin func(ctx context.Context) error                                                            `rpc_method:"system.general.checkin"`
+	ServiceQuery         func(ctx context.Context, filters [][]any, options serviceQueryOptions) ([]Service, error) `rpc_method:"service.query"`
+	ServiceRestart       func(ctx context.Context, service string, options serviceControlOptions) (int, error)      `rpc_method:"service.restart"`
+	S3Config             func(ctx context.Context) (*S3Entry, error)                                                `rpc_method:"s3.config"`
+	S3Update             func(ctx context.Context, params S3UpdateParams) (*S3Entry, error)                         `rpc_method:"s3.update"`
+	FTPConfig            func(ctx context.Context) (*FTPEntry, error)                                               `rpc_method:"ftp.config"`
+	FTPUpdate            func(ctx context.Context, params FTPUpdateParams) (*FTPEntry, error)                       `rpc_method:"ftp.update"`
+	WebDAVConfig         func(ctx context.Context) (*WebDAVEntry, error)                                            `rpc_method:"webdav.config"`
+	WebDAVUpdate         func(ctx context.Context, params WebDAVUpdateParams) (*WebDAVEntry, error)                 `rpc_method:"webdav.update"`
+	AppQuery             func(ctx context.Context, filters [][]any, options appQueryOptions) ([]App, error)         `rpc_method:"app.query"`
+	AppUpdate            func(ctx context.Context, name string, params AppUpdateParams) (int, error)                `rpc_method:"app.update"`
+	AlertList            func(ctx context.Context) ([]Alert, error)                                                 `rpc_method:"alert.list"`
+	AlertOneshotCreate   func(ctx context.Context, class string, args any) error                                    `rpc_method:"alert.oneshot_create"`
+	AlertOneshotDelete   func(ctx context.Context, class string, query any) error                                   `rpc_method:"alert.oneshot_delete"`
+	MailSend             func(ctx context.Context, message MailMessage) (int, error)                                `rpc_method:"mail.send"`
 }
 
 // Client is a TrueNAS SCALE API client.
@@ -41,13 +55,24 @@ type Client struct {
 	a      api
 	closer jsonrpc.ClientCloser
 
-	apiKey string
-	opts   []Option
+	apiKey   string
+	opts     []Option
+	observer Observer
 }
 
 type config struct {
 	url       *url.URL
 	tlsConfig *tls.Config
+	observer  Observer
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	return cfg
 }
 
 // Option configures a Client.
@@ -68,10 +93,7 @@ func WithTLSConfig(tc *tls.Config) Option {
 }
 
 func dial(ctx context.Context, apiKey string, opts []Option) (api, jsonrpc.ClientCloser, error) {
-	cfg := &config{}
-	for _, o := range opts {
-		o(cfg)
-	}
+	cfg := newConfig(opts)
 
 	addr := cfg.url
 	if addr == nil {
@@ -91,17 +113,25 @@ func dial(ctx context.Context, apiKey string, opts []Option) (api, jsonrpc.Clien
 
 	var a api
 	closer, err := jsonrpc.NewMergeClient(
-		ctx,
+		// the connection lives until closed, not until ctx is done: reconnects
+		// happen with short-lived contexts
+		context.WithoutCancel(ctx),
 		addr.String(),
 		"",
 		[]any{&a},
 		nil,
 		jsonrpc.WithNoReconnect(),
 		jsonrpc.WithMethodNameFormatter(jsonrpc.DefaultMethodNameFormatter),
+		// map the error of calls in flight when the connection closes to
+		// *jsonrpc.RPCConnectionError, see isConnectionError
+		jsonrpc.WithErrors(jsonrpc.NewErrors()),
 	)
 	if err != nil {
 		return api{}, nil, fmt.Errorf("jsonrpc connect: %w", err)
 	}
+	if cfg.observer != nil {
+		observe(&a, cfg.observer)
+	}
 
 	ok, err := a.AuthLoginWithAPIKey(ctx, apiKey)
 	if err != nil {
@@ -124,10 +154,11 @@ func Dial(ctx context.Context, apiKey string, opts ...Option) (*Client, error) {
 	}
 
 	return &Client{
-		a:      a,
-		closer: closer,
-		apiKey: apiKey,
-		opts:   opts,
+		a:        a,
+		closer:   closer,
+		apiKey:   apiKey,
+		opts:     opts,
+		observer: newConfig(opts).observer,
 	}, nil
 }
 
@@ -137,6 +168,7 @@ func (c *Client) Close() {
 	defer c.mu.Unlock()
 	if c.closer != nil {
 		c.closer()
+		c.closer = nil
 	}
 }
 
@@ -144,7 +176,9 @@ func (c *Client) Close() {
 // Must be called with c.mu held.
 func (c *Client) reconnect(ctx context.Context) error {
 	if c.closer != nil {
+		// the closer must only be called once, also if the dial below fails
 		c.closer()
+		c.closer = nil
 	}
 	a, closer, err := dial(ctx, c.apiKey, c.opts)
 	if err != nil {
@@ -159,27 +193,60 @@ func (c *Client) reconnect(ctx context.Context) error {
 // when the server is unavailable (e.g. while the UI is restarting).
 const reconnectTimeout = 60 * time.Second
 
-// withReconnect runs f, and if it fails with a transport-level error (a dropped
-// or refused connection), reconnects with backoff and retries f once.
+// isConnectionError reports whether err is a transport-level error: a dropped
+// or refused connection.
 //
 // The go-jsonrpc library wraps every client-side/transport failure in
 // *jsonrpc.ErrClient (this includes "websocket routine exiting" after the
-// connection drops, and dial failures). Server-side RPC errors are returned as
-// *jsonrpc.JSONRPCError instead, and must propagate unchanged.
+// connection drops, and dial failures). Calls in flight when the connection
+// closes fail with *jsonrpc.RPCConnectionError. Server-side RPC errors are
+// returned as *jsonrpc.JSONRPCError instead.
+func isConnectionError(err error) bool {
+	var (
+		clientErr *jsonrpc.ErrClient
+		connErr   *jsonrpc.RPCConnectionError
+	)
+	return errors.As(err, &clientErr) || errors.As(err, &connErr)
+}
+
+// errReconnectFailed is returned when the connection dropped and could not be
+// established again.
+var errReconnectFailed = errors.New("reconnect failed")
+
+// IsConnectionError reports whether err is TrueNAS being unreachable: a
+// dropped or refused connection that could not be established again.
+func IsConnectionError(err error) bool {
+	return isConnectionError(err) || errors.Is(err, errReconnectFailed)
+}
+
+// methodNotFoundCode is the JSON-RPC error code for a method the server does
+// not know.
+const methodNotFoundCode = -32601
+
+// IsMethodNotFound reports whether err is the server rejecting a method it does
+// not know, e.g. the API of a service removed from the running TrueNAS
+// version.
+func IsMethodNotFound(err error) bool {
+	var rpcErr *jsonrpc.JSONRPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == methodNotFoundCode
+}
+
+// withReconnect runs f, and if it fails with a transport-level error (a dropped
+// or refused connection), reconnects with backoff and retries f once.
+// Server-side RPC errors propagate unchanged.
 func (c *Client) withReconnect(ctx context.Context, f func() error) error {
 	err := f()
 	if err == nil {
 		return nil
 	}
-	var clientErr *jsonrpc.ErrClient
-	if !errors.As(err, &clientErr) {
+	if !isConnectionError(err) {
 		return err
 	}
 
 	rctx, cancel := context.WithTimeout(ctx, reconnectTimeout)
 	defer cancel()
 	if rerr := c.reconnectWithBackoff(rctx); rerr != nil {
-		return fmt.Errorf("reconnect failed: %w (original: %v)", rerr, err)
+		return fmt.Errorf("%w: %w (original: %v)", errReconnectFailed, rerr, err)
 	}
 
 	return f()
@@ -200,6 +267,9 @@ func (c *Client) reconnectWithBackoff(ctx context.Context) error {
 		c.mu.Lock()
 		err := c.reconnect(ctx)
 		c.mu.Unlock()
+		if c.observer != nil {
+			c.observer.Reconnect(err)
+		}
 		if err == nil {
 			return nil
 		}
@@ -1,9 +1,14 @@
 package truenas
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"net/url"
 	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
 )
 
 func TestDefaultURL(t *testing.T) {
@@ -67,3 +72,99 @@ func TestWithTLSConfig_Nil(t *testing.T) {
 		t.Errorf("expected nil tlsConfig, got %v", cfg.tlsConfig)
 	}
 }
+
+// newTestClient starts a fake TrueNAS server and connects to it.
+func newTestClient(t *testing.T, opts ...truenastest.Option) (*Client, *truenastest.Server) {
+	t.Helper()
+
+	srv := truenastest.NewServer(opts...)
+	t.Cleanup(srv.Close)
+
+	client, err := Dial(t.Context(), truenastest.DefaultAPIKey, WithURL(srv.URL))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return client, srv
+}
+
+func TestDial_invalidAPIKey(t *testing.T) {
+	t.Parallel()
+
+	srv := truenastest.NewServer()
+	t.Cleanup(srv.Close)
+
+	_, err := Dial(t.Context(), "wrong", WithURL(srv.URL))
+	if !errors.Is(err, errInvalidAPIKey) {
+		t.Errorf("Dial() error = %v, want %v", err, errInvalidAPIKey)
+	}
+}
+
+func TestClient_withReconnect(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	srv.DropNext("system.info", 1)
+
+	info, err := client.SystemInfo(t.Context())
+	if err != nil {
+		t.Fatalf("SystemInfo() error = %v", err)
+	}
+	if info.Hostname == "" {
+		t.Error("SystemInfo() returned no hostname")
+	}
+	if got := srv.Calls("system.info"); got != 2 {
+		t.Errorf("system.info called %d times, want 2", got)
+	}
+	if got := srv.Calls("auth.login_with_api_key"); got != 2 {
+		t.Errorf("auth.login_with_api_key called %d times, want 2", got)
+	}
+}
+
+func TestClient_withReconnect_unavailable(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	srv.SetUnavailable(true)
+	srv.DropConnections()
+	time.AfterFunc(500*time.Millisecond, func() { srv.SetUnavailable(false) })
+
+	if _, err := client.SystemInfo(t.Context()); err != nil {
+		t.Fatalf("SystemInfo() error = %v", err)
+	}
+}
+
+func TestClient_withReconnect_rpcError(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+
+	if err := client.CertificateDelete(t.Context(), 42); err == nil {
+		t.Fatal("CertificateDelete() of an unknown certificate succeeded")
+	}
+	if got := srv.Calls("certificate.delete"); got != 1 {
+		t.Errorf("certificate.delete called %d times, want 1", got)
+	}
+	if got := srv.Calls("auth.login_with_api_key"); got != 1 {
+		t.Errorf("reconnected on an RPC error, auth.login_with_api_key called %d times", got)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	err := client.CertificateDelete(t.Context(), 42)
+	if err == nil || IsConnectionError(err) {
+		t.Errorf("IsConnectionError(%v) = true for an RPC error", err)
+	}
+
+	srv.SetUnavailable(true)
+	srv.DropConnections()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	if _, err := client.SystemInfo(ctx); !IsConnectionError(err) {
+		t.Errorf("IsConnectionError(%v) = false while unavailable", err)
+	}
+}
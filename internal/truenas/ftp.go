@@ -0,0 +1,42 @@
+package truenas
+
+import "context"
+
+// FTPEntry holds the configuration of the FTP service.
+type FTPEntry struct {
+	ID   int `json:"id"`
+	Port int `json:"port"`
+	// TLS enables FTPS with SSLTLSCertificate.
+	TLS bool `json:"tls"`
+	// SSLTLSCertificate is the ID of the certificate used for FTPS, nil if
+	// none is set.
+	SSLTLSCertificate *int `json:"ssltls_certificate"`
+}
+
+// FTPUpdateParams holds parameters for updating the FTP service configuration.
+type FTPUpdateParams struct {
+	TLS               *bool `json:"tls,omitempty"`
+	SSLTLSCertificate *int  `json:"ssltls_certificate,omitempty"`
+}
+
+// FTPConfig returns the FTP service configuration.
+func (c *Client) FTPConfig(ctx context.Context) (*FTPEntry, error) {
+	var result *FTPEntry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.FTPConfig(ctx)
+		return err
+	})
+	return result, err
+}
+
+// FTPUpdate updates the FTP service configuration.
+func (c *Client) FTPUpdate(ctx context.Context, params FTPUpdateParams) (*FTPEntry, error) {
+	var result *FTPEntry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.FTPUpdate(ctx, params)
+		return err
+	})
+	return result, err
+}
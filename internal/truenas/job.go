@@ -53,18 +53,25 @@ func (c *Client) getJob(ctx context.Context, id int) (*Job, error) {
 // waitForJob polls core.get_jobs until the job reaches a terminal state.
 // It returns an error if the job fails or is aborted, or if the context is
 // cancelled or jobWaitTimeout elapses.
-func (c *Client) waitForJob(ctx context.Context, id int) error {
+func (c *Client) waitForJob(ctx context.Context, id int) (err error) {
 	ctx, cancel := context.WithTimeout(ctx, jobWaitTimeout)
 	defer cancel()
 
 	ticker := time.NewTicker(jobPollInterval)
 	defer ticker.Stop()
 
+	var method string
+	if c.observer != nil {
+		start := time.Now()
+		defer func() { c.observer.Job(method, time.Since(start), err) }()
+	}
+
 	for {
 		job, err := c.getJob(ctx, id)
 		if err != nil {
 			return err
 		}
+		method = job.Method
 
 		switch job.State {
 		case "SUCCESS":
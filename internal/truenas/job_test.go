@@ -0,0 +1,90 @@
+package truenas
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func TestClient_CertificateImport(t *testing.T) {
+	t.Parallel()
+
+	// outlast the first poll, so waitForJob sees the job running
+	client, srv := newTestClient(t, truenastest.WithJobDuration(2*jobPollInterval))
+	cert := generateSelfSignedCert(t)
+
+	imported, err := client.CertificateImport(t.Context(), "acme-test", cert)
+	if err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+	if imported.Name != "acme-test" {
+		t.Errorf("CertificateImport() name = %q, want %q", imported.Name, "acme-test")
+	}
+	leaf, err := imported.Leaf()
+	if err != nil {
+		t.Fatalf("Leaf() error = %v", err)
+	}
+	if !leaf.Equal(cert.Leaf) {
+		t.Error("CertificateImport() returned a different certificate")
+	}
+	if got := srv.Calls("core.get_jobs"); got < 2 {
+		t.Errorf("core.get_jobs called %d times, want the job polled at least twice", got)
+	}
+}
+
+func TestClient_CertificateDelete(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	imported, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t))
+	if err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+
+	srv.SetUICertificate(imported.ID)
+	if err := client.CertificateDelete(t.Context(), imported.ID); err == nil {
+		t.Error("CertificateDelete() of the ui certificate succeeded")
+	}
+
+	srv.SetUICertificate(0)
+	if err := client.CertificateDelete(t.Context(), imported.ID); err != nil {
+		t.Fatalf("CertificateDelete() error = %v", err)
+	}
+	if certs := srv.Certificates(); len(certs) != 0 {
+		t.Errorf("certificates after delete = %+v, want none", certs)
+	}
+}
+
+func TestClient_waitForJob_failed(t *testing.T) {
+	t.Parallel()
+
+	client, _ := newTestClient(t)
+	id, err := client.a.CertificateCreate(t.Context(), CertificateCreateParams{
+		Name:        "broken",
+		CreateType:  "CERTIFICATE_CREATE_IMPORTED",
+		Certificate: "not a certificate",
+	})
+	if err != nil {
+		t.Fatalf("certificate.create error = %v", err)
+	}
+
+	if err := client.waitForJob(t.Context(), id); !errors.Is(err, errJobFailed) {
+		t.Errorf("waitForJob() error = %v, want %v", err, errJobFailed)
+	}
+}
+
+func TestClient_waitForJob_reconnect(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t, truenastest.WithJobDuration(100*time.Millisecond))
+	srv.DropNext("core.get_jobs", 1)
+
+	if _, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t)); err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+	if got := srv.Calls("auth.login_with_api_key"); got != 2 {
+		t.Errorf("auth.login_with_api_key called %d times, want 2", got)
+	}
+}
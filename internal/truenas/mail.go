@@ -0,0 +1,30 @@
+package truenas
+
+import "context"
+
+// MailMessage is a message sent through the TrueNAS mail service.
+type MailMessage struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	// To are the recipients. The message is sent to the email address of the
+	// administrator if it is empty.
+	To []string `json:"to,omitempty"`
+}
+
+// MailSend sends msg with the mail settings of TrueNAS and waits until it is
+// sent.
+func (c *Client) MailSend(ctx context.Context, msg MailMessage) error {
+	// mail.send is a job: it returns a job ID and completes once the message
+	// is handed to the mail server.
+	var jobID int
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		jobID, err = c.a.MailSend(ctx, msg)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, jobID)
+}
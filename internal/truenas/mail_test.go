@@ -0,0 +1,26 @@
+package truenas
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClient_MailSend(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	msg := MailMessage{Subject: "certificates renewed", Text: "nas.example.com", To: []string{"admin@example.com"}}
+
+	if err := client.MailSend(t.Context(), msg); err != nil {
+		t.Fatalf("MailSend() error = %v", err)
+	}
+	mails := srv.Mails()
+	if len(mails) != 1 || mails[0].Subject != msg.Subject || mails[0].Text != msg.Text || len(mails[0].To) != 1 {
+		t.Errorf("mails = %+v, want %+v", mails, msg)
+	}
+
+	srv.FailMails()
+	if err := client.MailSend(t.Context(), msg); !errors.Is(err, errJobFailed) {
+		t.Errorf("MailSend() error = %v, want %v", err, errJobFailed)
+	}
+}
@@ -0,0 +1,41 @@
+package truenas
+
+import (
+	"reflect"
+	"time"
+)
+
+// Observer is notified about the calls of a [Client], e.g. to record metrics.
+// Its methods are called concurrently.
+type Observer interface {
+	// Call is called after an RPC call of method returned.
+	Call(method string, took time.Duration, err error)
+	// Reconnect is called after an attempt to reconnect.
+	Reconnect(err error)
+	// Job is called when waiting for a job of method ended.
+	Job(method string, took time.Duration, err error)
+}
+
+// WithObserver reports the calls of the client to o.
+func WithObserver(o Observer) Option {
+	return func(c *config) {
+		c.observer = o
+	}
+}
+
+// observe replaces the methods of a with functions reporting every call to o.
+func observe(a *api, o Observer) {
+	v := reflect.ValueOf(a).Elem()
+	for i := range v.NumField() {
+		field := v.Field(i)
+		method := v.Type().Field(i).Tag.Get("rpc_method")
+		call := reflect.ValueOf(field.Interface())
+		field.Set(reflect.MakeFunc(field.Type(), func(args []reflect.Value) []reflect.Value {
+			start := time.Now()
+			results := call.Call(args)
+			err, _ := results[len(results)-1].Interface().(error)
+			o.Call(method, time.Since(start), err)
+			return results
+		}))
+	}
+}
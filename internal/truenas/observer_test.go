@@ -0,0 +1,78 @@
+package truenas
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+// recorder is an Observer recording what it is told.
+type recorder struct {
+	mu         sync.Mutex
+	calls      map[string]int
+	errors     map[string]int
+	reconnects int
+	jobs       []string
+}
+
+func (r *recorder) Call(method string, _ time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls[method]++
+	if err != nil {
+		r.errors[method]++
+	}
+}
+
+func (r *recorder) Reconnect(error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reconnects++
+}
+
+func (r *recorder) Job(method string, _ time.Duration, _ error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs = append(r.jobs, method)
+}
+
+func TestWithObserver(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{calls: map[string]int{}, errors: map[string]int{}}
+	srv := truenastest.NewServer(truenastest.WithJobDuration(0))
+	t.Cleanup(srv.Close)
+	client, err := Dial(t.Context(), truenastest.DefaultAPIKey, WithURL(srv.URL), WithObserver(rec))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	srv.DropNext("system.info", 1)
+	if _, err := client.SystemInfo(t.Context()); err != nil {
+		t.Fatalf("SystemInfo() error = %v", err)
+	}
+	if _, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t)); err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.calls["system.info"] != 2 || rec.errors["system.info"] != 1 {
+		t.Errorf("system.info calls = %d, errors = %d, want 2 and 1", rec.calls["system.info"], rec.errors["system.info"])
+	}
+	if rec.calls["auth.login_with_api_key"] != 2 {
+		t.Errorf("auth.login_with_api_key calls = %d, want 2", rec.calls["auth.login_with_api_key"])
+	}
+	if rec.reconnects != 1 {
+		t.Errorf("reconnects = %d, want 1", rec.reconnects)
+	}
+	if len(rec.jobs) != 1 || rec.jobs[0] != "certificate.create" {
+		t.Errorf("jobs = %v, want certificate.create", rec.jobs)
+	}
+}
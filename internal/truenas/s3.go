@@ -0,0 +1,46 @@
+package truenas
+
+import "context"
+
+// S3Entry holds the configuration of the S3 (MinIO) service. The service is
+// available up to TrueNAS SCALE 24.10.
+type S3Entry struct {
+	ID           int    `json:"id"`
+	BindIP       string `json:"bindip"`
+	BindPort     int    `json:"bindport"`
+	StoragePath  string `json:"storage_path"`
+	TLSServerURI string `json:"tls_server_uri"`
+	// Certificate is the ID of the certificate the service uses for TLS, nil
+	// if it serves plain HTTP.
+	Certificate *int `json:"certificate"`
+}
+
+// S3UpdateParams holds parameters for updating the S3 service configuration.
+type S3UpdateParams struct {
+	Certificate *int `json:"certificate,omitempty"`
+	// TLSServerURI is the name clients reach the service with, it must be
+	// covered by the certificate.
+	TLSServerURI *string `json:"tls_server_uri,omitempty"`
+}
+
+// S3Config returns the S3 service configuration.
+func (c *Client) S3Config(ctx context.Context) (*S3Entry, error) {
+	var result *S3Entry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.S3Config(ctx)
+		return err
+	})
+	return result, err
+}
+
+// S3Update updates the S3 service configuration.
+func (c *Client) S3Update(ctx context.Context, params S3UpdateParams) (*S3Entry, error) {
+	var result *S3Entry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.S3Update(ctx, params)
+		return err
+	})
+	return result, err
+}
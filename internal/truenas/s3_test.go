@@ -0,0 +1,58 @@
+package truenas
+
+import "testing"
+
+func TestClient_S3Update(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	imported, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t))
+	if err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+
+	// TrueNAS needs the name clients use along with the certificate
+	if _, err := client.S3Update(t.Context(), S3UpdateParams{Certificate: &imported.ID}); err == nil {
+		t.Error("S3Update() without tls_server_uri succeeded")
+	}
+
+	uri := "s3.example.com"
+	entry, err := client.S3Update(t.Context(), S3UpdateParams{Certificate: &imported.ID, TLSServerURI: &uri})
+	if err != nil {
+		t.Fatalf("S3Update() error = %v", err)
+	}
+	if entry.Certificate == nil || *entry.Certificate != imported.ID || entry.TLSServerURI != uri {
+		t.Errorf("S3Update() = %+v, want certificate %d and tls_server_uri %s", entry, imported.ID, uri)
+	}
+
+	config, err := client.S3Config(t.Context())
+	if err != nil {
+		t.Fatalf("S3Config() error = %v", err)
+	}
+	if config.Certificate == nil || *config.Certificate != imported.ID {
+		t.Errorf("S3Config() certificate = %v, want %d", config.Certificate, imported.ID)
+	}
+	if got := srv.ServiceCertificate("s3"); got != imported.ID {
+		t.Errorf("s3 certificate = %d, want %d", got, imported.ID)
+	}
+}
+
+func TestIsMethodNotFound(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	srv.DisableMethods("s3.config")
+
+	_, err := client.S3Config(t.Context())
+	if !IsMethodNotFound(err) {
+		t.Errorf("IsMethodNotFound(%v) = false, want true", err)
+	}
+
+	_, err = client.S3Update(t.Context(), S3UpdateParams{})
+	if err != nil {
+		t.Fatalf("S3Update() error = %v", err)
+	}
+	if err := client.CertificateDelete(t.Context(), 42); IsMethodNotFound(err) {
+		t.Errorf("IsMethodNotFound(%v) = true, want false", err)
+	}
+}
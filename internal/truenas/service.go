@@ -0,0 +1,75 @@
+package truenas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Service states reported by service.query.
+const (
+	ServiceRunning = "RUNNING"
+	ServiceStopped = "STOPPED"
+)
+
+// errServiceNotFound is returned when service.query has no entry for a service.
+var errServiceNotFound = errors.New("service not found")
+
+// Service describes a system service as returned by service.query.
+type Service struct {
+	ID      int    `json:"id"`
+	Service string `json:"service"`
+	Enable  bool   `json:"enable"`
+	State   string `json:"state"`
+}
+
+// Running reports whether the service is running.
+func (s *Service) Running() bool {
+	return s.State == ServiceRunning
+}
+
+// serviceQueryOptions are the query-options for service.query.
+type serviceQueryOptions struct {
+	Limit int `json:"limit,omitempty"`
+}
+
+// serviceControlOptions are the options of service.restart.
+type serviceControlOptions struct {
+	// Silent makes a service failing to start a successful job, so it is
+	// unset to get the failure reported.
+	Silent bool `json:"silent"`
+}
+
+// Service returns the state of the named service, e.g. "ftp".
+func (c *Client) Service(ctx context.Context, name string) (*Service, error) {
+	var services []Service
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		services, err = c.a.ServiceQuery(ctx, [][]any{{"service", "=", name}}, serviceQueryOptions{Limit: 1})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%w: %q", errServiceNotFound, name)
+	}
+	return &services[0], nil
+}
+
+// ServiceRestart restarts the named service and waits until it is back.
+func (c *Client) ServiceRestart(ctx context.Context, name string) error {
+	// service.restart is a job: it returns a job ID and completes once the
+	// service is restarted.
+	var jobID int
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		jobID, err = c.a.ServiceRestart(ctx, name, serviceControlOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, jobID)
+}
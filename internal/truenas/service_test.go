@@ -0,0 +1,48 @@
+package truenas
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+func TestClient_Service(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	srv.SetServiceState("s3", truenastest.ServiceRunning)
+
+	svc, err := client.Service(t.Context(), "s3")
+	if err != nil {
+		t.Fatalf("Service() error = %v", err)
+	}
+	if svc.Service != "s3" || !svc.Running() {
+		t.Errorf("Service() = %+v, want running s3", svc)
+	}
+
+	if _, err := client.Service(t.Context(), "unknown"); !errors.Is(err, errServiceNotFound) {
+		t.Errorf("Service() error = %v, want %v", err, errServiceNotFound)
+	}
+}
+
+func TestClient_ServiceRestart(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+
+	if err := client.ServiceRestart(t.Context(), "s3"); err != nil {
+		t.Fatalf("ServiceRestart() error = %v", err)
+	}
+	if got := srv.ServiceRestarts("s3"); got != 1 {
+		t.Errorf("restarts = %d, want 1", got)
+	}
+	if got := srv.ServiceState("s3"); got != truenastest.ServiceRunning {
+		t.Errorf("state = %s, want %s", got, truenastest.ServiceRunning)
+	}
+
+	srv.FailServiceRestarts("s3")
+	if err := client.ServiceRestart(t.Context(), "s3"); !errors.Is(err, errJobFailed) {
+		t.Errorf("ServiceRestart() error = %v, want %v", err, errJobFailed)
+	}
+}
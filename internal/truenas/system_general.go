@@ -2,13 +2,21 @@ package truenas
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
-
-	jsonrpc "github.com/filecoin-project/go-jsonrpc"
 )
 
+// ErrUICertificateMismatch is returned when the UI does not present the
+// certificate it was switched to.
+var ErrUICertificateMismatch = errors.New("ui presents a different certificate")
+
 // SystemGeneralEntry holds the system general configuration.
 type SystemGeneralEntry struct {
 	ID            int          `json:"id"`
@@ -21,6 +29,10 @@ type SystemGeneralUpdateParams struct {
 	UICertificate   *int `json:"ui_certificate,omitempty"`
 	UIRestartDelay  *int `json:"ui_restart_delay,omitempty"`
 	RollbackTimeout *int `json:"rollback_timeout,omitempty"`
+
+	// Verify is called once the UI is back after the restart. If it fails,
+	// the change is not confirmed, so TrueNAS rolls it back.
+	Verify func(ctx context.Context) error `json:"-"`
 }
 
 // SystemGeneralConfig returns the system general configuration.
@@ -79,18 +91,22 @@ func (c *Client) SystemGeneralUpdate(ctx context.Context, params SystemGeneralUp
 	}
 
 	if err := c.reconnectWithBackoff(ctx); err != nil {
-		return fmt.Errorf("reconnect after ui restart: %w", err)
+		return fmt.Errorf("%w after ui restart: %w", errReconnectFailed, err)
+	}
+	if params.Verify != nil {
+		if err := params.Verify(ctx); err != nil {
+			return fmt.Errorf("verifying ui, skipping checkin to roll back: %w", err)
+		}
 	}
 
 	// Confirm the change. A transient drop right after reconnect can occur, so
 	// reconnect once more and retry on a connection error.
 	if err := c.a.SystemGeneralCheckin(ctx); err != nil {
-		var connErr *jsonrpc.RPCConnectionError
-		if !errors.As(err, &connErr) {
+		if !isConnectionError(err) {
 			return fmt.Errorf("system.general.checkin: %w", err)
 		}
 		if rerr := c.reconnectWithBackoff(ctx); rerr != nil {
-			return fmt.Errorf("reconnect before checkin: %w", rerr)
+			return fmt.Errorf("%w before checkin: %w", errReconnectFailed, rerr)
 		}
 		if err := c.a.SystemGeneralCheckin(ctx); err != nil {
 			return fmt.Errorf("system.general.checkin: %w", err)
@@ -99,3 +115,57 @@ func (c *Client) SystemGeneralUpdate(ctx context.Context, params SystemGeneralUp
 
 	return nil
 }
+
+// probeRetryInterval is the delay between attempts to reach the UI.
+const probeRetryInterval = 500 * time.Millisecond
+
+// ProbeUICertificate connects to the UI at the HTTPS port on the host of the
+// API and checks it presents want. Connection failures, e.g. while the UI is
+// still starting, are retried until ctx is done.
+func (c *Client) ProbeUICertificate(ctx context.Context, port int, want *x509.Certificate) error {
+	addr := net.JoinHostPort(c.host(), strconv.Itoa(port))
+	dialer := &tls.Dialer{Config: &tls.Config{
+		//nolint:gosec // the presented certificate is compared with want instead.
+		InsecureSkipVerify: true,
+	}}
+	// send a name of the certificate, in case the UI selects it by SNI
+	for _, name := range want.DNSNames {
+		if !strings.HasPrefix(name, "*.") {
+			dialer.Config.ServerName = name
+			break
+		}
+	}
+
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			state := conn.(*tls.Conn).ConnectionState()
+			conn.Close()
+			if !state.PeerCertificates[0].Equal(want) {
+				return fmt.Errorf("%w: %s presents serial %s, want %s", ErrUICertificateMismatch, addr,
+					state.PeerCertificates[0].SerialNumber.Text(16), want.SerialNumber.Text(16))
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("connecting to ui at %s: %w (last error: %v)", addr, ctx.Err(), err)
+		case <-time.After(probeRetryInterval):
+		}
+	}
+}
+
+// host returns the host of the API URL.
+func (c *Client) host() string {
+	cfg := newConfig(c.opts)
+	if cfg.url != nil {
+		return cfg.url.Hostname()
+	}
+
+	u, err := url.Parse(DefaultURL)
+	if err != nil {
+		return "localhost"
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,180 @@
+package truenas
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thde/truenas-scale-acme/internal/truenas/truenastest"
+)
+
+// importUICertificates imports two certificates and makes the first one the UI
+// certificate.
+func importUICertificates(t *testing.T, client *Client, srv *truenastest.Server) (current, next int) {
+	t.Helper()
+
+	ids := make([]int, 0, 2)
+	for _, name := range []string{"current", "next"} {
+		imported, err := client.CertificateImport(t.Context(), name, generateSelfSignedCert(t))
+		if err != nil {
+			t.Fatalf("CertificateImport() error = %v", err)
+		}
+		ids = append(ids, imported.ID)
+	}
+	srv.SetUICertificate(ids[0])
+
+	return ids[0], ids[1]
+}
+
+func TestClient_SystemGeneralUpdate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		drops []string
+	}{
+		{"restart", nil},
+		{"checkin dropped", []string{"system.general.checkin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, srv := newTestClient(t)
+			_, next := importUICertificates(t, client, srv)
+			for _, method := range tt.drops {
+				srv.DropNext(method, 1)
+			}
+
+			// with no delay, the restart could drop the connection before the
+			// response to the update is handled
+			restartDelay, rollbackTimeout := 1, 10
+			err := client.SystemGeneralUpdate(t.Context(), SystemGeneralUpdateParams{
+				UICertificate:   &next,
+				UIRestartDelay:  &restartDelay,
+				RollbackTimeout: &rollbackTimeout,
+			})
+			if err != nil {
+				t.Fatalf("SystemGeneralUpdate() error = %v", err)
+			}
+
+			if got := srv.UICertificate(); got != next {
+				t.Errorf("ui certificate = %d, want %d", got, next)
+			}
+			if got := srv.Checkins(); got != 1 {
+				t.Errorf("checkins = %d, want 1", got)
+			}
+			if got := srv.Restarts(); got != 1 {
+				t.Errorf("restarts = %d, want 1", got)
+			}
+
+			settings, err := client.SystemGeneralConfig(t.Context())
+			if err != nil {
+				t.Fatalf("SystemGeneralConfig() error = %v", err)
+			}
+			if settings.UICertificate == nil || settings.UICertificate.ID != next {
+				t.Errorf("SystemGeneralConfig() ui certificate = %+v, want %d", settings.UICertificate, next)
+			}
+		})
+	}
+}
+
+func TestClient_SystemGeneralUpdate_rollback(t *testing.T) {
+	t.Parallel()
+
+	// the UI does not come back before the rollback timeout
+	client, srv := newTestClient(t, truenastest.WithRestartDuration(3*time.Second))
+	current, next := importUICertificates(t, client, srv)
+
+	restartDelay, rollbackTimeout := 1, 2
+	err := client.SystemGeneralUpdate(t.Context(), SystemGeneralUpdateParams{
+		UICertificate:   &next,
+		UIRestartDelay:  &restartDelay,
+		RollbackTimeout: &rollbackTimeout,
+	})
+	if err == nil {
+		t.Fatal("SystemGeneralUpdate() succeeded without checkin")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Rollbacks() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := srv.UICertificate(); got != current {
+		t.Errorf("ui certificate = %d, want rolled back to %d", got, current)
+	}
+	if got := srv.Checkins(); got != 0 {
+		t.Errorf("checkins = %d, want 0", got)
+	}
+}
+
+func TestClient_SystemGeneralUpdate_verify(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		frozen  bool
+		wantErr error
+	}{
+		{"presented", false, nil},
+		{"not presented", true, ErrUICertificateMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, srv := newTestClient(t)
+			current, next := importUICertificates(t, client, srv)
+			srv.ServeUI()
+			if tt.frozen {
+				srv.FreezeUI()
+			}
+			settings, err := client.SystemGeneralConfig(t.Context())
+			if err != nil {
+				t.Fatalf("SystemGeneralConfig() error = %v", err)
+			}
+			certs, err := client.Certificates(t.Context())
+			if err != nil {
+				t.Fatalf("Certificates() error = %v", err)
+			}
+			var want *x509.Certificate
+			for i := range certs {
+				if certs[i].ID == next {
+					want, _ = certs[i].Leaf()
+				}
+			}
+
+			restartDelay, rollbackTimeout := 1, 2
+			err = client.SystemGeneralUpdate(t.Context(), SystemGeneralUpdateParams{
+				UICertificate:   &next,
+				UIRestartDelay:  &restartDelay,
+				RollbackTimeout: &rollbackTimeout,
+				Verify: func(ctx context.Context) error {
+					return client.ProbeUICertificate(ctx, settings.UIHTTPSPort, want)
+				},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SystemGeneralUpdate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if got := srv.Checkins(); got != 1 {
+					t.Errorf("checkins = %d, want 1", got)
+				}
+				return
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for srv.Rollbacks() == 0 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if got := srv.UICertificate(); got != current {
+				t.Errorf("ui certificate = %d, want rolled back to %d", got, current)
+			}
+			if got := srv.Checkins(); got != 0 {
+				t.Errorf("checkins = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package truenastest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Mail is a message sent with mail.send.
+type Mail struct {
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	To      []string `json:"to,omitempty"`
+}
+
+// Alerts returns the raised one-shot alerts by class, with their arguments.
+func (s *Server) Alerts() map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.alerts)
+}
+
+// Mails returns the messages sent so far.
+func (s *Server) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Mail(nil), s.mails...)
+}
+
+// FailMails makes mail.send jobs fail, like a mail service that is not
+// configured.
+func (s *Server) FailMails() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failMails = true
+}
+
+// oneshotClasses are the one-shot alert classes the server knows, with the
+// arguments their text is formatted with. The alerts are keyed by the id
+// argument, which alert.oneshot_delete takes as query.
+var oneshotClasses = map[string][]string{
+	"ReplicationFailed":   {"id", "name", "message"},
+	"CloudSyncTaskFailed": {"id", "name"},
+}
+
+func (s *Server) alertList() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := []map[string]any{}
+	for _, class := range slices.Sorted(maps.Keys(s.alerts)) {
+		alerts = append(alerts, map[string]any{"uuid": class, "klass": class, "args": s.alerts[class], "dismissed": false})
+	}
+
+	return alerts, nil
+}
+
+func (s *Server) alertOneshotCreate(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	var class string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 || json.Unmarshal(args[0], &class) != nil {
+		return nil, fmt.Errorf("%w: expected [klass, args]", errInvalidParams)
+	}
+	keys, ok := oneshotClasses[class]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown alert class %q", errInvalidParams, class)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(args[1], &fields); err != nil {
+		return nil, fmt.Errorf("%w: %s args must be a dict", errInvalidParams, class)
+	}
+	for _, key := range keys {
+		if _, ok := fields[key]; !ok {
+			return nil, fmt.Errorf("%w: %s args lack %q", errInvalidParams, class, key)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.alerts[class] = args[1]
+	return nil, nil
+}
+
+func (s *Server) alertOneshotDelete(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	var class string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 || json.Unmarshal(args[0], &class) != nil {
+		return nil, fmt.Errorf("%w: expected [klass, query]", errInvalidParams)
+	}
+	if _, ok := oneshotClasses[class]; !ok {
+		return nil, fmt.Errorf("%w: unknown alert class %q", errInvalidParams, class)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// like the classes keyed by id, the query is compared with the id the
+	// alert was raised with
+	var raised struct {
+		ID any `json:"id"`
+	}
+	var query any
+	if json.Unmarshal(s.alerts[class], &raised) == nil && json.Unmarshal(args[1], &query) == nil && fmt.Sprint(raised.ID) == fmt.Sprint(query) {
+		delete(s.alerts, class)
+	}
+	return nil, nil
+}
+
+func (s *Server) mailSend(params json.RawMessage) (any, error) {
+	var args []Mail
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected [message]", errInvalidParams)
+	}
+
+	return s.startJob("mail.send", func() error {
+		if s.failMails {
+			return errMailNotConfigured
+		}
+		s.mails = append(s.mails, args[0])
+		return nil
+	}), nil
+}
@@ -0,0 +1,188 @@
+package truenastest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// App states reported by app.query.
+const (
+	AppRunning = "RUNNING"
+	AppStopped = "STOPPED"
+)
+
+// appCertificateKey is the key catalog apps reference a certificate with in
+// their values.
+const appCertificateKey = "certificate_id"
+
+// app is an installed app with its values.
+type app struct {
+	state   string
+	config  map[string]any
+	updates int
+}
+
+// AddApp installs a running app with the given values.
+func (s *Server) AddApp(name string, values map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apps[name] = &app{state: AppRunning, config: cloneValues(values)}
+}
+
+// AppValues returns the values of the named app.
+func (s *Server) AppValues(name string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return cloneValues(s.apps[name].config)
+}
+
+// AppUpdates returns how often the named app was updated.
+func (s *Server) AppUpdates(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apps[name].updates
+}
+
+// appEntry is an app as TrueNAS encodes it.
+type appEntry struct {
+	ID     string         `json:"id"`
+	Name   string         `json:"name"`
+	State  string         `json:"state"`
+	Config map[string]any `json:"config,omitempty"`
+}
+
+func (s *Server) appQuery(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("%w: expected [filters, options]", errInvalidParams)
+	}
+	var filters [][]any
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &filters); err != nil {
+			return nil, fmt.Errorf("%w: filters: %w", errInvalidParams, err)
+		}
+	}
+	var options struct {
+		Extra struct {
+			RetrieveConfig bool `json:"retrieve_config"`
+		} `json:"extra"`
+		Limit int `json:"limit"`
+	}
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &options); err != nil {
+			return nil, fmt.Errorf("%w: options: %w", errInvalidParams, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []appEntry{}
+	for _, name := range slices.Sorted(maps.Keys(s.apps)) {
+		a := s.apps[name]
+		if !matches(map[string]any{"id": name, "name": name, "state": a.state}, filters) {
+			continue
+		}
+		entry := appEntry{ID: name, Name: name, State: a.state}
+		if options.Extra.RetrieveConfig {
+			entry.Config = cloneValues(a.config)
+		}
+		entries = append(entries, entry)
+	}
+	if options.Limit > 0 && len(entries) > options.Limit {
+		entries = entries[:options.Limit]
+	}
+
+	return entries, nil
+}
+
+// appUpdate merges the values of the update into the values of the app in a
+// job. Certificates referenced by the values must exist.
+func (s *Server) appUpdate(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 2 {
+		return nil, fmt.Errorf("%w: expected [app_name, data]", errInvalidParams)
+	}
+	var name string
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return nil, fmt.Errorf("%w: app_name: %w", errInvalidParams, err)
+	}
+	var data struct {
+		Values map[string]any `json:"values"`
+	}
+	if err := json.Unmarshal(args[1], &data); err != nil {
+		return nil, fmt.Errorf("%w: data: %w", errInvalidParams, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[name]
+	if !ok {
+		return nil, fmt.Errorf("app %q %w", name, errNotFound)
+	}
+
+	return s.startJob("app.update", func() error {
+		config := mergeValues(cloneValues(a.config), data.Values)
+		for _, id := range appCertificates(config) {
+			if !slices.ContainsFunc(s.certs, func(c Certificate) bool { return c.ID == id }) {
+				return fmt.Errorf("app_update.values.%s: certificate %d %w", appCertificateKey, id, errNotFound)
+			}
+		}
+		a.config = config
+		a.updates++
+		return nil
+	}), nil
+}
+
+// appCertificates returns the IDs of the certificates referenced by values.
+func appCertificates(values map[string]any) []int {
+	var ids []int
+	for key, value := range values {
+		switch v := value.(type) {
+		case map[string]any:
+			ids = append(ids, appCertificates(v)...)
+		case float64:
+			if key == appCertificateKey {
+				ids = append(ids, int(v))
+			}
+		}
+	}
+
+	return ids
+}
+
+// mergeValues merges src into dst recursively and returns dst.
+func mergeValues(dst, src map[string]any) map[string]any {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]any); ok {
+			if dstMap, ok := dst[key].(map[string]any); ok {
+				dst[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+
+	return dst
+}
+
+// cloneValues returns a deep copy of values as JSON decodes it, so numbers
+// are float64.
+func cloneValues(values map[string]any) map[string]any {
+	b, err := json.Marshal(values)
+	if err != nil {
+		panic(fmt.Sprintf("truenastest: encoding app values: %v", err))
+	}
+	clone := map[string]any{}
+	if err := json.Unmarshal(b, &clone); err != nil {
+		panic(fmt.Sprintf("truenastest: decoding app values: %v", err))
+	}
+
+	return clone
+}
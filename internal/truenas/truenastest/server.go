@@ -0,0 +1,782 @@
+// Package truenastest provides an in-process TrueNAS WebSocket JSON-RPC 2.0
+// server for tests.
+//
+// The server implements the subset of the API the client uses, with the
+// semantics that matter to it: certificate.create and certificate.delete run
+// as jobs polled through core.get_jobs, and changing the UI certificate
+// restarts the UI, dropping every connection, and is rolled back unless
+// system.general.checkin is called within the rollback timeout. With
+// [Server.ServeUI], the UI presents its certificate on an HTTPS port. Services
+// referencing a certificate, such as S3, are configured through their
+// <service>.config and <service>.update methods and restarted with the
+// service.restart job. Apps reference certificates by a certificate_id in
+// their values, which app.update changes in a job. One-shot alerts of the
+// classes the server knows are raised and cleared with alert.oneshot_create
+// and alert.oneshot_delete and listed by alert.list, and the messages of
+// mail.send are recorded in a job.
+package truenastest
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultAPIKey is the API key accepted unless [WithAPIKey] is given.
+const DefaultAPIKey = "truenastest-api-key"
+
+// timeLayout is the time format TrueNAS uses for certificate dates.
+const timeLayout = "Mon Jan _2 15:04:05 2006"
+
+// Job states reported by core.get_jobs.
+const (
+	JobRunning = "RUNNING"
+	JobSuccess = "SUCCESS"
+	JobFailed  = "FAILED"
+)
+
+// JSON-RPC error codes of failed method calls.
+const (
+	// errorCode is the code TrueNAS uses for failed method calls.
+	errorCode = -32001
+	// methodNotFoundCode is the code for unknown methods.
+	methodNotFoundCode = -32601
+)
+
+var (
+	errNotAuthenticated  = errors.New("not authenticated")
+	errMethodNotFound    = errors.New("method not found")
+	errInvalidParams     = errors.New("invalid params")
+	errNotFound          = errors.New("does not exist")
+	errInUse             = errors.New("certificate is in use")
+	errMailNotConfigured = errors.New("mail is not configured")
+)
+
+// Certificate is a certificate entry held by the server.
+type Certificate struct {
+	ID          int
+	Name        string
+	Certificate string
+	PrivateKey  string
+}
+
+// Job is a job as reported by core.get_jobs.
+type Job struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server is an in-process TrueNAS API server. Its zero value is not usable,
+// create one with [NewServer].
+type Server struct {
+	// URL is the WebSocket URL of the API.
+	URL *url.URL
+
+	apiKey          string
+	jobDuration     time.Duration
+	restartDuration time.Duration
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	conns       map[*websocket.Conn]bool
+	unavailable bool
+	drops       map[string]int
+	calls       map[string]int
+	disabled    map[string]bool
+
+	certs       []Certificate
+	jobs        []*Job
+	nextCertID  int
+	nextJobID   int
+	uiCertID    int
+	uiHTTPSPort int
+
+	// uiSrv serves the UI certificate servedUICertID, see ServeUI.
+	uiSrv          *httptest.Server
+	servedUICertID int
+	uiFrozen       bool
+
+	rollback  *time.Timer
+	checkins  int
+	restarts  int
+	rollbacks int
+
+	services map[string]*service
+	apps     map[string]*app
+
+	alerts    map[string]json.RawMessage
+	mails     []Mail
+	failMails bool
+}
+
+// Option configures a [Server].
+type Option func(*Server)
+
+// WithAPIKey sets the API key auth.login_with_api_key accepts.
+func WithAPIKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithJobDuration sets how long jobs stay running before they complete.
+func WithJobDuration(d time.Duration) Option {
+	return func(s *Server) {
+		s.jobDuration = d
+	}
+}
+
+// WithRestartDuration sets how long the UI refuses connections while it
+// restarts.
+func WithRestartDuration(d time.Duration) Option {
+	return func(s *Server) {
+		s.restartDuration = d
+	}
+}
+
+// NewServer starts a server. It must be closed with [Server.Close].
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		apiKey:          DefaultAPIKey,
+		restartDuration: 100 * time.Millisecond,
+		conns:           map[*websocket.Conn]bool{},
+		drops:           map[string]int{},
+		calls:           map[string]int{},
+		disabled:        map[string]bool{},
+		services:        newServices(),
+		apps:            map[string]*app{},
+		alerts:          map[string]json.RawMessage{},
+		nextCertID:      1,
+		nextJobID:       1,
+		uiHTTPSPort:     443,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = &url.URL{Scheme: "ws", Host: s.srv.Listener.Addr().String(), Path: "/api/current"}
+
+	return s
+}
+
+// Close drops every connection and shuts the server down.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.rollback != nil {
+		s.rollback.Stop()
+	}
+	s.mu.Unlock()
+
+	s.DropConnections()
+	s.srv.Close()
+	if s.uiSrv != nil {
+		s.uiSrv.Close()
+	}
+}
+
+// DropConnections closes every open connection, as a restarting UI or a
+// network failure would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropConnections()
+}
+
+func (s *Server) dropConnections() {
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// DropNext makes the next n calls of method drop their connection instead of
+// answering.
+func (s *Server) DropNext(method string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drops[method] += n
+}
+
+// DisableMethods makes the server answer calls of methods as unknown, like a
+// TrueNAS version without them would.
+func (s *Server) DisableMethods(methods ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, method := range methods {
+		s.disabled[method] = true
+	}
+}
+
+// SetUnavailable makes the server refuse new connections while unavailable is
+// set.
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unavailable = unavailable
+}
+
+// Calls returns how often method was called.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+// Checkins returns how often system.general.checkin confirmed a change.
+func (s *Server) Checkins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkins
+}
+
+// Restarts returns how often the UI was restarted.
+func (s *Server) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.restarts
+}
+
+// Rollbacks returns how often an unconfirmed change was rolled back.
+func (s *Server) Rollbacks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rollbacks
+}
+
+// AddCertificate adds a certificate entry from PEM encoded data and returns
+// its ID.
+func (s *Server) AddCertificate(name, certPEM, keyPEM string) (int, error) {
+	if _, err := parseLeaf(certPEM); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addCertificate(name, certPEM, keyPEM), nil
+}
+
+func (s *Server) addCertificate(name, certPEM, keyPEM string) int {
+	id := s.nextCertID
+	s.nextCertID++
+	s.certs = append(s.certs, Certificate{ID: id, Name: name, Certificate: certPEM, PrivateKey: keyPEM})
+
+	return id
+}
+
+// Certificates returns the certificate entries.
+func (s *Server) Certificates() []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.certs)
+}
+
+// SetUICertificate sets the UI certificate without restarting the UI, as if
+// it was loaded at boot. An ID of 0 unsets it.
+func (s *Server) SetUICertificate(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uiCertID = id
+	s.reloadUI()
+}
+
+// UICertificate returns the ID of the UI certificate, 0 if none is set.
+func (s *Server) UICertificate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.uiCertID
+}
+
+type request struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	unavailable := s.unavailable
+	s.mu.Unlock()
+	if unavailable {
+		http.Error(w, "ui restarting", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	s.serveConn(conn)
+}
+
+// serveConn answers the requests of one connection in order until it is
+// closed.
+func (s *Server) serveConn(conn *websocket.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	authenticated := false
+	for {
+		var req request
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		// notifications, e.g. xrpc.cancel, are not answered
+		if len(req.ID) == 0 || strings.HasPrefix(req.Method, "xrpc.") {
+			continue
+		}
+
+		s.mu.Lock()
+		s.calls[req.Method]++
+		drop := s.drops[req.Method] > 0
+		if drop {
+			s.drops[req.Method]--
+		}
+		disabled := s.disabled[req.Method]
+		s.mu.Unlock()
+		if drop {
+			return
+		}
+
+		resp := response{JSONRPC: "2.0", ID: req.ID}
+		var (
+			result any
+			after  func()
+			err    error
+		)
+		switch {
+		case disabled:
+			err = fmt.Errorf("%w: %s", errMethodNotFound, req.Method)
+		case req.Method == "auth.login_with_api_key":
+			result, err = s.login(req.Params)
+			authenticated = result == true
+		case !authenticated:
+			err = errNotAuthenticated
+		case req.Method == "system.general.update":
+			result, after, err = s.generalUpdate(req.Params)
+		default:
+			result, err = s.call(req.Method, req.Params)
+		}
+		switch {
+		case errors.Is(err, errMethodNotFound):
+			resp.Error = &rpcError{Code: methodNotFoundCode, Message: err.Error()}
+		case err != nil:
+			resp.Error = &rpcError{Code: errorCode, Message: err.Error()}
+		default:
+			resp.Result = result
+		}
+
+		if err := conn.WriteJSON(resp); err != nil {
+			return
+		}
+		if after != nil {
+			after()
+		}
+	}
+}
+
+func (s *Server) call(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "system.info":
+		return map[string]string{"version": "TrueNAS-25.10.0", "hostname": "truenas"}, nil
+	case "certificate.query":
+		return s.certificateQuery()
+	case "certificate.create":
+		return s.certificateCreate(params)
+	case "certificate.delete":
+		return s.certificateDelete(params)
+	case "core.get_jobs":
+		return s.getJobs(params)
+	case "system.general.config":
+		return s.generalConfig()
+	case "system.general.checkin":
+		return s.checkin()
+	case "service.query":
+		return s.serviceQuery(params)
+	case "service.restart":
+		return s.serviceRestart(params)
+	case "app.query":
+		return s.appQuery(params)
+	case "app.update":
+		return s.appUpdate(params)
+	case "alert.list":
+		return s.alertList()
+	case "alert.oneshot_create":
+		return s.alertOneshotCreate(params)
+	case "alert.oneshot_delete":
+		return s.alertOneshotDelete(params)
+	case "mail.send":
+		return s.mailSend(params)
+	}
+
+	if name, op, ok := strings.Cut(method, "."); ok && serviceCertificateFields[name] != "" {
+		switch op {
+		case "config":
+			return s.serviceConfig(name)
+		case "update":
+			return s.serviceUpdate(name, params)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", errMethodNotFound, method)
+}
+
+func (s *Server) login(params json.RawMessage) (any, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected [api_key]", errInvalidParams)
+	}
+
+	return args[0] == s.apiKey, nil
+}
+
+// certificateEntry is a certificate as TrueNAS encodes it.
+type certificateEntry struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Common      string   `json:"common"`
+	Certificate string   `json:"certificate"`
+	Privatekey  string   `json:"privatekey"`
+	Expired     bool     `json:"expired"`
+	From        string   `json:"from"`
+	Until       string   `json:"until"`
+	SAN         []string `json:"san"`
+}
+
+func newCertificateEntry(c Certificate) certificateEntry {
+	entry := certificateEntry{ID: c.ID, Name: c.Name, Certificate: c.Certificate, Privatekey: c.PrivateKey, SAN: []string{}}
+
+	leaf, err := parseLeaf(c.Certificate)
+	if err != nil {
+		return entry
+	}
+	entry.Common = leaf.Subject.CommonName
+	entry.Expired = time.Now().After(leaf.NotAfter)
+	entry.From = leaf.NotBefore.UTC().Format(timeLayout)
+	entry.Until = leaf.NotAfter.UTC().Format(timeLayout)
+	for _, name := range leaf.DNSNames {
+		entry.SAN = append(entry.SAN, "DNS:"+name)
+	}
+
+	return entry
+}
+
+func (s *Server) certificateQuery() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]certificateEntry, 0, len(s.certs))
+	for _, c := range s.certs {
+		entries = append(entries, newCertificateEntry(c))
+	}
+
+	return entries, nil
+}
+
+func (s *Server) certificateCreate(params json.RawMessage) (any, error) {
+	var args []struct {
+		Name        string `json:"name"`
+		CreateType  string `json:"create_type"`
+		Certificate string `json:"certificate"`
+		Privatekey  string `json:"privatekey"`
+	}
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected [data]", errInvalidParams)
+	}
+	data := args[0]
+	if data.CreateType != "CERTIFICATE_CREATE_IMPORTED" {
+		return nil, fmt.Errorf("%w: unsupported create_type %q", errInvalidParams, data.CreateType)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data.Name == "" || slices.ContainsFunc(s.certs, func(c Certificate) bool { return c.Name == data.Name }) {
+		return nil, fmt.Errorf("%w: name %q is empty or already in use", errInvalidParams, data.Name)
+	}
+
+	return s.startJob("certificate.create", func() error {
+		if _, err := parseLeaf(data.Certificate); err != nil {
+			return err
+		}
+		s.addCertificate(data.Name, data.Certificate, data.Privatekey)
+		return nil
+	}), nil
+}
+
+func (s *Server) certificateDelete(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) < 1 {
+		return nil, fmt.Errorf("%w: expected [id, force]", errInvalidParams)
+	}
+	var id int
+	if err := json.Unmarshal(args[0], &id); err != nil {
+		return nil, fmt.Errorf("%w: id: %w", errInvalidParams, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.certs, func(c Certificate) bool { return c.ID == id })
+	if i < 0 {
+		return nil, fmt.Errorf("certificate %d %w", id, errNotFound)
+	}
+	if s.certificateInUse(id) {
+		return nil, fmt.Errorf("certificate %d: %w", id, errInUse)
+	}
+
+	return s.startJob("certificate.delete", func() error {
+		s.certs = slices.DeleteFunc(s.certs, func(c Certificate) bool { return c.ID == id })
+		return nil
+	}), nil
+}
+
+// startJob registers a running job that calls run once the job duration has
+// passed. Must be called with s.mu held, run is called with s.mu held.
+func (s *Server) startJob(method string, run func() error) int {
+	job := &Job{ID: s.nextJobID, Method: method, State: JobRunning}
+	s.nextJobID++
+	s.jobs = append(s.jobs, job)
+
+	time.AfterFunc(s.jobDuration, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := run(); err != nil {
+			job.State, job.Error = JobFailed, err.Error()
+			return
+		}
+		job.State = JobSuccess
+	})
+
+	return job.ID
+}
+
+// Jobs returns every job started so far.
+func (s *Server) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	return jobs
+}
+
+func (s *Server) getJobs(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("%w: expected [filters, options]", errInvalidParams)
+	}
+	var filters [][]any
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &filters); err != nil {
+			return nil, fmt.Errorf("%w: filters: %w", errInvalidParams, err)
+		}
+	}
+	var options struct {
+		Limit int `json:"limit"`
+	}
+	if len(args) > 1 {
+		if err := json.Unmarshal(args[1], &options); err != nil {
+			return nil, fmt.Errorf("%w: options: %w", errInvalidParams, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		fields := map[string]any{"id": float64(job.ID), "method": job.Method, "state": job.State}
+		if matches(fields, filters) {
+			jobs = append(jobs, *job)
+		}
+	}
+	if options.Limit > 0 && len(jobs) > options.Limit {
+		jobs = jobs[:options.Limit]
+	}
+
+	return jobs, nil
+}
+
+// matches reports whether fields match every ["field", "=", value] filter.
+func matches(fields map[string]any, filters [][]any) bool {
+	for _, f := range filters {
+		if len(f) != 3 || f[1] != "=" {
+			return false
+		}
+		name, _ := f[0].(string)
+		if fields[name] != f[2] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// generalEntry is the system general configuration as TrueNAS encodes it.
+type generalEntry struct {
+	ID            int               `json:"id"`
+	UICertificate *certificateEntry `json:"ui_certificate"`
+	UIHTTPSPort   int               `json:"ui_httpsport"`
+}
+
+func (s *Server) generalConfig() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.generalEntry(), nil
+}
+
+func (s *Server) generalEntry() generalEntry {
+	entry := generalEntry{ID: 1, UIHTTPSPort: s.uiHTTPSPort}
+	if i := slices.IndexFunc(s.certs, func(c Certificate) bool { return c.ID == s.uiCertID }); i >= 0 {
+		c := newCertificateEntry(s.certs[i])
+		entry.UICertificate = &c
+	}
+
+	return entry
+}
+
+// generalUpdate applies the update and, if the UI certificate changed, returns
+// a function restarting the UI ui_restart_delay seconds after the response is
+// sent. With a rollback_timeout, the previous certificate is restored unless
+// the change is confirmed in time.
+func (s *Server) generalUpdate(params json.RawMessage) (any, func(), error) {
+	var args []struct {
+		UICertificate   *int `json:"ui_certificate"`
+		UIRestartDelay  *int `json:"ui_restart_delay"`
+		RollbackTimeout *int `json:"rollback_timeout"`
+	}
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, nil, fmt.Errorf("%w: expected [data]", errInvalidParams)
+	}
+	data := args[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data.UICertificate == nil || *data.UICertificate == s.uiCertID {
+		return s.generalEntry(), nil, nil
+	}
+	if !slices.ContainsFunc(s.certs, func(c Certificate) bool { return c.ID == *data.UICertificate }) {
+		return nil, nil, fmt.Errorf("ui_certificate %d %w", *data.UICertificate, errNotFound)
+	}
+
+	previous := s.uiCertID
+	s.uiCertID = *data.UICertificate
+	entry := s.generalEntry()
+
+	delay := 3
+	if data.UIRestartDelay != nil {
+		delay = *data.UIRestartDelay
+	}
+	if data.RollbackTimeout != nil && *data.RollbackTimeout > 0 {
+		if s.rollback != nil {
+			s.rollback.Stop()
+		}
+		s.rollback = time.AfterFunc(time.Duration(*data.RollbackTimeout)*time.Second, func() {
+			s.mu.Lock()
+			s.uiCertID = previous
+			s.rollback = nil
+			s.rollbacks++
+			s.mu.Unlock()
+
+			s.restartUI()
+		})
+	}
+
+	return entry, func() { time.AfterFunc(time.Duration(delay)*time.Second, s.restartUI) }, nil
+}
+
+// restartUI drops every connection and refuses new ones for the restart
+// duration.
+func (s *Server) restartUI() {
+	s.mu.Lock()
+	s.restarts++
+	s.unavailable = true
+	s.reloadUI()
+	s.dropConnections()
+	s.mu.Unlock()
+
+	time.AfterFunc(s.restartDuration, func() {
+		s.SetUnavailable(false)
+	})
+}
+
+func (s *Server) checkin() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rollback != nil {
+		s.rollback.Stop()
+		s.rollback = nil
+		s.checkins++
+	}
+
+	return nil, nil
+}
+
+func parseLeaf(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%w: no PEM certificate", errInvalidParams)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidParams, err)
+	}
+
+	return leaf, nil
+}
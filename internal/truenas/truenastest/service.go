@@ -0,0 +1,269 @@
+package truenastest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Service states reported by service.query.
+const (
+	ServiceRunning = "RUNNING"
+	ServiceStopped = "STOPPED"
+)
+
+// serviceCertificateFields maps the services whose configuration references a
+// certificate, served as <service>.config and <service>.update, to the field
+// holding the certificate ID.
+var serviceCertificateFields = map[string]string{
+	"ftp":    "ssltls_certificate",
+	"s3":     "certificate",
+	"webdav": "certssl",
+}
+
+// serviceDefaults is the initial configuration of every service in
+// serviceCertificateFields.
+var serviceDefaults = map[string]map[string]any{
+	"ftp": {
+		"id":                 1,
+		"port":               21,
+		"tls":                false,
+		"ssltls_certificate": nil,
+	},
+	"s3": {
+		"id":             1,
+		"bindip":         "0.0.0.0",
+		"bindport":       9000,
+		"storage_path":   "/mnt/tank/s3",
+		"tls_server_uri": "",
+		"certificate":    nil,
+	},
+	"webdav": {
+		"id":         1,
+		"protocol":   "HTTP",
+		"tcpport":    8080,
+		"tcpportssl": 8081,
+		"certssl":    nil,
+	},
+}
+
+// service is a system service with its configuration.
+type service struct {
+	id           int
+	enable       bool
+	state        string
+	config       map[string]any
+	restarts     int
+	failRestarts bool
+}
+
+func newServices() map[string]*service {
+	services := map[string]*service{}
+	for i, name := range slices.Sorted(maps.Keys(serviceCertificateFields)) {
+		services[name] = &service{id: i + 1, state: ServiceStopped, config: maps.Clone(serviceDefaults[name])}
+	}
+
+	return services
+}
+
+// SetServiceState sets the state of the named service.
+func (s *Server) SetServiceState(name, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := s.services[name]
+	svc.state = state
+	svc.enable = state == ServiceRunning
+}
+
+// ServiceState returns the state of the named service.
+func (s *Server) ServiceState(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.services[name].state
+}
+
+// FailServiceRestarts makes restarts of the named service fail, leaving it
+// stopped.
+func (s *Server) FailServiceRestarts(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.services[name].failRestarts = true
+}
+
+// ServiceRestarts returns how often the named service was restarted.
+func (s *Server) ServiceRestarts(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.services[name].restarts
+}
+
+// ServiceCertificate returns the ID of the certificate the named service is
+// configured with, 0 if none is set.
+func (s *Server) ServiceCertificate(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := s.services[name].config[serviceCertificateFields[name]].(int)
+	return id
+}
+
+// SetServiceCertificate sets the certificate of the named service without
+// restarting it. An ID of 0 unsets it.
+func (s *Server) SetServiceCertificate(name string, id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var value any
+	if id != 0 {
+		value = id
+	}
+	s.services[name].config[serviceCertificateFields[name]] = value
+}
+
+// serviceEntry is a service as TrueNAS encodes it.
+type serviceEntry struct {
+	ID      int    `json:"id"`
+	Service string `json:"service"`
+	Enable  bool   `json:"enable"`
+	State   string `json:"state"`
+}
+
+func (s *Server) serviceQuery(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, fmt.Errorf("%w: expected [filters, options]", errInvalidParams)
+	}
+	var filters [][]any
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &filters); err != nil {
+			return nil, fmt.Errorf("%w: filters: %w", errInvalidParams, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []serviceEntry{}
+	for _, name := range slices.Sorted(maps.Keys(s.services)) {
+		svc := s.services[name]
+		fields := map[string]any{"id": float64(svc.id), "service": name, "state": svc.state}
+		if !matches(fields, filters) {
+			continue
+		}
+		entries = append(entries, serviceEntry{ID: svc.id, Service: name, Enable: svc.enable, State: svc.state})
+	}
+
+	return entries, nil
+}
+
+func (s *Server) serviceRestart(params json.RawMessage) (any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) < 1 {
+		return nil, fmt.Errorf("%w: expected [service, options]", errInvalidParams)
+	}
+	var name string
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return nil, fmt.Errorf("%w: service: %w", errInvalidParams, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc, ok := s.services[name]
+	if !ok {
+		return nil, fmt.Errorf("service %q %w", name, errNotFound)
+	}
+
+	return s.startJob("service.restart", func() error {
+		svc.restarts++
+		if svc.failRestarts {
+			svc.state = ServiceStopped
+			return fmt.Errorf("%s failed to start", name)
+		}
+		svc.state = ServiceRunning
+		return nil
+	}), nil
+}
+
+func (s *Server) serviceConfig(name string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.services[name].config), nil
+}
+
+// serviceUpdate applies the fields of the update to the configuration of the
+// named service. The certificate must exist and the configuration valid.
+func (s *Server) serviceUpdate(name string, params json.RawMessage) (any, error) {
+	var args []map[string]any
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected [data]", errInvalidParams)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := s.services[name]
+	config := maps.Clone(svc.config)
+	for field, value := range args[0] {
+		if _, ok := config[field]; !ok {
+			return nil, fmt.Errorf("%w: %s_update.%s: field not allowed", errInvalidParams, name, field)
+		}
+		if field == serviceCertificateFields[name] && value != nil {
+			id, ok := value.(float64)
+			if !ok || !slices.ContainsFunc(s.certs, func(c Certificate) bool { return c.ID == int(id) }) {
+				return nil, fmt.Errorf("%s_update.%s: certificate %v %w", name, field, value, errNotFound)
+			}
+			value = int(id)
+		}
+		config[field] = value
+	}
+	if err := validServiceConfig(name, config); err != nil {
+		return nil, err
+	}
+	svc.config = config
+
+	return maps.Clone(config), nil
+}
+
+// validServiceConfig checks the constraints TrueNAS puts on a service
+// configuration referencing a certificate.
+func validServiceConfig(name string, config map[string]any) error {
+	switch {
+	case name == "s3" && config["certificate"] != nil && config["tls_server_uri"] == "":
+		return fmt.Errorf("%w: s3_update.tls_server_uri: required with a certificate", errInvalidParams)
+	case name == "ftp" && config["tls"] == true && config["ssltls_certificate"] == nil:
+		return fmt.Errorf("%w: ftp_update.ssltls_certificate: required with tls", errInvalidParams)
+	case name == "webdav" && config["protocol"] != "HTTP" && config["certssl"] == nil:
+		return fmt.Errorf("%w: webdav_update.certssl: required with %v", errInvalidParams, config["protocol"])
+	case name == "webdav" && !slices.Contains([]any{"HTTP", "HTTPS", "HTTPHTTPS"}, config["protocol"]):
+		return fmt.Errorf("%w: webdav_update.protocol: invalid protocol %v", errInvalidParams, config["protocol"])
+	}
+
+	return nil
+}
+
+// certificateInUse reports whether the UI, a service or an app is configured
+// with the certificate. Must be called with s.mu held.
+func (s *Server) certificateInUse(id int) bool {
+	if s.uiCertID == id {
+		return true
+	}
+	for name, svc := range s.services {
+		if v, _ := svc.config[serviceCertificateFields[name]].(int); v == id {
+			return true
+		}
+	}
+	for _, a := range s.apps {
+		if slices.Contains(appCertificates(a.config), id) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package truenastest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+)
+
+// ServeUI serves HTTPS on a local port, which becomes ui_httpsport, like the
+// nginx of the UI. It presents the UI certificate loaded by the last UI
+// restart. The UI server is closed with the server.
+func (s *Server) ServeUI() {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("TrueNAS"))
+	}))
+	// failed handshakes are part of the tests, e.g. while no certificate is set
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return s.servedUICertificate()
+	}}
+	srv.StartTLS()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uiSrv = srv
+	s.uiHTTPSPort = srv.Listener.Addr().(*net.TCPAddr).Port
+	s.servedUICertID = s.uiCertID
+}
+
+// FreezeUI makes UI restarts keep presenting the certificate served so far,
+// like an nginx failing to load the new certificate.
+func (s *Server) FreezeUI() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uiFrozen = true
+}
+
+// reloadUI loads the UI certificate into the UI server, unless it is frozen.
+// Must be called with s.mu held.
+func (s *Server) reloadUI() {
+	if !s.uiFrozen {
+		s.servedUICertID = s.uiCertID
+	}
+}
+
+func (s *Server) servedUICertificate() (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.certs, func(c Certificate) bool { return c.ID == s.servedUICertID })
+	if i < 0 {
+		return nil, fmt.Errorf("ui certificate %d %w", s.servedUICertID, errNotFound)
+	}
+	cert, err := tls.X509KeyPair([]byte(s.certs[i].Certificate), []byte(s.certs[i].PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("ui certificate %d: %w", s.servedUICertID, err)
+	}
+
+	return &cert, nil
+}
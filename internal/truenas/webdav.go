@@ -0,0 +1,49 @@
+package truenas
+
+import "context"
+
+// WebDAV protocols the service can be configured with.
+const (
+	WebDAVHTTP      = "HTTP"
+	WebDAVHTTPS     = "HTTPS"
+	WebDAVHTTPHTTPS = "HTTPHTTPS"
+)
+
+// WebDAVEntry holds the configuration of the WebDAV service.
+type WebDAVEntry struct {
+	ID         int    `json:"id"`
+	Protocol   string `json:"protocol"`
+	TCPPort    int    `json:"tcpport"`
+	TCPPortSSL int    `json:"tcpportssl"`
+	// CertSSL is the ID of the certificate used for HTTPS, nil if none is set.
+	CertSSL *int `json:"certssl"`
+}
+
+// WebDAVUpdateParams holds parameters for updating the WebDAV service
+// configuration.
+type WebDAVUpdateParams struct {
+	Protocol *string `json:"protocol,omitempty"`
+	CertSSL  *int    `json:"certssl,omitempty"`
+}
+
+// WebDAVConfig returns the WebDAV service configuration.
+func (c *Client) WebDAVConfig(ctx context.Context) (*WebDAVEntry, error) {
+	var result *WebDAVEntry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.WebDAVConfig(ctx)
+		return err
+	})
+	return result, err
+}
+
+// WebDAVUpdate updates the WebDAV service configuration.
+func (c *Client) WebDAVUpdate(ctx context.Context, params WebDAVUpdateParams) (*WebDAVEntry, error) {
+	var result *WebDAVEntry
+	err := c.withReconnect(ctx, func() error {
+		var err error
+		result, err = c.a.WebDAVUpdate(ctx, params)
+		return err
+	})
+	return result, err
+}
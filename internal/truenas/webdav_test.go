@@ -0,0 +1,30 @@
+package truenas
+
+import "testing"
+
+func TestClient_WebDAVUpdate(t *testing.T) {
+	t.Parallel()
+
+	client, srv := newTestClient(t)
+	imported, err := client.CertificateImport(t.Context(), "acme-test", generateSelfSignedCert(t))
+	if err != nil {
+		t.Fatalf("CertificateImport() error = %v", err)
+	}
+
+	// HTTPS needs a certificate
+	protocol := WebDAVHTTPS
+	if _, err := client.WebDAVUpdate(t.Context(), WebDAVUpdateParams{Protocol: &protocol}); err == nil {
+		t.Error("WebDAVUpdate() without certssl succeeded")
+	}
+
+	entry, err := client.WebDAVUpdate(t.Context(), WebDAVUpdateParams{Protocol: &protocol, CertSSL: &imported.ID})
+	if err != nil {
+		t.Fatalf("WebDAVUpdate() error = %v", err)
+	}
+	if entry.CertSSL == nil || *entry.CertSSL != imported.ID || entry.Protocol != WebDAVHTTPS {
+		t.Errorf("WebDAVUpdate() = %+v, want certssl %d and protocol %s", entry, imported.ID, WebDAVHTTPS)
+	}
+	if got := srv.ServiceCertificate("webdav"); got != imported.ID {
+		t.Errorf("webdav certificate = %d, want %d", got, imported.ID)
+	}
+}